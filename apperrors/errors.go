@@ -0,0 +1,127 @@
+package apperrors
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Kind classifies an error so that transports can pick a status code
+// without knowing where the error came from.
+type Kind int
+
+const (
+	KindInternal Kind = iota
+	KindNotFound
+	KindValidation
+	KindConflict
+	KindUnauthorized
+	KindUnavailable
+	KindUnsupportedMediaType
+	KindPreconditionFailed
+	KindPreconditionRequired
+	KindUnprocessable
+	KindTooLarge
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindNotFound:
+		return "not_found"
+	case KindValidation:
+		return "validation_failed"
+	case KindConflict:
+		return "conflict"
+	case KindUnauthorized:
+		return "unauthorized"
+	case KindUnavailable:
+		return "unavailable"
+	case KindUnsupportedMediaType:
+		return "unsupported_media_type"
+	case KindPreconditionFailed:
+		return "precondition_failed"
+	case KindPreconditionRequired:
+		return "precondition_required"
+	case KindUnprocessable:
+		return "unprocessable"
+	case KindTooLarge:
+		return "too_large"
+	default:
+		return "internal"
+	}
+}
+
+// Error is the domain error returned by stores and services.
+type Error struct {
+	Kind    Kind
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil && e.Err.Error() != e.Message {
+		return fmt.Sprintf("%s: %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func NotFound(format string, args ...any) *Error {
+	return &Error{Kind: KindNotFound, Message: fmt.Sprintf(format, args...)}
+}
+
+func Validation(format string, args ...any) *Error {
+	return &Error{Kind: KindValidation, Message: fmt.Sprintf(format, args...)}
+}
+
+func Conflict(format string, args ...any) *Error {
+	return &Error{Kind: KindConflict, Message: fmt.Sprintf(format, args...)}
+}
+
+func Unauthorized(format string, args ...any) *Error {
+	return &Error{Kind: KindUnauthorized, Message: fmt.Sprintf(format, args...)}
+}
+
+func UnsupportedMediaType(format string, args ...any) *Error {
+	return &Error{Kind: KindUnsupportedMediaType, Message: fmt.Sprintf(format, args...)}
+}
+
+func PreconditionFailed(format string, args ...any) *Error {
+	return &Error{Kind: KindPreconditionFailed, Message: fmt.Sprintf(format, args...)}
+}
+
+func PreconditionRequired(format string, args ...any) *Error {
+	return &Error{Kind: KindPreconditionRequired, Message: fmt.Sprintf(format, args...)}
+}
+
+func Unprocessable(format string, args ...any) *Error {
+	return &Error{Kind: KindUnprocessable, Message: fmt.Sprintf(format, args...)}
+}
+
+func TooLarge(format string, args ...any) *Error {
+	return &Error{Kind: KindTooLarge, Message: fmt.Sprintf(format, args...)}
+}
+
+func Unavailable(err error) *Error {
+	return &Error{Kind: KindUnavailable, Message: "service temporarily unavailable", Err: err}
+}
+
+// Wrap attaches a kind to an existing error, keeping its message.
+func Wrap(kind Kind, err error) *Error {
+	return &Error{Kind: kind, Message: err.Error(), Err: err}
+}
+
+// KindOf reports the kind of the first *Error in err's chain, or KindInternal.
+func KindOf(err error) Kind {
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr.Kind
+	}
+	return KindInternal
+}
+
+func IsNotFound(err error) bool {
+	return KindOf(err) == KindNotFound
+}
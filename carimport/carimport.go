@@ -0,0 +1,203 @@
+// Package carimport reads the cars of a bulk import from CSV or NDJSON.
+// It only turns the file into rows; validating the cars is up to the
+// caller.
+package carimport
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"golangSecond/models"
+
+	"github.com/google/uuid"
+)
+
+// Columns are the CSV columns an import needs, in the order exports write
+// them. Their order in a file does not matter and other columns are
+// ignored, so that an export can be imported again.
+var Columns = []string{"name", "year", "brand", "fuel_type", "price", "engine_id"}
+
+// maxLine is the longest NDJSON line ReadNDJSON accepts.
+const maxLine = 1 << 20
+
+// Error reports a file that cannot be read at all, as opposed to a row
+// that is wrong. Line is 0 when the problem is not with one line.
+type Error struct {
+	Line int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	if e.Line == 0 {
+		return e.Msg
+	}
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// ReadCSV reads a CSV file whose first line names its columns. It fails if
+// the file holds more than maxRows rows. A quote starting a text cell is
+// dropped, as spreadsheets drop it: exports add one to text a spreadsheet
+// could take for a formula, such as a name starting with -.
+func ReadCSV(r io.Reader, maxRows int) ([]models.ImportRow, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, &Error{Msg: "the file has no header line"}
+	}
+	if err != nil {
+		return nil, csvError(err)
+	}
+	index, err := columnIndex(header)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []models.ImportRow
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) && errors.Is(parseErr.Err, csv.ErrFieldCount) {
+			rows = append(rows, models.ImportRow{Line: parseErr.StartLine, Errors: models.ValidationErrors{{
+				Field:   "row",
+				Code:    models.CodeInvalidFormat,
+				Message: fmt.Sprintf("row has %d fields, the header has %d", len(record), len(header)),
+			}}})
+		} else if err != nil {
+			return nil, csvError(err)
+		} else {
+			line, _ := reader.FieldPos(0)
+			rows = append(rows, csvRow(line, record, index))
+		}
+		if len(rows) > maxRows {
+			return nil, &Error{Msg: fmt.Sprintf("an import may hold at most %d rows", maxRows)}
+		}
+	}
+	return rows, nil
+}
+
+// columnIndex maps each of Columns to its position in header.
+func columnIndex(header []string) (map[string]int, error) {
+	index := make(map[string]int)
+	for i, name := range header {
+		if i == 0 {
+			name = strings.TrimPrefix(name, "\uFEFF")
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := index[name]; ok {
+			return nil, &Error{Line: 1, Msg: fmt.Sprintf("column %q appears more than once", name)}
+		}
+		index[name] = i
+	}
+	var missing []string
+	for _, column := range Columns {
+		if _, ok := index[column]; !ok {
+			missing = append(missing, column)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, &Error{Line: 1, Msg: "missing columns " + strings.Join(missing, ", ")}
+	}
+	return index, nil
+}
+
+func csvRow(line int, record []string, index map[string]int) models.ImportRow {
+	value := func(column string) string {
+		return strings.TrimSpace(record[index[column]])
+	}
+	text := func(column string) string {
+		return strings.TrimPrefix(value(column), "'")
+	}
+	row := models.ImportRow{
+		Line: line,
+		Car: models.CarRequest{
+			Name:     text("name"),
+			Year:     text("year"),
+			Brand:    text("brand"),
+			FuelType: text("fuel_type"),
+		},
+	}
+	if price := value("price"); price != "" {
+		var err error
+		if row.Car.Price, err = strconv.ParseFloat(price, 64); err != nil {
+			row.Errors = append(row.Errors, models.FieldError{Field: "price", Code: models.CodeInvalidFormat, Message: "price must be a number"})
+		}
+	}
+	if engineID := value("engine_id"); engineID != "" {
+		var err error
+		if row.Car.Engine.EngineID, err = uuid.Parse(engineID); err != nil {
+			row.Errors = append(row.Errors, models.FieldError{Field: "engine.engine_id", Code: models.CodeInvalidFormat, Message: "engine_id must be a UUID"})
+		}
+	}
+	return row
+}
+
+func csvError(err error) error {
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return &Error{Line: parseErr.Line, Msg: parseErr.Err.Error()}
+	}
+	return err
+}
+
+// ReadNDJSON reads a file with one car per line, in the shape POST /cars
+// takes. Blank lines are skipped. It fails if the file holds more than
+// maxRows rows.
+func ReadNDJSON(r io.Reader, maxRows int) ([]models.ImportRow, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLine)
+	var rows []models.ImportRow
+	for line := 1; scanner.Scan(); line++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		row := models.ImportRow{Line: line}
+		if err := json.Unmarshal(text, &row.Car); err != nil {
+			row.Errors = models.ValidationErrors{jsonError(err)}
+		}
+		rows = append(rows, row)
+		if len(rows) > maxRows {
+			return nil, &Error{Msg: fmt.Sprintf("an import may hold at most %d rows", maxRows)}
+		}
+	}
+	if errors.Is(scanner.Err(), bufio.ErrTooLong) {
+		return nil, &Error{Msg: fmt.Sprintf("a line is longer than %d bytes", maxLine)}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+func jsonError(err error) models.FieldError {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return models.FieldError{Field: typeErr.Field, Code: models.CodeInvalidFormat, Message: typeErr.Field + " must be " + jsonType(typeErr.Type)}
+	}
+	return models.FieldError{Field: "row", Code: models.CodeInvalidFormat, Message: "invalid JSON: " + err.Error()}
+}
+
+// jsonType names the kind of JSON value t is decoded from.
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Struct, reflect.Map:
+		return "an object"
+	}
+	return "a " + t.String()
+}
@@ -0,0 +1,144 @@
+package carimport
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"golangSecond/export"
+	"golangSecond/models"
+
+	"github.com/google/uuid"
+)
+
+const engineID = "9c9b0cf2-4404-4e96-8a81-58a5f0df2a9d"
+
+func TestReadCSV(t *testing.T) {
+	input := "\uFEFFid,Name,year,brand,fuel_type,price,engine_id\n" +
+		",Nexon,2021,Tata,Petrol,10000," + engineID + "\n" +
+		"\n" +
+		",\"Punch, Adventure\",2022,Tata,Petrol,abc,nope\n" +
+		",Tiago,2020,Tata\n"
+	rows, err := ReadCSV(strings.NewReader(input), 10)
+	if err != nil {
+		t.Fatalf("ReadCSV: %v", err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("ReadCSV returned %d rows, want 3", len(rows))
+	}
+
+	want := models.CarRequest{
+		Name:     "Nexon",
+		Year:     "2021",
+		Brand:    "Tata",
+		FuelType: "Petrol",
+		Engine:   models.Engine{EngineID: uuid.MustParse(engineID)},
+		Price:    10000,
+	}
+	if rows[0].Line != 2 || rows[0].Car != want || len(rows[0].Errors) != 0 {
+		t.Errorf("row 0 = %+v, want line 2 with %+v", rows[0], want)
+	}
+	if rows[1].Line != 4 || rows[1].Car.Name != "Punch, Adventure" {
+		t.Errorf("row 1 = %+v, want line 4 named Punch, Adventure", rows[1])
+	}
+	expectFields(t, "row 1", rows[1].Errors, "price", "engine.engine_id")
+	if rows[2].Line != 5 {
+		t.Errorf("row 2 line = %d, want 5", rows[2].Line)
+	}
+	expectFields(t, "row 2", rows[2].Errors, "row")
+}
+
+func TestReadCSVRoundTrip(t *testing.T) {
+	names := []string{"Nexon", "-X", "=Y", "'quoted", "''twice"}
+	var buf bytes.Buffer
+	w, err := export.NewWriter(export.CSV, &buf, Columns)
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	for _, name := range names {
+		if err := w.Write(export.Record{Cells: []any{name, "2021", "Tata", "Petrol", 10000.0, engineID}}); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	rows, err := ReadCSV(&buf, 10)
+	if err != nil {
+		t.Fatalf("ReadCSV: %v", err)
+	}
+	if len(rows) != len(names) {
+		t.Fatalf("ReadCSV returned %d rows, want %d", len(rows), len(names))
+	}
+	for i, name := range names {
+		if rows[i].Car.Name != name {
+			t.Errorf("row %d name = %q, want %q", i, rows[i].Car.Name, name)
+		}
+	}
+}
+
+func TestReadCSVErrors(t *testing.T) {
+	tests := []struct {
+		name, input, want string
+	}{
+		{"empty", "", "the file has no header line"},
+		{"missing columns", "name,year,brand\n", "line 1: missing columns fuel_type, price, engine_id"},
+		{"duplicate column", "name,name,year,brand,fuel_type,price,engine_id\n", `line 1: column "name" appears more than once`},
+		{"bad quote", "name,year,brand,fuel_type,price,engine_id\na,\"b\"c,d,e,f,g\n", "line 2: "},
+		{"too many rows", "name,year,brand,fuel_type,price,engine_id\na,b,c,d,e,f\na,b,c,d,e,f\na,b,c,d,e,f\n", "an import may hold at most 2 rows"},
+	}
+	for _, tt := range tests {
+		_, err := ReadCSV(strings.NewReader(tt.input), 2)
+		var importErr *Error
+		if !errors.As(err, &importErr) || !strings.HasPrefix(err.Error(), tt.want) {
+			t.Errorf("%s: ReadCSV error = %v, want one starting with %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestReadNDJSON(t *testing.T) {
+	input := `{"name":"Nexon","year":"2021","brand":"Tata","fuel_type":"Petrol","engine":{"engine_id":"` + engineID + `"},"price":10000}` + "\n" +
+		"\n" +
+		`{"name":"Punch","price":"cheap"}` + "\n" +
+		`{"name":` + "\n"
+	rows, err := ReadNDJSON(strings.NewReader(input), 10)
+	if err != nil {
+		t.Fatalf("ReadNDJSON: %v", err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("ReadNDJSON returned %d rows, want 3", len(rows))
+	}
+	if rows[0].Line != 1 || rows[0].Car.Name != "Nexon" || rows[0].Car.Engine.EngineID != uuid.MustParse(engineID) || len(rows[0].Errors) != 0 {
+		t.Errorf("row 0 = %+v, want line 1 with Nexon", rows[0])
+	}
+	if rows[1].Line != 3 {
+		t.Errorf("row 1 line = %d, want 3", rows[1].Line)
+	}
+	expectFields(t, "row 1", rows[1].Errors, "price")
+	if rows[1].Errors[0].Message != "price must be a number" {
+		t.Errorf("row 1 message = %q", rows[1].Errors[0].Message)
+	}
+	expectFields(t, "row 2", rows[2].Errors, "row")
+
+	_, err = ReadNDJSON(strings.NewReader("{}\n{}\n{}\n"), 2)
+	var importErr *Error
+	if !errors.As(err, &importErr) {
+		t.Errorf("ReadNDJSON past maxRows error = %v, want an *Error", err)
+	}
+}
+
+func expectFields(t *testing.T, op string, errs models.ValidationErrors, want ...string) {
+	t.Helper()
+	if len(errs) != len(want) {
+		t.Errorf("%s errors = %v, want fields %v", op, errs, want)
+		return
+	}
+	for i, field := range want {
+		if errs[i].Field != field {
+			t.Errorf("%s errors = %v, want fields %v", op, errs, want)
+			return
+		}
+	}
+}
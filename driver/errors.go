@@ -0,0 +1,48 @@
+package driver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"strings"
+
+	"golangSecond/apperrors"
+)
+
+// MapError translates database errors into domain errors so that callers do
+// not need to know about SQLSTATE codes. Errors it does not recognise are
+// returned unchanged and end up as internal errors.
+func MapError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var appErr *apperrors.Error
+	if errors.As(err, &appErr) {
+		return err
+	}
+
+	var stateErr interface{ SQLState() string }
+	if errors.As(err, &stateErr) {
+		code := stateErr.SQLState()
+		switch {
+		case code == "23505":
+			return &apperrors.Error{Kind: apperrors.KindConflict, Message: "resource already exists", Err: err}
+		case code == "23503":
+			return &apperrors.Error{Kind: apperrors.KindConflict, Message: "resource is referenced by or references a missing resource", Err: err}
+		case code == "23502", code == "23514", strings.HasPrefix(code, "22"):
+			return &apperrors.Error{Kind: apperrors.KindValidation, Message: "invalid value", Err: err}
+		case strings.HasPrefix(code, "08"), strings.HasPrefix(code, "53"), strings.HasPrefix(code, "57P"):
+			return apperrors.Unavailable(err)
+		}
+		return err
+	}
+
+	var netErr net.Error
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) {
+		return apperrors.Unavailable(err)
+	}
+	return err
+}
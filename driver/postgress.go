@@ -1 +1,168 @@
-package main
+package driver
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// Config holds everything needed to open and tune a Postgres connection pool.
+// When DSN is empty the connection string is built from the discrete fields.
+type Config struct {
+	DSN      string
+	Host     string
+	Port     string
+	User     string
+	Password string
+	Name     string
+	SSLMode  string
+
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+
+	ConnectRetries int
+	RetryBackoff   time.Duration
+	MaxBackoff     time.Duration
+}
+
+// ConfigFromEnv reads the database configuration from the environment.
+// DATABASE_URL wins over the DB_* variables when both are set.
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{
+		DSN:      os.Getenv("DATABASE_URL"),
+		Host:     getEnv("DB_HOST", "localhost"),
+		Port:     getEnv("DB_PORT", "5432"),
+		User:     getEnv("DB_USER", "postgres"),
+		Password: os.Getenv("DB_PASSWORD"),
+		Name:     getEnv("DB_NAME", "postgres"),
+		SSLMode:  getEnv("DB_SSLMODE", "disable"),
+	}
+
+	var err error
+	if cfg.MaxOpenConns, err = getEnvInt("DB_MAX_OPEN_CONNS", 25); err != nil {
+		return cfg, err
+	}
+	if cfg.MaxIdleConns, err = getEnvInt("DB_MAX_IDLE_CONNS", 25); err != nil {
+		return cfg, err
+	}
+	if cfg.ConnMaxLifetime, err = getEnvDuration("DB_CONN_MAX_LIFETIME", 30*time.Minute); err != nil {
+		return cfg, err
+	}
+	if cfg.ConnMaxIdleTime, err = getEnvDuration("DB_CONN_MAX_IDLE_TIME", 5*time.Minute); err != nil {
+		return cfg, err
+	}
+	if cfg.ConnectRetries, err = getEnvInt("DB_CONNECT_RETRIES", 5); err != nil {
+		return cfg, err
+	}
+	if cfg.RetryBackoff, err = getEnvDuration("DB_RETRY_BACKOFF", 500*time.Millisecond); err != nil {
+		return cfg, err
+	}
+	if cfg.MaxBackoff, err = getEnvDuration("DB_MAX_BACKOFF", 10*time.Second); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// ConnectionString returns the DSN handed to the postgres driver.
+func (c Config) ConnectionString() string {
+	if c.DSN != "" {
+		return c.DSN
+	}
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(c.User, c.Password),
+		Host:     c.Host + ":" + c.Port,
+		Path:     c.Name,
+		RawQuery: url.Values{"sslmode": {c.SSLMode}}.Encode(),
+	}
+	return u.String()
+}
+
+// Connect opens a pooled connection to Postgres and pings it, retrying with
+// exponential backoff until the database answers or the retries run out.
+// The returned close function should be called once the server has drained.
+func Connect(ctx context.Context, cfg Config) (*sql.DB, func() error, error) {
+	db, err := sql.Open("postgres", cfg.ConnectionString())
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening database: %w", err)
+	}
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	if err := pingWithRetry(ctx, db, cfg); err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+
+	closeDB := func() error {
+		log.Println("Closing database connection")
+		return db.Close()
+	}
+	return db, closeDB, nil
+}
+
+func pingWithRetry(ctx context.Context, db *sql.DB, cfg Config) error {
+	backoff := cfg.RetryBackoff
+	var err error
+	for attempt := 0; attempt <= cfg.ConnectRetries; attempt++ {
+		if err = db.PingContext(ctx); err == nil {
+			return nil
+		}
+		if attempt == cfg.ConnectRetries {
+			break
+		}
+		log.Printf("Database not ready (attempt %d/%d): %v, retrying in %s", attempt+1, cfg.ConnectRetries+1, err, backoff)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if cfg.MaxBackoff > 0 && backoff > cfg.MaxBackoff {
+			backoff = cfg.MaxBackoff
+		}
+	}
+	return fmt.Errorf("error connecting to database: %w", err)
+}
+
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return n, nil
+}
+
+func getEnvDuration(key string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return d, nil
+}
@@ -0,0 +1,103 @@
+package driver
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Querier is the part of *sql.DB and *sql.Tx that stores use, so that the
+// same query code runs inside and outside a transaction.
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+type txKey struct{}
+
+// txState is the transaction carried by a context, along with how deeply
+// WithinTx calls are nested in it.
+type txState struct {
+	tx    *sql.Tx
+	depth int
+}
+
+// TxManager is the Postgres implementation of store.TxManager. Any number of
+// managers may share a *sql.DB: the transaction travels in the context, not
+// in the manager.
+type TxManager struct {
+	db *sql.DB
+}
+
+func NewTxManager(db *sql.DB) *TxManager {
+	return &TxManager{
+		db: db,
+	}
+}
+
+// WithinTx runs fn in a transaction that is committed if fn returns nil and
+// rolled back otherwise, including when fn panics. A call made with a
+// context that already carries a transaction joins it through a savepoint,
+// so an inner failure only undoes the inner work and the outer caller
+// decides whether to carry on.
+func (m *TxManager) WithinTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if outer, ok := ctx.Value(txKey{}).(*txState); ok {
+		return withinSavepoint(ctx, outer, fn)
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return MapError(err)
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(context.WithValue(ctx, txKey{}, &txState{tx: tx})); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rbErr)
+		}
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return MapError(err)
+	}
+	return nil
+}
+
+func withinSavepoint(ctx context.Context, outer *txState, fn func(ctx context.Context) error) error {
+	inner := &txState{tx: outer.tx, depth: outer.depth + 1}
+	savepoint := fmt.Sprintf("sp_%d", inner.depth)
+	if _, err := outer.tx.ExecContext(ctx, "SAVEPOINT "+savepoint); err != nil {
+		return MapError(err)
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_, _ = outer.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+savepoint)
+			panic(p)
+		}
+	}()
+
+	if err := fn(context.WithValue(ctx, txKey{}, inner)); err != nil {
+		if _, rbErr := outer.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+savepoint); rbErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rbErr)
+		}
+		return err
+	}
+	if _, err := outer.tx.ExecContext(ctx, "RELEASE SAVEPOINT "+savepoint); err != nil {
+		return MapError(err)
+	}
+	return nil
+}
+
+// Conn returns the transaction carried by ctx, or db when there is none.
+func Conn(ctx context.Context, db *sql.DB) Querier {
+	if state, ok := ctx.Value(txKey{}).(*txState); ok {
+		return state.tx
+	}
+	return db
+}
@@ -0,0 +1,28 @@
+package driver
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"golangSecond/apperrors"
+)
+
+// MissingOrStale explains why a conditional write touched no row: either
+// the row with id is gone, or it is no longer at ifVersion. versionQuery
+// selects the version of the row with id $1, if it still counts as there;
+// kind names the row in the error, e.g. "car".
+func MissingOrStale(ctx context.Context, conn Querier, versionQuery, kind, id string, ifVersion int64) error {
+	var version int64
+	err := conn.QueryRowContext(ctx, versionQuery, id).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return apperrors.NotFound("%s %s not found", kind, id)
+	}
+	if err != nil {
+		return MapError(err)
+	}
+	if ifVersion == 0 || version == ifVersion {
+		return apperrors.NotFound("%s %s not found", kind, id)
+	}
+	return apperrors.PreconditionFailed("%s %s is at version %d, not %d", kind, id, version, ifVersion)
+}
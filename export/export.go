@@ -0,0 +1,140 @@
+// Package export writes records one at a time as CSV, NDJSON or XLSX, so
+// that exports of any size can be streamed with constant memory.
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Format is a file format records can be exported to.
+type Format string
+
+const (
+	CSV    Format = "csv"
+	NDJSON Format = "ndjson"
+	XLSX   Format = "xlsx"
+)
+
+// Formats lists every supported format.
+var Formats = []Format{CSV, NDJSON, XLSX}
+
+// ParseFormat parses a format name; an empty name means CSV.
+func ParseFormat(name string) (Format, bool) {
+	if name == "" {
+		return CSV, true
+	}
+	for _, format := range Formats {
+		if string(format) == strings.ToLower(name) {
+			return format, true
+		}
+	}
+	return "", false
+}
+
+// ContentType is the media type of files in the format.
+func (f Format) ContentType() string {
+	switch f {
+	case NDJSON:
+		return "application/x-ndjson"
+	case XLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// Record is one exported item. NDJSON writes Value as JSON; CSV and XLSX
+// write Cells, one per column. A cell is a string, an int64 or a float64.
+type Record struct {
+	Value any
+	Cells []any
+}
+
+// Writer writes records to an export. Close finishes the file and must be
+// called for it to be complete.
+type Writer interface {
+	Write(record Record) error
+	Close() error
+}
+
+// NewWriter starts an export in the given format, writing the header row
+// naming columns for the formats that have one.
+func NewWriter(format Format, w io.Writer, columns []string) (Writer, error) {
+	switch format {
+	case CSV:
+		return newCSVWriter(w, columns)
+	case NDJSON:
+		return &ndjsonWriter{enc: json.NewEncoder(w)}, nil
+	case XLSX:
+		return newXLSXWriter(w, columns)
+	}
+	return nil, fmt.Errorf("unknown export format %q", format)
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func newCSVWriter(w io.Writer, columns []string) (*csvWriter, error) {
+	cw := &csvWriter{w: csv.NewWriter(w)}
+	if err := cw.w.Write(columns); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+func (c *csvWriter) Write(record Record) error {
+	row := make([]string, len(record.Cells))
+	for i, cell := range record.Cells {
+		switch v := cell.(type) {
+		case string:
+			row[i] = defuse(v)
+		default:
+			row[i] = formatNumber(v)
+		}
+	}
+	return c.w.Write(row)
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// defuse keeps spreadsheets from taking a text cell for a formula, by
+// prefixing the characters that start one with a quote as OWASP suggests.
+// Text already starting with a quote gets one too, so that dropping the
+// first quote, as spreadsheets and carimport.ReadCSV do, always gives the
+// text back.
+func defuse(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r'", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func formatNumber(cell any) string {
+	switch v := cell.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(cell)
+}
+
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+func (n *ndjsonWriter) Write(record Record) error {
+	return n.enc.Encode(record.Value)
+}
+
+func (n *ndjsonWriter) Close() error {
+	return nil
+}
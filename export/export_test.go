@@ -0,0 +1,157 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+)
+
+var records = []Record{
+	{Value: map[string]any{"name": "Nexon"}, Cells: []any{"Nexon", int64(2021), 10000.5}},
+	{Value: map[string]any{"name": "=1+1"}, Cells: []any{"=1+1", int64(-3), float64(7)}},
+}
+
+func write(t *testing.T, format Format) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(format, &buf, []string{"name", "year", "price"})
+	if err != nil {
+		t.Fatalf("NewWriter(%s): %v", format, err)
+	}
+	for _, record := range records {
+		if err := w.Write(record); err != nil {
+			t.Fatalf("Write(%s): %v", format, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close(%s): %v", format, err)
+	}
+	return buf.Bytes()
+}
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		want Format
+		ok   bool
+	}{
+		{"", CSV, true},
+		{"csv", CSV, true},
+		{"NDJSON", NDJSON, true},
+		{"xlsx", XLSX, true},
+		{"xls", "", false},
+	}
+	for _, tt := range tests {
+		if got, ok := ParseFormat(tt.name); got != tt.want || ok != tt.ok {
+			t.Errorf("ParseFormat(%q) = %q, %v, want %q, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestCSV(t *testing.T) {
+	got := string(write(t, CSV))
+	want := "name,year,price\nNexon,2021,10000.5\n'=1+1,-3,7\n"
+	if got != want {
+		t.Errorf("CSV export = %q, want %q", got, want)
+	}
+}
+
+func TestDefuse(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"Nexon", "Nexon"},
+		{"", ""},
+		{"=Y", "'=Y"},
+		{"-X", "'-X"},
+		{"+1", "'+1"},
+		{"@sum", "'@sum"},
+		{"'quoted", "''quoted"},
+		{"a=b", "a=b"},
+	}
+	for _, tt := range tests {
+		if got := defuse(tt.in); got != tt.want {
+			t.Errorf("defuse(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNDJSON(t *testing.T) {
+	got := string(write(t, NDJSON))
+	want := `{"name":"Nexon"}` + "\n" + `{"name":"=1+1"}` + "\n"
+	if got != want {
+		t.Errorf("NDJSON export = %q, want %q", got, want)
+	}
+}
+
+func TestXLSX(t *testing.T) {
+	data := write(t, XLSX)
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("XLSX export is not a zip archive: %v", err)
+	}
+	parts := make(map[string]string)
+	for _, f := range archive.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts[f.Name] = string(body)
+	}
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/worksheets/sheet1.xml"} {
+		body, ok := parts[name]
+		if !ok {
+			t.Errorf("XLSX export has no %s", name)
+			continue
+		}
+		dec := xml.NewDecoder(strings.NewReader(body))
+		for {
+			_, err := dec.Token()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Errorf("%s is not well-formed XML: %v", name, err)
+				break
+			}
+		}
+	}
+
+	var sheet struct {
+		Rows []struct {
+			R     int `xml:"r,attr"`
+			Cells []struct {
+				Type   string `xml:"t,attr"`
+				Text   string `xml:"is>t"`
+				Number string `xml:"v"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	if err := xml.Unmarshal([]byte(parts["xl/worksheets/sheet1.xml"]), &sheet); err != nil {
+		t.Fatalf("sheet1.xml: %v", err)
+	}
+	want := [][]string{{"name", "year", "price"}, {"Nexon", "2021", "10000.5"}, {"=1+1", "-3", "7"}}
+	if len(sheet.Rows) != len(want) {
+		t.Fatalf("sheet has %d rows, want %d", len(sheet.Rows), len(want))
+	}
+	for i, row := range sheet.Rows {
+		if row.R != i+1 || len(row.Cells) != len(want[i]) {
+			t.Fatalf("row %d = %+v, want %v", i, row, want[i])
+		}
+		for j, cell := range row.Cells {
+			got := cell.Number
+			if cell.Type == "inlineStr" {
+				got = cell.Text
+			}
+			if got != want[i][j] {
+				t.Errorf("cell %d,%d = %q, want %q", i, j, got, want[i][j])
+			}
+		}
+	}
+}
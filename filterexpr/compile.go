@@ -0,0 +1,233 @@
+package filterexpr
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"golangSecond/store/sqlbuilder"
+)
+
+// Type is the value type of a field or literal.
+type Type int
+
+const (
+	String Type = iota
+	Number
+)
+
+func (t Type) String() string {
+	if t == Number {
+		return "number"
+	}
+	return "string"
+}
+
+// Fields maps the field names an expression may use to their types.
+type Fields map[string]Type
+
+// Check reports unknown fields and type mismatches as syntax errors.
+func (e *Expr) Check(fields Fields) error {
+	return check(e.root, fields)
+}
+
+// References reports whether the expression uses a field for which ref
+// returns true, e.g. to tell whether it needs a join.
+func (e *Expr) References(ref func(field string) bool) bool {
+	return references(e.root, ref)
+}
+
+func references(n node, ref func(field string) bool) bool {
+	switch n := n.(type) {
+	case logicalNode:
+		return references(n.left, ref) || references(n.right, ref)
+	case notNode:
+		return references(n.inner, ref)
+	case compareNode:
+		return ref(n.field)
+	case inNode:
+		return ref(n.field)
+	}
+	return false
+}
+
+func check(n node, fields Fields) error {
+	switch n := n.(type) {
+	case logicalNode:
+		if err := check(n.left, fields); err != nil {
+			return err
+		}
+		return check(n.right, fields)
+	case notNode:
+		return check(n.inner, fields)
+	case compareNode:
+		typ, err := fieldType(fields, n.field, n.pos)
+		if err != nil {
+			return err
+		}
+		if n.op == "contains" && typ != String {
+			return &SyntaxError{Pos: n.pos, Msg: fmt.Sprintf("contains needs a string field, %s is a %s", n.field, typ)}
+		}
+		return checkLiteral(n.field, typ, n.value)
+	case inNode:
+		typ, err := fieldType(fields, n.field, n.pos)
+		if err != nil {
+			return err
+		}
+		for _, value := range n.values {
+			if err := checkLiteral(n.field, typ, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func fieldType(fields Fields, field string, pos int) (Type, error) {
+	typ, ok := fields[field]
+	if !ok {
+		names := make([]string, 0, len(fields))
+		for name := range fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return 0, &SyntaxError{Pos: pos, Msg: fmt.Sprintf("unknown field %q, expected one of %s", field, strings.Join(names, ", "))}
+	}
+	return typ, nil
+}
+
+func checkLiteral(field string, typ Type, value literal) error {
+	if value.typ != typ {
+		return &SyntaxError{Pos: value.pos, Msg: fmt.Sprintf("%s is a %s field but was compared with a %s", field, typ, value.typ)}
+	}
+	return nil
+}
+
+// AddTo compiles the expression into a condition on where. columns maps
+// each field to its SQL expression; every value is bound as a parameter.
+func (e *Expr) AddTo(where *sqlbuilder.Where, columns map[string]string) error {
+	clause, err := toSQL(e.root, where, columns)
+	if err != nil {
+		return err
+	}
+	where.AddRaw(clause)
+	return nil
+}
+
+func toSQL(n node, where *sqlbuilder.Where, columns map[string]string) (string, error) {
+	switch n := n.(type) {
+	case logicalNode:
+		left, err := toSQL(n.left, where, columns)
+		if err != nil {
+			return "", err
+		}
+		right, err := toSQL(n.right, where, columns)
+		if err != nil {
+			return "", err
+		}
+		return "(" + left + " " + strings.ToUpper(n.op) + " " + right + ")", nil
+	case notNode:
+		inner, err := toSQL(n.inner, where, columns)
+		if err != nil {
+			return "", err
+		}
+		return "NOT " + inner, nil
+	case compareNode:
+		column, ok := columns[n.field]
+		if !ok {
+			return "", fmt.Errorf("no column for field %q", n.field)
+		}
+		if n.op == "contains" {
+			return column + " ILIKE " + where.Arg("%"+sqlbuilder.EscapeLike(n.value.str)+"%") + ` ESCAPE '\'`, nil
+		}
+		return "(" + column + " " + n.op + " " + bind(where, n.value) + ")", nil
+	case inNode:
+		column, ok := columns[n.field]
+		if !ok {
+			return "", fmt.Errorf("no column for field %q", n.field)
+		}
+		placeholders := make([]string, len(n.values))
+		for i, value := range n.values {
+			placeholders[i] = bind(where, value)
+		}
+		op := " IN "
+		if n.negate {
+			op = " NOT IN "
+		}
+		return "(" + column + op + "(" + strings.Join(placeholders, ", ") + "))", nil
+	}
+	return "", fmt.Errorf("unknown expression node %T", n)
+}
+
+// bind casts numbers explicitly so that fractional values compared with
+// integer columns are not rejected by Postgres.
+func bind(where *sqlbuilder.Where, value literal) string {
+	if value.typ == Number {
+		return where.Arg(value.num) + "::numeric"
+	}
+	return where.Arg(value.str)
+}
+
+// Eval evaluates the expression against a record. lookup returns the value
+// of a field as a string or a float64.
+func (e *Expr) Eval(lookup func(field string) any) bool {
+	return eval(e.root, lookup)
+}
+
+func eval(n node, lookup func(field string) any) bool {
+	switch n := n.(type) {
+	case logicalNode:
+		if n.op == "and" {
+			return eval(n.left, lookup) && eval(n.right, lookup)
+		}
+		return eval(n.left, lookup) || eval(n.right, lookup)
+	case notNode:
+		return !eval(n.inner, lookup)
+	case compareNode:
+		return compare(lookup(n.field), n.op, n.value)
+	case inNode:
+		value := lookup(n.field)
+		for _, candidate := range n.values {
+			if compare(value, "=", candidate) {
+				return !n.negate
+			}
+		}
+		return n.negate
+	}
+	return false
+}
+
+func compare(value any, op string, lit literal) bool {
+	var c int
+	switch v := value.(type) {
+	case string:
+		if op == "contains" {
+			return strings.Contains(strings.ToLower(v), strings.ToLower(lit.str))
+		}
+		c = strings.Compare(v, lit.str)
+	case float64:
+		switch {
+		case v < lit.num:
+			c = -1
+		case v > lit.num:
+			c = 1
+		}
+	default:
+		return false
+	}
+	switch op {
+	case "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package filterexpr
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golangSecond/store/sqlbuilder"
+)
+
+var testFields = Fields{
+	"name":            String,
+	"fuel_type":       String,
+	"price":           Number,
+	"engine.carRange": Number,
+}
+
+func TestEval(t *testing.T) {
+	record := map[string]any{
+		"name":            "Nexon EV",
+		"fuel_type":       "Electric",
+		"price":           25000.0,
+		"engine.carRange": 450.0,
+	}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`fuel_type in ("Electric","Hybrid") and price < 30000 and engine.carRange >= 400`, true},
+		{`fuel_type in ('Petrol', 'Diesel')`, false},
+		{`fuel_type not in ("Petrol")`, true},
+		{`price = 25000 and not (engine.carRange > 450)`, true},
+		{`price > 30000 or name contains "ev"`, true},
+		{`price >= 25000.5 OR fuel_type == "Electric" AND price <> 1`, true},
+		{`name != "Nexon EV"`, false},
+	}
+	for _, tt := range tests {
+		expr, err := Parse(tt.expr)
+		if err == nil {
+			err = expr.Check(testFields)
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.expr, err)
+		}
+		if got := expr.Eval(func(field string) any { return record[field] }); got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestSyntaxErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		pos  int
+	}{
+		{`price <`, 7},
+		{`price < 10 and`, 14},
+		{`fuel_type in ("Electric" "Hybrid")`, 25},
+		{`fuel_type in ()`, 14},
+		{`name = "unterminated`, 7},
+		{`(price > 1`, 10},
+		{`price ! 1`, 6},
+		{`price > 1 price`, 10},
+		{`and = 1`, 0},
+		{`price ~ 1`, 6},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.expr)
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("%s: expected a syntax error, got %v", tt.expr, err)
+			continue
+		}
+		if syntaxErr.Pos != tt.pos {
+			t.Errorf("%s: error at %d, want %d (%v)", tt.expr, syntaxErr.Pos, tt.pos, err)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	for _, input := range []string{
+		`color = "red"`,
+		`PRICE > 1`,
+		`price = "cheap"`,
+		`name > 5`,
+		`price contains "1"`,
+		`fuel_type in ("Electric", 3)`,
+	} {
+		expr, err := Parse(input)
+		if err != nil {
+			t.Fatalf("%s: %v", input, err)
+		}
+		if err := expr.Check(testFields); err == nil {
+			t.Errorf("%s: expected a check error", input)
+		}
+	}
+}
+
+func TestAddTo(t *testing.T) {
+	expr, err := Parse(`fuel_type in ("Electric","Hybrid") and (price < 30000 or not name contains "50%")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var where sqlbuilder.Where
+	where.Add("c.brand = ?", "Tata")
+	columns := map[string]string{"fuel_type": "c.fuel_type", "price": "c.price", "name": "c.name"}
+	if err := expr.AddTo(&where, columns); err != nil {
+		t.Fatal(err)
+	}
+
+	wantSQL := `where c.brand = $1 AND ((c.fuel_type IN ($2, $3)) AND ((c.price < $4::numeric) OR NOT c.name ILIKE $5 ESCAPE '\'))`
+	if got := where.SQL(); got != wantSQL {
+		t.Errorf("SQL =\n%s\nwant\n%s", got, wantSQL)
+	}
+	wantArgs := []any{"Tata", "Electric", "Hybrid", 30000.0, `%50\%%`}
+	if got := where.Args(); !reflect.DeepEqual(got, wantArgs) {
+		t.Errorf("Args = %#v, want %#v", got, wantArgs)
+	}
+}
+
+func TestReferences(t *testing.T) {
+	isEngine := func(field string) bool { return strings.HasPrefix(field, "engine.") }
+	for input, want := range map[string]bool{
+		`price < 10`: false,
+		`name = "a" or (price > 1 and not engine.carRange > 300)`: true,
+		`fuel_type in ("Electric")`:                               false,
+	} {
+		expr, err := Parse(input)
+		if err != nil {
+			t.Fatalf("%s: %v", input, err)
+		}
+		if got := expr.References(isEngine); got != want {
+			t.Errorf("%s: References = %v, want %v", input, got, want)
+		}
+	}
+}
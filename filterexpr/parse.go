@@ -0,0 +1,361 @@
+// Package filterexpr parses filter expressions such as
+//
+//	fuel_type in ("Electric", "Hybrid") and price < 30000 and engine.carRange >= 400
+//
+// and compiles them into parameterized SQL or evaluates them in memory.
+//
+// Grammar:
+//
+//	expr       = and { "or" and }
+//	and        = unary { "and" unary }
+//	unary      = "not" unary | "(" expr ")" | comparison
+//	comparison = field op value
+//	           | field [ "not" ] "in" "(" value { "," value } ")"
+//	           | field "contains" string
+//	op         = "=" | "==" | "!=" | "<>" | "<" | "<=" | ">" | ">="
+//	value      = string | number
+//
+// Keywords are case-insensitive; strings use single or double quotes.
+package filterexpr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// SyntaxError reports where in the input parsing failed.
+type SyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at position %d: %s", e.Pos+1, e.Msg)
+}
+
+// Expr is a parsed expression.
+type Expr struct {
+	source string
+	root   node
+}
+
+func (e *Expr) String() string {
+	return e.source
+}
+
+// Parse parses input into an Expr. Field names are not checked until
+// Check is called with a schema.
+func Parse(input string) (*Expr, error) {
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, &SyntaxError{Pos: tok.pos, Msg: fmt.Sprintf("unexpected %s", tok)}
+	}
+	return &Expr{source: input, root: root}, nil
+}
+
+type node interface{}
+
+type logicalNode struct {
+	op          string // "and" or "or"
+	left, right node
+}
+
+type notNode struct {
+	inner node
+}
+
+type compareNode struct {
+	field string
+	op    string
+	value literal
+	pos   int
+}
+
+type inNode struct {
+	field  string
+	values []literal
+	negate bool
+	pos    int
+}
+
+type literal struct {
+	typ Type
+	str string
+	num float64
+	pos int
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of input"
+	case tokString:
+		return fmt.Sprintf("string %q", t.text)
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+func (t token) isKeyword(keyword string) bool {
+	return t.kind == tokIdent && strings.EqualFold(t.text, keyword)
+}
+
+func lex(input string) ([]token, error) {
+	var tokens []token
+	runes := []rune(input)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{kind: tokLParen, text: "(", pos: i})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{kind: tokRParen, text: ")", pos: i})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{kind: tokComma, text: ",", pos: i})
+			i++
+		case r == '"' || r == '\'':
+			start := i
+			var b strings.Builder
+			i++
+			for ; i < len(runes) && runes[i] != r; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				b.WriteRune(runes[i])
+			}
+			if i >= len(runes) {
+				return nil, &SyntaxError{Pos: start, Msg: "unterminated string"}
+			}
+			i++
+			tokens = append(tokens, token{kind: tokString, text: b.String(), pos: start})
+		case strings.ContainsRune("=!<>", r):
+			start := i
+			i++
+			if i < len(runes) && (runes[i] == '=' || (r == '<' && runes[i] == '>')) {
+				i++
+			}
+			op := string(runes[start:i])
+			if op == "!" {
+				return nil, &SyntaxError{Pos: start, Msg: `expected "!="`}
+			}
+			tokens = append(tokens, token{kind: tokOp, text: op, pos: start})
+		case r == '-' || r == '.' || unicode.IsDigit(r):
+			start := i
+			i++
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: string(runes[start:i]), pos: start})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_' || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: string(runes[start:i]), pos: start})
+		default:
+			return nil, &SyntaxError{Pos: i, Msg: fmt.Sprintf("unexpected character %q", r)}
+		}
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(runes)}), nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) expect(kind tokenKind, what string) (token, error) {
+	tok := p.next()
+	if tok.kind != kind {
+		return tok, &SyntaxError{Pos: tok.pos, Msg: fmt.Sprintf("expected %s, found %s", what, tok)}
+	}
+	return tok, nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().isKeyword("or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalNode{op: "or", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().isKeyword("and") {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalNode{op: "and", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	tok := p.peek()
+	switch {
+	case tok.isKeyword("not"):
+		p.next()
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{inner: inner}, nil
+	case tok.kind == tokLParen:
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokRParen, `")"`); err != nil {
+			return nil, err
+		}
+		return inner, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	field, err := p.expect(tokIdent, "field name")
+	if err != nil {
+		return nil, err
+	}
+	if isKeyword(field.text) {
+		return nil, &SyntaxError{Pos: field.pos, Msg: fmt.Sprintf("expected field name, found %s", field)}
+	}
+
+	tok := p.next()
+	switch {
+	case tok.kind == tokOp:
+		value, err := p.parseLiteral()
+		if err != nil {
+			return nil, err
+		}
+		op := tok.text
+		switch op {
+		case "==":
+			op = "="
+		case "<>":
+			op = "!="
+		}
+		return compareNode{field: field.text, op: op, value: value, pos: field.pos}, nil
+	case tok.isKeyword("contains"):
+		value, err := p.parseLiteral()
+		if err != nil {
+			return nil, err
+		}
+		return compareNode{field: field.text, op: "contains", value: value, pos: field.pos}, nil
+	case tok.isKeyword("not"):
+		if !p.peek().isKeyword("in") {
+			return nil, &SyntaxError{Pos: p.peek().pos, Msg: fmt.Sprintf(`expected "in", found %s`, p.peek())}
+		}
+		p.next()
+		return p.parseIn(field, true)
+	case tok.isKeyword("in"):
+		return p.parseIn(field, false)
+	}
+	return nil, &SyntaxError{Pos: tok.pos, Msg: fmt.Sprintf("expected operator after %s, found %s", field, tok)}
+}
+
+func (p *parser) parseIn(field token, negate bool) (node, error) {
+	if _, err := p.expect(tokLParen, `"("`); err != nil {
+		return nil, err
+	}
+	var values []literal
+	for {
+		value, err := p.parseLiteral()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		tok := p.next()
+		if tok.kind == tokRParen {
+			break
+		}
+		if tok.kind != tokComma {
+			return nil, &SyntaxError{Pos: tok.pos, Msg: fmt.Sprintf(`expected "," or ")", found %s`, tok)}
+		}
+	}
+	return inNode{field: field.text, values: values, negate: negate, pos: field.pos}, nil
+}
+
+func (p *parser) parseLiteral() (literal, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokString:
+		return literal{typ: String, str: tok.text, pos: tok.pos}, nil
+	case tokNumber:
+		num, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return literal{}, &SyntaxError{Pos: tok.pos, Msg: fmt.Sprintf("invalid number %q", tok.text)}
+		}
+		return literal{typ: Number, num: num, pos: tok.pos}, nil
+	}
+	return literal{}, &SyntaxError{Pos: tok.pos, Msg: fmt.Sprintf("expected a string or number, found %s", tok)}
+}
+
+func isKeyword(s string) bool {
+	switch strings.ToLower(s) {
+	case "and", "or", "not", "in", "contains":
+		return true
+	}
+	return false
+}
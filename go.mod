@@ -4,4 +4,7 @@ go 1.22.0
 
 require github.com/google/uuid v1.6.0
 
-require github.com/gorilla/mux v1.8.1 // indirect
+require (
+	github.com/gorilla/mux v1.8.1
+	github.com/lib/pq v1.12.3
+)
@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"golangSecond/models"
+	"golangSecond/service"
+)
+
+// ActorHeader names who a request acts for in the audit trail. The service
+// does not authenticate callers; it records what they claim.
+const ActorHeader = "X-Actor"
+
+// AnonymousActor is recorded for requests without an ActorHeader.
+const AnonymousActor = "anonymous"
+
+// WithActor is middleware recording the changes a request makes as made by
+// the actor named in its ActorHeader.
+func WithActor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor := strings.TrimSpace(r.Header.Get(ActorHeader))
+		if actor == "" {
+			actor = AnonymousActor
+		}
+		next.ServeHTTP(w, r.WithContext(service.WithActor(r.Context(), actor)))
+	})
+}
+
+// ParseHistoryOptions reads the paging parameters of a history request.
+func ParseHistoryOptions(r *http.Request) (models.AuditListOptions, error) {
+	query := r.URL.Query()
+	limit, err := ParseLimit(query)
+	if err != nil {
+		return models.AuditListOptions{}, err
+	}
+	return models.AuditListOptions{Limit: limit, Cursor: query.Get("cursor")}, nil
+}
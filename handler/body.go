@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"golangSecond/apperrors"
+)
+
+// MaxBodyBytes is the largest request body accepted, enough for an import
+// of models.MaxImportRows rows.
+const MaxBodyBytes = 32 << 20
+
+// LimitBody is middleware failing reads past MaxBodyBytes of a request body
+// with an *http.MaxBytesError.
+func LimitBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, MaxBodyBytes)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// BodyError is the error reported for a request body that could not be
+// read: too large when it went past MaxBodyBytes, invalid otherwise.
+func BodyError(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return apperrors.TooLarge("request body is larger than %d bytes", tooLarge.Limit)
+	}
+	return apperrors.Validation("invalid request body: %v", err)
+}
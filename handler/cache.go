@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// WriteCacheable answers a GET for a single resource with its validators
+// and the configured Cache-Control. A request whose If-None-Match or
+// If-Modified-Since shows it already holds this representation gets 304
+// Not Modified without a body. A zero lastModified leaves Last-Modified out.
+func (o Options) WriteCacheable(w http.ResponseWriter, r *http.Request, etag string, lastModified time.Time, v any) {
+	header := w.Header()
+	header.Set("ETag", etag)
+	if !lastModified.IsZero() {
+		header.Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+	if o.CacheControl != "" {
+		header.Set("Cache-Control", o.CacheControl)
+	}
+	if notModified(r, etag, lastModified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	WriteJSON(w, http.StatusOK, v)
+}
+
+// notModified evaluates If-None-Match, or If-Modified-Since when there is no
+// If-None-Match, as RFC 9110 orders them.
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if values := r.Header.Values("If-None-Match"); len(values) > 0 {
+		for _, value := range values {
+			for _, tag := range strings.Split(value, ",") {
+				tag = strings.TrimSpace(tag)
+				// If-None-Match uses weak comparison.
+				if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	if lastModified.IsZero() {
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	// HTTP dates have whole seconds.
+	return !lastModified.Truncate(time.Second).After(since)
+}
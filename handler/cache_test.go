@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWriteCacheable(t *testing.T) {
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 500, time.UTC)
+	before := modified.Add(-time.Hour).Format(http.TimeFormat)
+	after := modified.Add(time.Hour).Format(http.TimeFormat)
+
+	tests := []struct {
+		name   string
+		header map[string]string
+		want   int
+	}{
+		{"no validators", nil, http.StatusOK},
+		{"matching If-None-Match", map[string]string{"If-None-Match": `"3"`}, http.StatusNotModified},
+		{"one of several If-None-Match tags", map[string]string{"If-None-Match": `"1", "3"`}, http.StatusNotModified},
+		{"weak If-None-Match", map[string]string{"If-None-Match": `W/"3"`}, http.StatusNotModified},
+		{"any If-None-Match", map[string]string{"If-None-Match": "*"}, http.StatusNotModified},
+		{"other If-None-Match", map[string]string{"If-None-Match": `"2"`}, http.StatusOK},
+		{"If-Modified-Since after the change", map[string]string{"If-Modified-Since": after}, http.StatusNotModified},
+		{"If-Modified-Since in the second of the change", map[string]string{"If-Modified-Since": modified.Format(http.TimeFormat)}, http.StatusNotModified},
+		{"If-Modified-Since before the change", map[string]string{"If-Modified-Since": before}, http.StatusOK},
+		{"invalid If-Modified-Since", map[string]string{"If-Modified-Since": "yesterday"}, http.StatusOK},
+		{"If-None-Match over a later If-Modified-Since", map[string]string{"If-None-Match": `"2"`, "If-Modified-Since": after}, http.StatusOK},
+		{"If-None-Match over an earlier If-Modified-Since", map[string]string{"If-None-Match": `"3"`, "If-Modified-Since": before}, http.StatusNotModified},
+	}
+	opts := Options{CacheControl: "no-cache"}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/engines/1", nil)
+			for name, value := range tc.header {
+				r.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+			opts.WriteCacheable(w, r, `"3"`, modified, map[string]int{"version": 3})
+
+			if w.Code != tc.want {
+				t.Fatalf("status = %d, want %d", w.Code, tc.want)
+			}
+			if tc.want == http.StatusNotModified && w.Body.Len() != 0 {
+				t.Errorf("304 body = %q, want none", w.Body)
+			}
+			if tc.want == http.StatusOK && w.Body.Len() == 0 {
+				t.Error("200 without a body")
+			}
+			if got := w.Header().Get("ETag"); got != `"3"` {
+				t.Errorf("ETag = %s, want \"3\"", got)
+			}
+			if got := w.Header().Get("Last-Modified"); got != modified.Format(http.TimeFormat) {
+				t.Errorf("Last-Modified = %q, want %q", got, modified.Format(http.TimeFormat))
+			}
+			if got := w.Header().Get("Cache-Control"); got != "no-cache" {
+				t.Errorf("Cache-Control = %q, want no-cache", got)
+			}
+		})
+	}
+}
+
+func TestWriteCacheableWithoutLastModified(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/engines/1", nil)
+	r.Header.Set("If-Modified-Since", time.Now().Format(http.TimeFormat))
+	w := httptest.NewRecorder()
+	Options{}.WriteCacheable(w, r, `"3"`, time.Time{}, struct{}{})
+
+	if w.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200: If-Modified-Since means nothing without Last-Modified", w.Code)
+	}
+	if got := w.Header().Values("Last-Modified"); len(got) != 0 {
+		t.Errorf("Last-Modified = %q, want none", got)
+	}
+	if got := w.Header().Values("Cache-Control"); len(got) != 0 {
+		t.Errorf("Cache-Control = %q, want none when not configured", got)
+	}
+}
@@ -2,175 +2,424 @@ package car
 
 import (
 	"encoding/json"
+	"errors"
+	"golangSecond/apperrors"
+	"golangSecond/carimport"
+	"golangSecond/export"
+	"golangSecond/handler"
 	"golangSecond/models"
 	"golangSecond/service"
 	"io"
-	"log"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
 type CarHandler struct {
 	service service.CarServiceInterface
+	opts    handler.Options
 }
 
-func NewCarHandler(service service.CarServiceInterface) *CarHandler {
+func NewCarHandler(service service.CarServiceInterface, opts handler.Options) *CarHandler {
 	return &CarHandler{
 		service: service,
+		opts:    opts,
 	}
 }
 
+// GetCarByID serves GET /cars/{id}, and with ?as_of=<RFC3339> the car as
+// it was at that moment. Like every car read it takes ?fields= and
+// ?include=, see parseCarFields.
 func (h *CarHandler) GetCarByID(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	vars := mux.Vars(r)
 	id := vars["id"]
-	res, err := h.service.GetCarByID(ctx, id)
+	fields, err := parseCarFields(r, true)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Println("Error :", err)
+		handler.WriteError(w, err)
 		return
 	}
-	body, err := json.Marshal(res)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Println("Error :", err)
+	if value := r.URL.Query().Get("as_of"); value != "" {
+		at, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			handler.WriteError(w, apperrors.Validation("invalid as_of %q, expected an RFC 3339 time", value))
+			return
+		}
+		car, err := h.service.GetCarAsOf(ctx, id, at, fields)
+		if err != nil {
+			handler.WriteError(w, err)
+			return
+		}
+		handler.WriteTagged(w, http.StatusOK, carETag(car), car)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(body)
-	// Write response body here
-	_, err = w.Write(body)
+	res, err := h.service.GetCarByID(ctx, id, fields)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Println("Errore Writting response:", err)
+		handler.WriteError(w, err)
 		return
 	}
+	h.opts.WriteCacheable(w, r, carETag(res), carLastModified(res), res)
+}
 
+// GetCarsByIDs serves GET /cars?ids=a,b,c with the cars asked for, in that
+// order, and the ids of those that do not exist under "missing".
+func (h *CarHandler) GetCarsByIDs(w http.ResponseWriter, r *http.Request) {
+	var ids []string
+	for _, id := range strings.Split(r.URL.Query().Get("ids"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	fields, err := parseCarFields(r, true)
+	if err != nil {
+		handler.WriteError(w, err)
+		return
+	}
+	batch, err := h.service.GetCarsByIDs(r.Context(), ids, fields)
+	if err != nil {
+		handler.WriteError(w, err)
+		return
+	}
+	handler.WriteJSON(w, http.StatusOK, batch)
 }
+
+// GetCarByBrand serves GET /cars?brand=. It leaves out engines unless asked
+// for with ?include=engine or, as before ?include= existed, ?isEngine=true.
 func (h *CarHandler) GetCarByBrand(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	brand := r.URL.Query().Get("brand")
 	isEngine := r.URL.Query().Get("isEngine") == "true"
-	resp, err := h.service.GetCarByBrand(ctx, brand, isEngine)
+	fields, err := parseCarFields(r, isEngine)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Println("Error :", err)
+		handler.WriteError(w, err)
 		return
 	}
-	body, err := json.Marshal(resp)
+	resp, err := h.service.GetCarByBrand(ctx, brand, fields)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Println("Error :", err)
+		handler.WriteError(w, err)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(body)
-	// Write response body here
-	_, err = w.Write(body)
+	handler.WriteJSON(w, http.StatusOK, resp)
+}
+
+// ListCars serves GET /cars?limit=20&sort=-price,name&cursor=... along with
+// the filters understood by parseCarFilter and a filter expression in
+// ?filter=, e.g. `fuel_type in ("Electric","Hybrid") and price < 30000`.
+func (h *CarHandler) ListCars(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseCarListOptions(r)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Println("Errore Writting response:", err)
+		handler.WriteError(w, err)
+		return
+	}
+	if opts.Fields, err = parseCarFields(r, true); err != nil {
+		handler.WriteError(w, err)
 		return
 	}
+	page, err := h.service.ListCars(r.Context(), opts)
+	if err != nil {
+		handler.WriteError(w, err)
+		return
+	}
+	handler.WriteJSON(w, http.StatusOK, page)
 }
 
-func (h *CarHandler) CreateCar(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	body, err := io.ReadAll(r.Body)
+// ExportCars serves GET /cars/export?format=csv|ndjson|xlsx with the same
+// sort and filters as ListCars, streaming every matching car.
+func (h *CarHandler) ExportCars(w http.ResponseWriter, r *http.Request) {
+	format, err := handler.ParseExportFormat(r)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Println("Error :", err)
+		handler.WriteError(w, err)
 		return
 	}
-	var carReq models.CarRequest
-	err = json.Unmarshal(body, &carReq)
+	opts, err := parseCarListOptions(r)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Println("Error :", err)
+		handler.WriteError(w, err)
 		return
 	}
-	createdCar, err := h.service.CreateCar(ctx, &carReq)
+	each, err := h.service.ExportCars(r.Context(), opts)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Println("Error :", err)
+		handler.WriteError(w, err)
 		return
 	}
+	handler.StreamExport(w, format, "cars", models.CarExportColumns, func(write func(export.Record) error) error {
+		return each(func(car models.Car) error {
+			return write(export.Record{Value: car, Cells: car.ExportCells()})
+		})
+	})
+}
 
-	responseBody, err := json.Marshal(createdCar)
+// ListTrash serves GET /cars/trash, the deleted cars that can still be
+// restored, with the same paging, sorting and filters as ListCars.
+func (h *CarHandler) ListTrash(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseCarListOptions(r)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Println("Error while marshalling:", err)
+		handler.WriteError(w, err)
+		return
+	}
+	if opts.Fields, err = parseCarFields(r, true); err != nil {
+		handler.WriteError(w, err)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	_, err = w.Write(responseBody)
+	page, err := h.service.ListTrash(r.Context(), opts)
 	if err != nil {
-		log.Println("Error while writing response:", err)
+		handler.WriteError(w, err)
+		return
 	}
+	handler.WriteJSON(w, http.StatusOK, page)
+}
+
+// RestoreCar serves POST /cars/{id}/restore.
+func (h *CarHandler) RestoreCar(w http.ResponseWriter, r *http.Request) {
+	restoredCar, err := h.service.RestoreCar(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		handler.WriteError(w, err)
+		return
+	}
+	handler.WriteTagged(w, http.StatusOK, carETag(restoredCar), restoredCar)
+}
+
+// RevertCar serves POST /cars/{id}/revert with a body such as
+// {"version": 3}, which sets the car back to that earlier version.
+func (h *CarHandler) RevertCar(w http.ResponseWriter, r *http.Request) {
+	pre, err := h.opts.Precondition(r)
+	if err != nil {
+		handler.WriteError(w, err)
+		return
+	}
+	var req models.RevertRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		handler.WriteError(w, handler.BodyError(err))
+		return
+	}
+	revertedCar, err := h.service.RevertCar(r.Context(), mux.Vars(r)["id"], req.Version, pre)
+	if err != nil {
+		handler.WriteError(w, err)
+		return
+	}
+	handler.WriteTagged(w, http.StatusOK, carETag(revertedCar), revertedCar)
+}
+
+// CarHistory serves GET /cars/{id}/history, the car's audit trail newest
+// first. It stays available after the car is deleted.
+func (h *CarHandler) CarHistory(w http.ResponseWriter, r *http.Request) {
+	opts, err := handler.ParseHistoryOptions(r)
+	if err != nil {
+		handler.WriteError(w, err)
+		return
+	}
+	page, err := h.service.History(r.Context(), mux.Vars(r)["id"], opts)
+	if err != nil {
+		handler.WriteError(w, err)
+		return
+	}
+	handler.WriteJSON(w, http.StatusOK, page)
+}
+
+// PurgeTrash serves DELETE /cars/trash?older_than=720h, which permanently
+// removes the cars deleted longer ago than older_than, by default the
+// configured retention.
+func (h *CarHandler) PurgeTrash(w http.ResponseWriter, r *http.Request) {
+	olderThan := h.opts.TrashRetention
+	if value := r.URL.Query().Get("older_than"); value != "" {
+		var err error
+		if olderThan, err = time.ParseDuration(value); err != nil {
+			handler.WriteError(w, apperrors.Validation("invalid older_than %q, expected a duration such as 720h", value))
+			return
+		}
+	}
+	purged, err := h.service.PurgeTrash(r.Context(), olderThan)
+	if err != nil {
+		handler.WriteError(w, err)
+		return
+	}
+	handler.WriteJSON(w, http.StatusOK, struct {
+		Purged int64 `json:"purged"`
+	}{purged})
+}
+
+func parseCarListOptions(r *http.Request) (models.CarListOptions, error) {
+	query := r.URL.Query()
+	filter, err := parseCarFilter(query)
+	if err != nil {
+		return models.CarListOptions{}, apperrors.Wrap(apperrors.KindValidation, err)
+	}
+	limit, err := handler.ParseLimit(query)
+	if err != nil {
+		return models.CarListOptions{}, err
+	}
+	return models.CarListOptions{
+		Limit:      limit,
+		Sort:       query.Get("sort"),
+		Cursor:     query.Get("cursor"),
+		Filter:     filter,
+		Expression: query.Get("filter"),
+	}, nil
+}
+
+// parseCarFields reads the sparse fieldset of a car read from ?fields=, e.g.
+// name,price,engine.displacement, and ?include=engine. includeEngine is
+// whether the endpoint embeds engines when neither is given.
+func parseCarFields(r *http.Request, includeEngine bool) (models.FieldSet, error) {
+	query := r.URL.Query()
+	return models.ParseCarFieldSet(query.Get("fields"), query.Get("include"), includeEngine)
+}
+
+// ImportCars serves POST /cars/import with a text/csv or
+// application/x-ndjson body, ?mode=atomic|per_row and ?dry_run=true. It
+// answers 201 when every row was created, 422 when none was because of
+// invalid rows and 200 otherwise, always with the import report.
+func (h *CarHandler) ImportCars(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	opts := models.ImportOptions{Mode: models.ImportMode(query.Get("mode"))}
+	if value := query.Get("dry_run"); value != "" {
+		var err error
+		if opts.DryRun, err = strconv.ParseBool(value); err != nil {
+			handler.WriteError(w, apperrors.Validation("invalid dry_run %q, expected true or false", value))
+			return
+		}
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var rows []models.ImportRow
+	var err error
+	switch mediaType {
+	case "text/csv":
+		rows, err = carimport.ReadCSV(r.Body, models.MaxImportRows)
+	case "application/x-ndjson", "application/ndjson":
+		rows, err = carimport.ReadNDJSON(r.Body, models.MaxImportRows)
+	default:
+		err = apperrors.UnsupportedMediaType("unsupported import format %q, expected text/csv or application/x-ndjson", mediaType)
+	}
+	var importErr *carimport.Error
+	switch {
+	case errors.As(err, &importErr):
+		err = apperrors.Validation("invalid import file: %v", importErr)
+	case err != nil && apperrors.KindOf(err) == apperrors.KindInternal:
+		err = handler.BodyError(err)
+	}
+	if err != nil {
+		handler.WriteError(w, err)
+		return
+	}
+
+	report, err := h.service.ImportCars(r.Context(), rows, opts)
+	if err != nil {
+		handler.WriteError(w, err)
+		return
+	}
+	status := http.StatusOK
+	switch {
+	case report.DryRun:
+	case len(report.Errors) == 0:
+		status = http.StatusCreated
+	case len(report.Created) == 0:
+		status = http.StatusUnprocessableEntity
+	}
+	handler.WriteJSON(w, status, report)
+}
+
+func (h *CarHandler) CreateCar(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	carReq, err := decodeCarRequest(r)
+	if err != nil {
+		handler.WriteError(w, err)
+		return
+	}
+	createdCar, err := h.service.CreateCar(ctx, carReq)
+	if err != nil {
+		handler.WriteError(w, err)
+		return
+	}
+	handler.WriteTagged(w, http.StatusCreated, carETag(createdCar), createdCar)
 }
 func (h *CarHandler) UpdateCar(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	params := mux.Vars(r)
 	id := params["id"]
-	body, err := io.ReadAll(r.Body)
+	pre, err := h.opts.Precondition(r)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Println("Error reading request body:", err)
+		handler.WriteError(w, err)
 		return
 	}
-	var carReq models.CarRequest
-	err = json.Unmarshal(body, &carReq)
+	carReq, err := decodeCarRequest(r)
+	if err != nil {
+		handler.WriteError(w, err)
+		return
+	}
+	updatedCar, err := h.service.UpdateCar(ctx, id, carReq, pre)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Println("Error :", err)
+		handler.WriteError(w, err)
 		return
 	}
-	updatedCar, err := h.service.UpdateCar(ctx, id, &carReq)
+	handler.WriteTagged(w, http.StatusOK, carETag(updatedCar), updatedCar)
+}
+
+// PatchCar serves PATCH /cars/{id} with a JSON Merge Patch or JSON Patch body.
+func (h *CarHandler) PatchCar(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+	pre, err := h.opts.Precondition(r)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Println("Error updating car:", err)
+		handler.WriteError(w, err)
 		return
 	}
-	responseBody, err := json.Marshal(updatedCar)
+	patch, err := handler.ReadPatch(r)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Println("Error while marshalling:", err)
+		handler.WritePatchError(w, err)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_, err = w.Write(responseBody)
+	patchedCar, err := h.service.PatchCar(ctx, id, patch, pre)
 	if err != nil {
-		log.Println("Error while writing response:", err)
+		handler.WritePatchError(w, err)
+		return
 	}
+	handler.WriteTagged(w, http.StatusOK, carETag(patchedCar), patchedCar)
 }
 
 func (h *CarHandler) DeleteCar(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	params := mux.Vars(r)
 	id := params["id"]
-
-	deletedCar, err := h.service.DeleteCar(ctx, id)
+	pre, err := h.opts.Precondition(r)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Println("Error deleting car:", err)
+		handler.WriteError(w, err)
 		return
 	}
 
-	responseBody, err := json.Marshal(deletedCar)
+	deletedCar, err := h.service.DeleteCar(ctx, id, pre)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Println("Error while marshalling:", err)
+		handler.WriteError(w, err)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_, err = w.Write(responseBody)
+	handler.WriteJSON(w, http.StatusOK, deletedCar)
+}
+
+// carETag covers the engine a car is returned with as well as the car.
+func carETag(car *models.Car) string {
+	return handler.ETag(car.Version, car.Engine.Version)
+}
+
+// carLastModified is the Last-Modified of a car response: the later of
+// the car's updated_at and that of its engine when embedded, so that it
+// changes whenever carETag does.
+func carLastModified(car *models.Car) time.Time {
+	if car.Engine.UpdatedAt.After(car.UpdatedAt) {
+		return car.Engine.UpdatedAt
+	}
+	return car.UpdatedAt
+}
+
+func decodeCarRequest(r *http.Request) (*models.CarRequest, error) {
+	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		log.Println("Error while writing response:", err)
+		return nil, handler.BodyError(err)
+	}
+	var carReq models.CarRequest
+	if err := json.Unmarshal(body, &carReq); err != nil {
+		return nil, apperrors.Validation("invalid request body: %v", err)
 	}
+	return &carReq, nil
 }
@@ -0,0 +1,134 @@
+package car
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golangSecond/handler"
+	"golangSecond/models"
+	carService "golangSecond/service/car"
+	"golangSecond/store/memory"
+
+	"github.com/gorilla/mux"
+)
+
+const nexonJSON = `{"name":"Nexon","year":"2021","brand":"Tata","fuel_type":"Petrol","price":10000,"engine":{"engine_id":"%s","displacement":1998,"noOfCylinders":4,"carRange":550}}`
+
+type fixture struct {
+	router  *mux.Router
+	engines *memory.EngineStore
+	car     *models.Car
+}
+
+// newFixture routes the car handler over in-memory stores holding one car
+// on one engine, both at version 1.
+func newFixture(t *testing.T, opts handler.Options) fixture {
+	t.Helper()
+	db := memory.NewDB()
+	engines := memory.NewEngineStore(db)
+	svc := carService.NewCarService(memory.NewCarStore(db), engines, memory.NewAuditStore(db), db)
+	engine, err := engines.EngineCreate(context.Background(), &models.EngineRequest{Displacement: 1998, NoOfCylinders: 4, CarRange: 550})
+	if err != nil {
+		t.Fatalf("EngineCreate: %v", err)
+	}
+	car, err := svc.CreateCar(context.Background(), &models.CarRequest{
+		Name: "Nexon", Year: "2021", Brand: "Tata", FuelType: "Petrol", Price: 10000, Engine: engine,
+	})
+	if err != nil {
+		t.Fatalf("CreateCar: %v", err)
+	}
+
+	h := NewCarHandler(svc, opts)
+	router := mux.NewRouter()
+	router.HandleFunc("/cars/{id}", h.GetCarByID).Methods(http.MethodGet)
+	router.HandleFunc("/cars/{id}", h.UpdateCar).Methods(http.MethodPut)
+	router.HandleFunc("/cars/{id}", h.PatchCar).Methods(http.MethodPatch)
+	router.HandleFunc("/cars/{id}", h.DeleteCar).Methods(http.MethodDelete)
+	return fixture{router: router, engines: engines, car: car}
+}
+
+func (f fixture) do(method, ifMatch, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "/cars/"+f.car.ID.String(), strings.NewReader(body))
+	if method == http.MethodPatch {
+		r.Header.Set("Content-Type", "application/merge-patch+json")
+	} else if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	if ifMatch != "" {
+		r.Header.Set("If-Match", ifMatch)
+	}
+	w := httptest.NewRecorder()
+	f.router.ServeHTTP(w, r)
+	return w
+}
+
+func (f fixture) carJSON() string {
+	return fmt.Sprintf(nexonJSON, f.car.Engine.EngineID)
+}
+
+func TestCarETagCoversTheEngine(t *testing.T) {
+	f := newFixture(t, handler.Options{})
+	if got := f.do(http.MethodGet, "", "").Header().Get("ETag"); got != `"1.1"` {
+		t.Errorf("ETag = %s, want \"1.1\"", got)
+	}
+	_, err := f.engines.EngineUpdate(context.Background(), f.car.Engine.EngineID.String(), &models.EngineRequest{Displacement: 2500, NoOfCylinders: 6, CarRange: 480}, 0)
+	if err != nil {
+		t.Fatalf("EngineUpdate: %v", err)
+	}
+	if got := f.do(http.MethodGet, "", "").Header().Get("ETag"); got != `"1.2"` {
+		t.Errorf("ETag after an engine update = %s, want \"1.2\"", got)
+	}
+	if got := f.do(http.MethodPatch, "", `{"price":12000}`).Header().Get("ETag"); got != `"2.2"` {
+		t.Errorf("ETag of a patched car = %s, want \"2.2\"", got)
+	}
+}
+
+func TestCarStaleIfMatch(t *testing.T) {
+	f := newFixture(t, handler.Options{})
+	if w := f.do(http.MethodPatch, `"1.1"`, `{"price":12000}`); w.Code != http.StatusOK {
+		t.Fatalf("PATCH with the current ETag = %d %s, want 200", w.Code, w.Body)
+	}
+	stale := []struct {
+		method, body string
+	}{
+		{http.MethodPut, f.carJSON()},
+		{http.MethodPatch, `{"price":13000}`},
+		{http.MethodDelete, ""},
+	}
+	for _, tc := range stale {
+		if w := f.do(tc.method, `"1.1"`, tc.body); w.Code != http.StatusPreconditionFailed {
+			t.Errorf("%s with a stale If-Match = %d %s, want 412", tc.method, w.Code, w.Body)
+		}
+	}
+	// Only the car's own version counts: the engine's cannot go stale
+	// through a car write.
+	if w := f.do(http.MethodPut, `"2.7"`, f.carJSON()); w.Code != http.StatusOK {
+		t.Errorf("PUT with the car's current version = %d %s, want 200", w.Code, w.Body)
+	}
+}
+
+func TestCarRequireIfMatch(t *testing.T) {
+	f := newFixture(t, handler.Options{RequireIfMatch: true})
+	writes := []struct {
+		method, body string
+	}{
+		{http.MethodPut, f.carJSON()},
+		{http.MethodPatch, `{"price":13000}`},
+		{http.MethodDelete, ""},
+	}
+	for _, tc := range writes {
+		if w := f.do(tc.method, "", tc.body); w.Code != http.StatusPreconditionRequired {
+			t.Errorf("%s without If-Match = %d %s, want 428", tc.method, w.Code, w.Body)
+		}
+	}
+	if w := f.do(http.MethodGet, "", ""); w.Code != http.StatusOK {
+		t.Errorf("GET without If-Match = %d, want 200", w.Code)
+	}
+	if w := f.do(http.MethodDelete, "*", ""); w.Code != http.StatusOK {
+		t.Errorf("DELETE with If-Match: * = %d %s, want 200", w.Code, w.Body)
+	}
+}
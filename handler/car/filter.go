@@ -0,0 +1,40 @@
+package car
+
+import (
+	"golangSecond/handler"
+	"golangSecond/models"
+	"net/url"
+	"strings"
+)
+
+// parseCarFilter reads the car listing filters from the query string:
+//
+//	name (substring), fuel_type (comma separated or repeated),
+//	price_min, price_max, year_min, year_max, displacement_min,
+//	displacement_max, cylinders_min, cylinders_max, range_min, range_max
+//
+// Every malformed number is reported, not just the first one.
+func parseCarFilter(query url.Values) (models.CarFilter, error) {
+	p := handler.NewQueryParser(query)
+	filter := models.CarFilter{
+		NameContains:    query.Get("name"),
+		PriceMin:        p.Float("price_min"),
+		PriceMax:        p.Float("price_max"),
+		YearMin:         p.Int("year_min"),
+		YearMax:         p.Int("year_max"),
+		DisplacementMin: p.Int64("displacement_min"),
+		DisplacementMax: p.Int64("displacement_max"),
+		CylindersMin:    p.Int64("cylinders_min"),
+		CylindersMax:    p.Int64("cylinders_max"),
+		RangeMin:        p.Int64("range_min"),
+		RangeMax:        p.Int64("range_max"),
+	}
+	for _, raw := range query["fuel_type"] {
+		for _, fuelType := range strings.Split(raw, ",") {
+			if fuelType = strings.TrimSpace(fuelType); fuelType != "" {
+				filter.FuelTypes = append(filter.FuelTypes, fuelType)
+			}
+		}
+	}
+	return filter, p.Err()
+}
@@ -2,23 +2,26 @@ package engine
 
 import (
 	"encoding/json"
+	"golangSecond/apperrors"
+	"golangSecond/export"
+	"golangSecond/handler"
 	"golangSecond/models"
 	"golangSecond/service"
 	"io"
-	"log"
 	"net/http"
 
-	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 )
 
 type EngineHandler struct {
 	service service.EngineServiceInterface
+	opts    handler.Options
 }
 
-func NewEngineHandler(service service.EngineServiceInterface) *EngineHandler {
+func NewEngineHandler(service service.EngineServiceInterface, opts handler.Options) *EngineHandler {
 	return &EngineHandler{
 		service: service,
+		opts:    opts,
 	}
 }
 
@@ -26,156 +29,228 @@ func (e *EngineHandler) GetEngineByID(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	vars := mux.Vars(r)
 	id := vars["id"]
-	resp, err := e.service.GetEngineByID(ctx, id)
+	fields, err := parseEngineFields(r)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Println("Error :", err)
+		handler.WriteError(w, err)
 		return
 	}
-	body, err := json.Marshal(resp)
+	resp, err := e.service.GetEngineByID(ctx, id, fields)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Println("Error :", err)
+		handler.WriteError(w, err)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(body)
-	// Write response body here
-	_, err = w.Write(body)
+	e.opts.WriteCacheable(w, r, handler.ETag(resp.Version), resp.UpdatedAt, resp)
+}
+
+// ListEngines serves GET /engines?limit=20&sort=-carRange&cursor=... with the
+// displacement_min/max, cylinders_min/max and range_min/max filters and a
+// filter expression in ?filter=, e.g. `carRange >= 400`.
+func (e *EngineHandler) ListEngines(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	opts, err := parseEngineListOptions(r)
+	if err != nil {
+		handler.WriteError(w, err)
+		return
+	}
+	if opts.Fields, err = parseEngineFields(r); err != nil {
+		handler.WriteError(w, err)
+		return
+	}
+	page, err := e.service.ListEngines(ctx, opts)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Println("Errore Writting response:", err)
+		handler.WriteError(w, err)
 		return
 	}
+	handler.WriteJSON(w, http.StatusOK, page)
 }
 
-func (e *EngineHandler) CreateEngine(w http.ResponseWriter, r *http.Request) {
+// ExportEngines serves GET /engines/export?format=csv|ndjson|xlsx with the
+// same sort and filters as ListEngines, streaming every matching engine.
+func (e *EngineHandler) ExportEngines(w http.ResponseWriter, r *http.Request) {
+	format, err := handler.ParseExportFormat(r)
+	if err != nil {
+		handler.WriteError(w, err)
+		return
+	}
+	opts, err := parseEngineListOptions(r)
+	if err != nil {
+		handler.WriteError(w, err)
+		return
+	}
+	each, err := e.service.ExportEngines(r.Context(), opts)
+	if err != nil {
+		handler.WriteError(w, err)
+		return
+	}
+	handler.StreamExport(w, format, "engines", models.EngineExportColumns, func(write func(export.Record) error) error {
+		return each(func(engine models.Engine) error {
+			return write(export.Record{Value: engine, Cells: engine.ExportCells()})
+		})
+	})
+}
+
+func parseEngineListOptions(r *http.Request) (models.EngineListOptions, error) {
+	query := r.URL.Query()
+	p := handler.NewQueryParser(query)
+	filter := models.EngineFilter{
+		DisplacementMin: p.Int64("displacement_min"),
+		DisplacementMax: p.Int64("displacement_max"),
+		CylindersMin:    p.Int64("cylinders_min"),
+		CylindersMax:    p.Int64("cylinders_max"),
+		RangeMin:        p.Int64("range_min"),
+		RangeMax:        p.Int64("range_max"),
+	}
+	if err := p.Err(); err != nil {
+		return models.EngineListOptions{}, apperrors.Wrap(apperrors.KindValidation, err)
+	}
+	limit, err := handler.ParseLimit(query)
+	if err != nil {
+		return models.EngineListOptions{}, err
+	}
+	return models.EngineListOptions{
+		Limit:      limit,
+		Sort:       query.Get("sort"),
+		Cursor:     query.Get("cursor"),
+		Filter:     filter,
+		Expression: query.Get("filter"),
+	}, nil
+}
+
+// parseEngineFields reads the sparse fieldset of an engine read from
+// ?fields=, e.g. displacement,carRange.
+func parseEngineFields(r *http.Request) (models.FieldSet, error) {
+	query := r.URL.Query()
+	return models.ParseEngineFieldSet(query.Get("fields"), query.Get("include"))
+}
+
+// GetCarsByEngineID serves GET /engines/{id}/cars with the car ?fields= and
+// ?include= of GET /cars.
+func (e *EngineHandler) GetCarsByEngineID(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	body, err := io.ReadAll(r.Body)
+	id := mux.Vars(r)["id"]
+	query := r.URL.Query()
+	fields, err := models.ParseCarFieldSet(query.Get("fields"), query.Get("include"), true)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Println("Error reading request body :", err)
+		handler.WriteError(w, err)
 		return
 	}
-	var engineReq models.EngineRequest
-	err = json.Unmarshal(body, &engineReq)
+	cars, err := e.service.GetCarsByEngineID(ctx, id, fields)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		log.Println("Error Unmarshalling request :", err)
+		handler.WriteError(w, err)
 		return
 	}
-	createdEngine, err := e.service.CreateEngine(ctx, &engineReq)
+	handler.WriteJSON(w, http.StatusOK, cars)
+}
+
+// EngineHistory serves GET /engines/{id}/history, the engine's audit trail
+// newest first. It stays available after the engine is deleted.
+func (e *EngineHandler) EngineHistory(w http.ResponseWriter, r *http.Request) {
+	opts, err := handler.ParseHistoryOptions(r)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Println("Error while creating engine :", err)
+		handler.WriteError(w, err)
 		return
 	}
+	page, err := e.service.History(r.Context(), mux.Vars(r)["id"], opts)
+	if err != nil {
+		handler.WriteError(w, err)
+		return
+	}
+	handler.WriteJSON(w, http.StatusOK, page)
+}
 
-	responseBody, err := json.Marshal(createdEngine)
+func (e *EngineHandler) CreateEngine(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	engineReq, err := decodeEngineRequest(r)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Println("Error while marshalling:", err)
+		handler.WriteError(w, err)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	_, err = w.Write(responseBody)
+	createdEngine, err := e.service.CreateEngine(ctx, engineReq)
 	if err != nil {
-		log.Println("Error while writing response:", err)
+		handler.WriteError(w, err)
+		return
 	}
+	handler.WriteTagged(w, http.StatusCreated, handler.ETag(createdEngine.Version), createdEngine)
 }
 
 func (e *EngineHandler) UpdateEngine(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	params := mux.Vars(r)
 	id := params["id"]
-	body, err := io.ReadAll(r.Body)
+	pre, err := e.opts.Precondition(r)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Println("Error reading request body:", err)
+		handler.WriteError(w, err)
 		return
 	}
-	var engineReq models.EngineRequest
-	err = json.Unmarshal(body, &engineReq)
+	engineReq, err := decodeEngineRequest(r)
+	if err != nil {
+		handler.WriteError(w, err)
+		return
+	}
+	updatedEngine, err := e.service.UpdateEngine(ctx, id, engineReq, pre)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Println("Error :", err)
+		handler.WriteError(w, err)
 		return
 	}
-	updatedEngine, err := e.service.UpdateEngine(ctx, id, &engineReq)
+	handler.WriteTagged(w, http.StatusOK, handler.ETag(updatedEngine.Version), updatedEngine)
+}
+
+// PatchEngine serves PATCH /engines/{id} with a JSON Merge Patch or JSON
+// Patch body.
+func (e *EngineHandler) PatchEngine(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+	pre, err := e.opts.Precondition(r)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Println("Error updating engine:", err)
+		handler.WriteError(w, err)
 		return
 	}
-	responseBody, err := json.Marshal(updatedEngine)
+	patch, err := handler.ReadPatch(r)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Println("Error while marshalling:", err)
+		handler.WritePatchError(w, err)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_, err = w.Write(responseBody)
+	patchedEngine, err := e.service.PatchEngine(ctx, id, patch, pre)
 	if err != nil {
-		log.Println("Error while writing response:", err)
+		handler.WritePatchError(w, err)
+		return
 	}
+	handler.WriteTagged(w, http.StatusOK, handler.ETag(patchedEngine.Version), patchedEngine)
 }
 
+// DeleteEngine serves DELETE /engines/{id}. Engines still used by cars are
+// only deleted with ?strategy=cascade, or ?strategy=detach&replacement_id=...
 func (e *EngineHandler) DeleteEngine(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	params := mux.Vars(r)
 	id := params["id"]
-
-	deletedEngine, err := e.service.DeleteEngine(ctx, id)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Println("Error deleting engine:", err)
-		response := map[string]string{"Invalid request": err.Error()}
-		jsonResponse, err := json.Marshal(response)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			log.Println("Error while marshalling:", err)
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		_, err = w.Write(jsonResponse)
-		if err != nil {
-			log.Println("Error while writing response:", err)
-		}
-		return
+	query := r.URL.Query()
+	opts := models.EngineDeleteOptions{
+		Strategy:      models.EngineDeleteStrategy(query.Get("strategy")),
+		ReplacementID: query.Get("replacement_id"),
 	}
-	// Check if engine was deleted
-	if deletedEngine.EngineID == uuid.Nil {
-		w.WriteHeader(http.StatusNotFound)
-		response := map[string]string{"message": "Engine not found"}
-		jsonResponse, err := json.Marshal(response)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			log.Println("Error while marshalling:", err)
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusNotFound)
-		_, err = w.Write(jsonResponse)
-		if err != nil {
-			log.Println("Error while writing response:", err)
-		}
+	pre, err := e.opts.Precondition(r)
+	if err != nil {
+		handler.WriteError(w, err)
 		return
 	}
 
-	responseBody, err := json.Marshal(deletedEngine)
+	deletedEngine, err := e.service.DeleteEngine(ctx, id, opts, pre)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Println("Error while marshalling:", err)
+		handler.WriteError(w, err)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_, err = w.Write(responseBody)
+	handler.WriteJSON(w, http.StatusOK, deletedEngine)
+}
+
+func decodeEngineRequest(r *http.Request) (*models.EngineRequest, error) {
+	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		log.Println("Error while writing response:", err)
+		return nil, handler.BodyError(err)
+	}
+	var engineReq models.EngineRequest
+	if err := json.Unmarshal(body, &engineReq); err != nil {
+		return nil, apperrors.Validation("invalid request body: %v", err)
 	}
+	return &engineReq, nil
 }
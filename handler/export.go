@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+
+	"golangSecond/apperrors"
+	"golangSecond/export"
+)
+
+// ParseExportFormat reads the format an export is asked for in ?format=.
+func ParseExportFormat(r *http.Request) (export.Format, error) {
+	name := r.URL.Query().Get("format")
+	format, ok := export.ParseFormat(name)
+	if !ok {
+		return "", apperrors.Validation("invalid format %q, expected one of csv, ndjson, xlsx", name)
+	}
+	return format, nil
+}
+
+// StreamExport writes an export as an attachment named after name. run is
+// called with a function writing one record; anything that can be
+// rejected must have been checked before, since the response has started
+// by the time run is called. If run fails part way, the connection is
+// aborted so that the client cannot take a truncated file for a complete
+// one.
+func StreamExport(w http.ResponseWriter, format export.Format, name string, columns []string, run func(write func(export.Record) error) error) {
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.`+string(format)+`"`)
+	w.WriteHeader(http.StatusOK)
+
+	writer, err := export.NewWriter(format, w, columns)
+	if err == nil {
+		err = run(writer.Write)
+	}
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		log.Println("Error while exporting "+name+":", err)
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"golangSecond/models"
+	"golangSecond/service"
+)
+
+// IdempotencyKeyHeader lets a client retry a POST or PATCH without its
+// changes being made twice: every request with the same key gets the
+// response of the first one.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotentReplayedHeader is set on responses replayed from an earlier
+// request with the same idempotency key.
+const IdempotentReplayedHeader = "Idempotent-Replayed"
+
+// storedHeaders are the response headers kept to be replayed.
+var storedHeaders = []string{"Content-Type", "Location", "ETag", "Last-Modified"}
+
+// WithIdempotency is middleware answering POST and PATCH requests that carry
+// an IdempotencyKeyHeader once, and replaying that answer to retries. Server
+// errors are not kept, so that a retry runs the request again.
+func WithIdempotency(idempotency service.IdempotencyServiceInterface) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" || (r.Method != http.MethodPost && r.Method != http.MethodPatch) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxBodyBytes))
+			if err != nil {
+				WriteError(w, BodyError(err))
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			stored, err := idempotency.Begin(r.Context(), key, fingerprint(r, body))
+			if err != nil {
+				WriteError(w, err)
+				return
+			}
+			if stored != nil {
+				replay(w, stored)
+				return
+			}
+
+			// The outcome is recorded even when the client has gone away,
+			// since that is when it is most likely to retry.
+			ctx := context.WithoutCancel(r.Context())
+			rec := &responseRecorder{ResponseWriter: w}
+			// Long requests such as imports must not lose the key to a
+			// retry while they run.
+			stop := idempotency.Hold(ctx, key)
+			defer func() {
+				if p := recover(); p != nil {
+					stop()
+					abandon(ctx, idempotency, key)
+					panic(p)
+				}
+			}()
+			next.ServeHTTP(rec, r)
+			stop()
+			if rec.status == 0 || rec.status >= http.StatusInternalServerError {
+				abandon(ctx, idempotency, key)
+				return
+			}
+			response := models.StoredResponse{Status: rec.status, Header: make(map[string][]string), Body: rec.body.Bytes()}
+			for _, name := range storedHeaders {
+				if values := rec.header.Values(name); len(values) > 0 {
+					response.Header[http.CanonicalHeaderKey(name)] = values
+				}
+			}
+			if err := idempotency.Finish(ctx, key, response); err != nil {
+				log.Println("Error storing idempotent response:", err)
+			}
+		})
+	}
+}
+
+// fingerprintHeaders are the request headers that change what a request
+// does, such as the format of an import or the version it expects.
+var fingerprintHeaders = []string{"Content-Type", "If-Match"}
+
+// fingerprint identifies a request by its method, target, fingerprintHeaders
+// and body, so that an idempotency key cannot be reused for a different
+// request.
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.RequestURI()+"\n")
+	for _, name := range fingerprintHeaders {
+		io.WriteString(h, name+": "+strings.Join(r.Header.Values(name), ", ")+"\n")
+	}
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func replay(w http.ResponseWriter, stored *models.StoredResponse) {
+	for name, values := range stored.Header {
+		w.Header()[name] = values
+	}
+	w.Header().Set(IdempotentReplayedHeader, "true")
+	w.WriteHeader(stored.Status)
+	if _, err := w.Write(stored.Body); err != nil {
+		log.Println("Error while writing response:", err)
+	}
+}
+
+func abandon(ctx context.Context, idempotency service.IdempotencyServiceInterface, key string) {
+	if err := idempotency.Abandon(ctx, key); err != nil {
+		log.Println("Error releasing idempotency key:", err)
+	}
+}
+
+// responseRecorder passes a response through while keeping a copy of it.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+		rec.header = rec.Header().Clone()
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golangSecond/service"
+	"golangSecond/store/memory"
+)
+
+// newIdempotent serves next behind WithActor and WithIdempotency, as the
+// router does, with an in-memory key store.
+func newIdempotent(next http.Handler) http.Handler {
+	idempotency := service.NewIdempotency(memory.NewIdempotencyStore(memory.NewDB()), time.Hour)
+	return WithActor(WithIdempotency(idempotency)(next))
+}
+
+// echo answers 201 with the request body and counts the requests it ran.
+func echo(calls *atomic.Int32) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Location", "/cars/1")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(body)
+	})
+}
+
+func idempotentRequest(key, actor, body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/cars", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set(IdempotencyKeyHeader, key)
+	if actor != "" {
+		r.Header.Set(ActorHeader, actor)
+	}
+	return r
+}
+
+func serve(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestWithIdempotencyReplays(t *testing.T) {
+	var calls atomic.Int32
+	h := newIdempotent(echo(&calls))
+
+	first := serve(h, idempotentRequest("k1", "", `{"name":"Nexon"}`))
+	if first.Code != http.StatusCreated || first.Body.String() != `{"name":"Nexon"}` {
+		t.Fatalf("first request = %d %q, want 201 with the body", first.Code, first.Body)
+	}
+	retry := serve(h, idempotentRequest("k1", "", `{"name":"Nexon"}`))
+	if retry.Code != http.StatusCreated || retry.Body.String() != first.Body.String() {
+		t.Errorf("retry = %d %q, want the stored %d %q", retry.Code, retry.Body, first.Code, first.Body)
+	}
+	if got := retry.Header().Get("Location"); got != "/cars/1" {
+		t.Errorf("retry Location = %q, want /cars/1", got)
+	}
+	if got := retry.Header().Get(IdempotentReplayedHeader); got != "true" {
+		t.Errorf("retry %s = %q, want true", IdempotentReplayedHeader, got)
+	}
+	if first.Header().Get(IdempotentReplayedHeader) != "" {
+		t.Error("the first response should not be marked as replayed")
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("handler ran %d times, want 1", n)
+	}
+}
+
+func TestWithIdempotencyRefusesADifferentRequest(t *testing.T) {
+	var calls atomic.Int32
+	h := newIdempotent(echo(&calls))
+
+	serve(h, idempotentRequest("k1", "", `{"name":"Nexon"}`))
+	w := serve(h, idempotentRequest("k1", "", `{"name":"Punch"}`))
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("same key with a different body = %d, want 422", w.Code)
+	}
+	r := idempotentRequest("k1", "", `{"name":"Nexon"}`)
+	r.Header.Set("Content-Type", "text/csv")
+	if w := serve(h, r); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("same key with a different Content-Type = %d, want 422", w.Code)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("handler ran %d times, want 1", n)
+	}
+}
+
+func TestWithIdempotencyConflictsWhileInProgress(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	h := newIdempotent(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- serve(h, idempotentRequest("k1", "", `{}`)) }()
+	<-started
+	if w := serve(h, idempotentRequest("k1", "", `{}`)); w.Code != http.StatusConflict {
+		t.Errorf("retry while the first request runs = %d, want 409", w.Code)
+	}
+	close(release)
+	if w := <-done; w.Code != http.StatusCreated {
+		t.Errorf("first request = %d, want 201", w.Code)
+	}
+	if w := serve(h, idempotentRequest("k1", "", `{}`)); w.Code != http.StatusCreated || w.Header().Get(IdempotentReplayedHeader) != "true" {
+		t.Errorf("retry after the first request = %d, want its replayed 201", w.Code)
+	}
+}
+
+func TestWithIdempotencyKeysPerActor(t *testing.T) {
+	var calls atomic.Int32
+	h := newIdempotent(echo(&calls))
+
+	serve(h, idempotentRequest("k1", "alice", `{"name":"Nexon"}`))
+	w := serve(h, idempotentRequest("k1", "bob", `{"name":"Punch"}`))
+	if w.Code != http.StatusCreated || w.Body.String() != `{"name":"Punch"}` || w.Header().Get(IdempotentReplayedHeader) != "" {
+		t.Errorf("another actor's request with the same key = %d %q, want it run", w.Code, w.Body)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("handler ran %d times, want 2", n)
+	}
+}
+
+func TestWithIdempotencyLimitsTheBody(t *testing.T) {
+	var calls atomic.Int32
+	h := newIdempotent(echo(&calls))
+
+	r := httptest.NewRequest(http.MethodPost, "/cars/import", bytes.NewReader(make([]byte, MaxBodyBytes+1)))
+	r.Header.Set(IdempotencyKeyHeader, "k1")
+	if w := serve(h, r); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("body past MaxBodyBytes = %d, want 413", w.Code)
+	}
+	if n := calls.Load(); n != 0 {
+		t.Errorf("handler ran %d times, want 0", n)
+	}
+}
@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"io"
+	"mime"
+	"net/http"
+
+	"golangSecond/apperrors"
+	"golangSecond/models"
+)
+
+// AcceptPatch lists the PATCH formats understood, for the Accept-Patch
+// header.
+const AcceptPatch = string(models.MergePatch) + ", " + string(models.JSONPatch)
+
+// ReadPatch reads a PATCH body, telling the format from its Content-Type.
+// Plain application/json is taken to be a merge patch.
+func ReadPatch(r *http.Request) (models.Patch, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return models.Patch{}, apperrors.UnsupportedMediaType("PATCH needs a Content-Type of %s", AcceptPatch)
+	}
+	patch := models.Patch{Type: models.PatchType(mediaType)}
+	switch patch.Type {
+	case models.MergePatch, models.JSONPatch:
+	case "application/json":
+		patch.Type = models.MergePatch
+	default:
+		return models.Patch{}, apperrors.UnsupportedMediaType("unsupported patch format %q, expected one of %s", mediaType, AcceptPatch)
+	}
+	if patch.Body, err = io.ReadAll(r.Body); err != nil {
+		return models.Patch{}, BodyError(err)
+	}
+	return patch, nil
+}
+
+// WritePatchError is WriteError for PATCH handlers: it advertises the
+// supported formats when the body was in another one.
+func WritePatchError(w http.ResponseWriter, err error) {
+	if apperrors.KindOf(err) == apperrors.KindUnsupportedMediaType {
+		w.Header().Set("Accept-Patch", AcceptPatch)
+	}
+	WriteError(w, err)
+}
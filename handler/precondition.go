@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golangSecond/apperrors"
+	"golangSecond/models"
+)
+
+// Options configure the car and engine handlers.
+type Options struct {
+	// RequireIfMatch refuses PUT, PATCH and DELETE requests without an
+	// If-Match header with 428 Precondition Required, so that no client can
+	// overwrite a change it has not seen.
+	RequireIfMatch bool
+	// CacheControl is sent with single cars and engines. Empty leaves the
+	// header out.
+	CacheControl string
+	// TrashRetention is how long deleted cars are kept when a purge does not
+	// say.
+	TrashRetention time.Duration
+}
+
+// ETag formats the versions a representation is built from as a strong
+// entity tag: the resource's own version first, then those of any
+// resources embedded in it, so that the tag changes whenever the body does.
+func ETag(versions ...int64) string {
+	parts := make([]string, len(versions))
+	for i, version := range versions {
+		parts[i] = strconv.FormatInt(version, 10)
+	}
+	return `"` + strings.Join(parts, ".") + `"`
+}
+
+// ParseIfMatch reads the If-Match header into a precondition. If-Match uses
+// strong comparison, so weak tags and tags that are not versions this API
+// issued are kept out of Versions and can never match. Only the resource's
+// own version is kept from tags that also cover embedded resources: writes
+// never touch those.
+func ParseIfMatch(r *http.Request) models.Precondition {
+	values := r.Header.Values("If-Match")
+	if len(values) == 0 {
+		return models.Precondition{}
+	}
+	pre := models.Precondition{Present: true}
+	for _, value := range values {
+		for _, tag := range strings.Split(value, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" {
+				pre.Any = true
+				continue
+			}
+			if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+				continue
+			}
+			own, _, _ := strings.Cut(tag[1:len(tag)-1], ".")
+			if version, err := strconv.ParseInt(own, 10, 64); err == nil {
+				pre.Versions = append(pre.Versions, version)
+			}
+		}
+	}
+	return pre
+}
+
+// Precondition returns the If-Match precondition of a write, failing with
+// precondition required when the options demand one and there is none.
+func (o Options) Precondition(r *http.Request) (models.Precondition, error) {
+	pre := ParseIfMatch(r)
+	if o.RequireIfMatch && !pre.Present {
+		return pre, apperrors.PreconditionRequired("%s needs an If-Match header with the ETag of the resource", r.Method)
+	}
+	return pre, nil
+}
+
+// WriteTagged is WriteJSON for a single resource: it also sets the ETag of
+// the version being returned.
+func WriteTagged(w http.ResponseWriter, status int, etag string, v any) {
+	w.Header().Set("ETag", etag)
+	WriteJSON(w, status, v)
+}
@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"golangSecond/apperrors"
+	"golangSecond/models"
+	"net/url"
+	"strconv"
+)
+
+// QueryParser reads typed query parameters, collecting a field error for
+// every malformed value instead of stopping at the first one.
+type QueryParser struct {
+	query url.Values
+	errs  models.ValidationErrors
+}
+
+func NewQueryParser(query url.Values) *QueryParser {
+	return &QueryParser{query: query}
+}
+
+func (p *QueryParser) Float(key string) *float64 {
+	raw := p.query.Get(key)
+	if raw == "" {
+		return nil
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		p.invalid(key)
+		return nil
+	}
+	return &v
+}
+
+func (p *QueryParser) Int64(key string) *int64 {
+	raw := p.query.Get(key)
+	if raw == "" {
+		return nil
+	}
+	v, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		p.invalid(key)
+		return nil
+	}
+	return &v
+}
+
+func (p *QueryParser) Int(key string) *int {
+	v := p.Int64(key)
+	if v == nil {
+		return nil
+	}
+	n := int(*v)
+	return &n
+}
+
+// Err returns the collected field errors, or nil.
+func (p *QueryParser) Err() error {
+	if len(p.errs) == 0 {
+		return nil
+	}
+	return p.errs
+}
+
+func (p *QueryParser) invalid(key string) {
+	p.errs = append(p.errs, models.FieldError{Field: key, Code: models.CodeInvalidFormat, Message: key + " must be a number"})
+}
+
+// ParseLimit reads the page size from ?limit=, returning 0 when absent so
+// that the service applies its default.
+func ParseLimit(query url.Values) (int, error) {
+	p := NewQueryParser(query)
+	limit := p.Int("limit")
+	if err := p.Err(); err != nil {
+		return 0, apperrors.Wrap(apperrors.KindValidation, err)
+	}
+	if limit == nil {
+		return 0, nil
+	}
+	return *limit, nil
+}
@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"golangSecond/apperrors"
+	"golangSecond/models"
+)
+
+const problemContentType = "application/problem+json"
+
+type ErrorBody struct {
+	Error ErrorDetail `json:"error"`
+}
+
+// ErrorDetail is the error reported to clients. Details carries structured
+// context for errors that have it, such as the cars that keep an engine
+// from being deleted.
+type ErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Details any    `json:"details,omitempty"`
+}
+
+// Problem is an RFC 7807 problem details body. Errors carries the per-field
+// report when the request failed validation.
+type Problem struct {
+	Type   string              `json:"type"`
+	Title  string              `json:"title"`
+	Status int                 `json:"status"`
+	Detail string              `json:"detail,omitempty"`
+	Errors []models.FieldError `json:"errors,omitempty"`
+}
+
+// StatusCode maps a domain error kind to the HTTP status clients see.
+func StatusCode(err error) int {
+	switch apperrors.KindOf(err) {
+	case apperrors.KindNotFound:
+		return http.StatusNotFound
+	case apperrors.KindValidation:
+		return http.StatusBadRequest
+	case apperrors.KindConflict:
+		return http.StatusConflict
+	case apperrors.KindUnauthorized:
+		return http.StatusUnauthorized
+	case apperrors.KindUnavailable:
+		return http.StatusServiceUnavailable
+	case apperrors.KindUnsupportedMediaType:
+		return http.StatusUnsupportedMediaType
+	case apperrors.KindPreconditionFailed:
+		return http.StatusPreconditionFailed
+	case apperrors.KindPreconditionRequired:
+		return http.StatusPreconditionRequired
+	case apperrors.KindUnprocessable:
+		return http.StatusUnprocessableEntity
+	case apperrors.KindTooLarge:
+		return http.StatusRequestEntityTooLarge
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// WriteError logs err and writes it as a JSON error body. Internal errors
+// are reported with a generic message so that details do not leak.
+func WriteError(w http.ResponseWriter, err error) {
+	status := StatusCode(err)
+	log.Println("Error :", err)
+
+	var fieldErrs models.ValidationErrors
+	if errors.As(err, &fieldErrs) {
+		writeProblem(w, Problem{
+			Type:   "about:blank",
+			Title:  "Validation failed",
+			Status: http.StatusBadRequest,
+			Detail: "one or more fields are invalid",
+			Errors: fieldErrs,
+		})
+		return
+	}
+
+	detail := ErrorDetail{
+		Code:    apperrors.KindOf(err).String(),
+		Message: http.StatusText(status),
+	}
+	var appErr *apperrors.Error
+	if status != http.StatusInternalServerError && errors.As(err, &appErr) {
+		detail.Message = appErr.Message
+	}
+	var inUse *models.EngineInUseError
+	if errors.As(err, &inUse) {
+		detail.Details = inUse
+	}
+	WriteJSON(w, status, ErrorBody{Error: detail})
+}
+
+// WriteJSON marshals v and writes it with the given status code.
+func WriteJSON(w http.ResponseWriter, status int, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("Error while marshalling:", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(body); err != nil {
+		log.Println("Error while writing response:", err)
+	}
+}
+
+func writeProblem(w http.ResponseWriter, problem Problem) {
+	body, err := json.Marshal(problem)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("Error while marshalling:", err)
+		return
+	}
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(problem.Status)
+	if _, err := w.Write(body); err != nil {
+		log.Println("Error while writing response:", err)
+	}
+}
@@ -0,0 +1,121 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		doc, patch, want string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+		{`{"price":15000}`, `{"price":12345678901234567890}`, `{"price":12345678901234567890}`},
+	}
+	for _, tt := range tests {
+		got, err := MergePatch([]byte(tt.doc), []byte(tt.patch))
+		if err != nil {
+			t.Fatalf("MergePatch(%s, %s): %v", tt.doc, tt.patch, err)
+		}
+		expectJSON(t, "MergePatch("+tt.doc+", "+tt.patch+")", got, tt.want)
+	}
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		doc, patch, want string
+	}{
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`},
+		{`{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+		{`{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+		{`{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			`{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{`{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`, `{"foo":"bar","child":{"grandchild":{}}}`},
+		{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`},
+		{`{"foo":null}`, `[{"op":"add","path":"/foo","value":1}]`, `{"foo":1}`},
+		{`{"/":1,"~":2}`, `[{"op":"replace","path":"/~1","value":3},{"op":"remove","path":"/~0"}]`, `{"/":3}`},
+		{`{"a":{"b":1}}`, `[{"op":"copy","from":"/a","path":"/c"},{"op":"replace","path":"/c/b","value":2}]`, `{"a":{"b":1},"c":{"b":2}}`},
+		{`{"price":15000}`, `[{"op":"test","path":"/price","value":15000.0},{"op":"replace","path":"/price","value":14000}]`, `{"price":14000}`},
+		{`{"a":1}`, `[{"op":"replace","path":"","value":[1]}]`, `[1]`},
+	}
+	for _, tt := range tests {
+		got, err := Apply([]byte(tt.doc), []byte(tt.patch))
+		if err != nil {
+			t.Fatalf("Apply(%s, %s): %v", tt.doc, tt.patch, err)
+		}
+		expectJSON(t, "Apply("+tt.doc+", "+tt.patch+")", got, tt.want)
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	tests := []struct {
+		doc, patch string
+		op         int
+	}{
+		{`{"foo":"bar"}`, `{"op":"add","path":"/baz","value":1}`, -1},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`, 0},
+		{`{"foo":"bar"}`, `[{"op":"remove","path":"/foo"},{"op":"remove","path":"/foo"}]`, 1},
+		{`{"foo":"bar"}`, `[{"op":"replace","path":"/baz","value":1}]`, 0},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz"}]`, 0},
+		{`{"foo":"bar"}`, `[{"op":"frobnicate","path":"/foo"}]`, 0},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"foo","value":1}]`, 0},
+		{`{"foo":[1,2]}`, `[{"op":"add","path":"/foo/3","value":1}]`, 0},
+		{`{"foo":[1,2]}`, `[{"op":"remove","path":"/foo/01"}]`, 0},
+		{`{"foo":[1,2]}`, `[{"op":"remove","path":"/foo/-"}]`, 0},
+		{`{"a":{"b":1}}`, `[{"op":"move","from":"/a","path":"/a/c"}]`, 0},
+		{`{"foo":"bar"}`, `[{"op":"copy","path":"/baz"}]`, 0},
+	}
+	for _, tt := range tests {
+		_, err := Apply([]byte(tt.doc), []byte(tt.patch))
+		var patchErr *Error
+		if !errors.As(err, &patchErr) {
+			t.Errorf("Apply(%s, %s): expected a patch error, got %v", tt.doc, tt.patch, err)
+			continue
+		}
+		if patchErr.Op != tt.op {
+			t.Errorf("Apply(%s, %s): error at op %d, want %d (%v)", tt.doc, tt.patch, patchErr.Op, tt.op, err)
+		}
+		if errors.Is(err, ErrTestFailed) {
+			t.Errorf("Apply(%s, %s): %v should not be a test failure", tt.doc, tt.patch, err)
+		}
+	}
+}
+
+func TestApplyTestFailure(t *testing.T) {
+	_, err := Apply([]byte(`{"baz":"qux"}`), []byte(`[{"op":"test","path":"/baz","value":"bar"},{"op":"remove","path":"/baz"}]`))
+	if !errors.Is(err, ErrTestFailed) {
+		t.Fatalf("expected a test failure, got %v", err)
+	}
+}
+
+func expectJSON(t *testing.T, op string, got []byte, want string) {
+	t.Helper()
+	var g, w any
+	if err := json.Unmarshal(got, &g); err != nil {
+		t.Fatalf("%s returned invalid JSON %s: %v", op, got, err)
+	}
+	if err := json.Unmarshal([]byte(want), &w); err != nil {
+		t.Fatal(err)
+	}
+	gotNorm, _ := json.Marshal(g)
+	wantNorm, _ := json.Marshal(w)
+	if string(gotNorm) != string(wantNorm) {
+		t.Errorf("%s = %s, want %s", op, got, want)
+	}
+}
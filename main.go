@@ -1,7 +1,208 @@
 package main
 
-import "fmt"
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"golangSecond/driver"
+	"golangSecond/handler"
+	carHandler "golangSecond/handler/car"
+	engineHandler "golangSecond/handler/engine"
+	"golangSecond/service"
+	carService "golangSecond/service/car"
+	engineService "golangSecond/service/engine"
+	"golangSecond/store"
+	auditStore "golangSecond/store/audit"
+	carStore "golangSecond/store/car"
+	engineStore "golangSecond/store/engine"
+	idempotencyStore "golangSecond/store/idempotency"
+	"golangSecond/store/memory"
+
+	"github.com/gorilla/mux"
+)
+
+const shutdownTimeout = 15 * time.Second
+
+// idempotencyPurgeInterval is how often expired idempotency keys are
+// deleted.
+const idempotencyPurgeInterval = time.Hour
 
 func main() {
-	fmt.Println("Hello World")
+	addr := getEnv("HTTP_ADDR", ":8080")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	backend := getEnv("STORE_BACKEND", "postgres")
+	migrate := len(os.Args) > 1 && os.Args[1] == "migrate"
+	if migrate && backend != "postgres" {
+		log.Fatalln("migrate requires STORE_BACKEND=postgres")
+	}
+
+	var cars store.CarStoreInterface
+	var engines store.EngineStoreInterface
+	var audits store.AuditStoreInterface
+	var idempotencyKeys store.IdempotencyStoreInterface
+	var tx store.TxManager
+	switch backend {
+	case "memory":
+		log.Println("Using in-memory store")
+		memDB := memory.NewDB()
+		cars = memory.NewCarStore(memDB)
+		engines = memory.NewEngineStore(memDB)
+		audits = memory.NewAuditStore(memDB)
+		idempotencyKeys = memory.NewIdempotencyStore(memDB)
+		tx = memDB
+	case "postgres":
+		dbConfig, err := driver.ConfigFromEnv()
+		if err != nil {
+			log.Fatalln("Error reading database config:", err)
+		}
+		db, closeDB, err := driver.Connect(ctx, dbConfig)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer func() {
+			if err := closeDB(); err != nil {
+				log.Println("Error closing database:", err)
+			}
+		}()
+
+		if migrate {
+			if err := runMigrate(ctx, db, os.Args[2:]); err != nil {
+				log.Fatalln("Error running migrations:", err)
+			}
+			return
+		}
+		if getEnv("DB_AUTO_MIGRATE", "false") == "true" {
+			if err := runMigrate(ctx, db, []string{"up"}); err != nil {
+				log.Fatalln("Error running migrations:", err)
+			}
+		}
+		cars = carStore.New(db)
+		engines = engineStore.New(db)
+		audits = auditStore.New(db)
+		idempotencyKeys = idempotencyStore.New(db)
+		tx = driver.NewTxManager(db)
+	default:
+		log.Fatalf("Unknown STORE_BACKEND %q, expected postgres or memory", backend)
+	}
+
+	carSvc := carService.NewCarService(cars, engines, audits, tx)
+	engineSvc := engineService.NewEngineService(engines, cars, audits, tx)
+
+	trashRetention, err := time.ParseDuration(getEnv("TRASH_RETENTION", "720h"))
+	if err != nil {
+		log.Fatalln("Invalid TRASH_RETENTION:", err)
+	}
+	idempotencyTTL, err := time.ParseDuration(getEnv("IDEMPOTENCY_TTL", "24h"))
+	if err != nil {
+		log.Fatalln("Invalid IDEMPOTENCY_TTL:", err)
+	}
+	idempotency := service.NewIdempotency(idempotencyKeys, idempotencyTTL)
+	go purgeIdempotencyKeys(ctx, idempotency)
+
+	handlerOpts := handler.Options{
+		RequireIfMatch: getEnv("REQUIRE_IF_MATCH", "false") == "true",
+		CacheControl:   getEnv("CACHE_CONTROL", "no-cache"),
+		TrashRetention: trashRetention,
+	}
+	router := newRouter(
+		carHandler.NewCarHandler(carSvc, handlerOpts),
+		engineHandler.NewEngineHandler(engineSvc, handlerOpts),
+		idempotency,
+	)
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           router,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Println("Server listening on", addr)
+		serverErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalln("Error starting server:", err)
+		}
+	case <-ctx.Done():
+		log.Println("Shutting down server")
+	}
+
+	// Stop accepting new connections and wait for in-flight requests to finish
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Println("Error shutting down server:", err)
+	}
+}
+
+func newRouter(carH *carHandler.CarHandler, engineH *engineHandler.EngineHandler, idempotency service.IdempotencyServiceInterface) *mux.Router {
+	router := mux.NewRouter()
+
+	router.HandleFunc("/cars", carH.GetCarsByIDs).Methods(http.MethodGet).Queries("ids", "{ids}")
+	router.HandleFunc("/cars", carH.GetCarByBrand).Methods(http.MethodGet).Queries("brand", "{brand}")
+	router.HandleFunc("/cars", carH.ListCars).Methods(http.MethodGet)
+	router.HandleFunc("/cars", carH.CreateCar).Methods(http.MethodPost)
+	router.HandleFunc("/cars/export", carH.ExportCars).Methods(http.MethodGet)
+	router.HandleFunc("/cars/import", carH.ImportCars).Methods(http.MethodPost)
+	router.HandleFunc("/cars/trash", carH.ListTrash).Methods(http.MethodGet)
+	router.HandleFunc("/cars/trash", carH.PurgeTrash).Methods(http.MethodDelete)
+	router.HandleFunc("/cars/{id}", carH.GetCarByID).Methods(http.MethodGet)
+	router.HandleFunc("/cars/{id}", carH.UpdateCar).Methods(http.MethodPut)
+	router.HandleFunc("/cars/{id}", carH.PatchCar).Methods(http.MethodPatch)
+	router.HandleFunc("/cars/{id}", carH.DeleteCar).Methods(http.MethodDelete)
+	router.HandleFunc("/cars/{id}/restore", carH.RestoreCar).Methods(http.MethodPost)
+	router.HandleFunc("/cars/{id}/revert", carH.RevertCar).Methods(http.MethodPost)
+	router.HandleFunc("/cars/{id}/history", carH.CarHistory).Methods(http.MethodGet)
+
+	router.HandleFunc("/engines", engineH.ListEngines).Methods(http.MethodGet)
+	router.HandleFunc("/engines", engineH.CreateEngine).Methods(http.MethodPost)
+	router.HandleFunc("/engines/export", engineH.ExportEngines).Methods(http.MethodGet)
+	router.HandleFunc("/engines/{id}", engineH.GetEngineByID).Methods(http.MethodGet)
+	router.HandleFunc("/engines/{id}", engineH.UpdateEngine).Methods(http.MethodPut)
+	router.HandleFunc("/engines/{id}", engineH.PatchEngine).Methods(http.MethodPatch)
+	router.HandleFunc("/engines/{id}", engineH.DeleteEngine).Methods(http.MethodDelete)
+	router.HandleFunc("/engines/{id}/cars", engineH.GetCarsByEngineID).Methods(http.MethodGet)
+	router.HandleFunc("/engines/{id}/history", engineH.EngineHistory).Methods(http.MethodGet)
+
+	router.Use(handler.LimitBody)
+	router.Use(handler.WithActor)
+	router.Use(handler.WithIdempotency(idempotency))
+
+	return router
+}
+
+// purgeIdempotencyKeys deletes expired idempotency keys until ctx is done.
+func purgeIdempotencyKeys(ctx context.Context, idempotency service.IdempotencyServiceInterface) {
+	ticker := time.NewTicker(idempotencyPurgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := idempotency.Purge(ctx); err != nil {
+				log.Println("Error purging idempotency keys:", err)
+			}
+		}
+	}
+}
+
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
 }
@@ -0,0 +1,128 @@
+package models
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AuditAction is what an audit entry records happening to a car or engine.
+type AuditAction string
+
+const (
+	AuditCreate  AuditAction = "create"
+	AuditUpdate  AuditAction = "update"
+	AuditDelete  AuditAction = "delete"
+	AuditRestore AuditAction = "restore"
+	AuditRevert  AuditAction = "revert"
+	// AuditPurge records a car removed from the trash for good.
+	AuditPurge AuditAction = "purge"
+)
+
+// The entity types audit entries are kept for.
+const (
+	AuditCar    = "car"
+	AuditEngine = "engine"
+)
+
+// FieldChange is the value of one field before and after a change. Before
+// is nil for a creation and After for a deletion.
+type FieldChange struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+// AuditEntry records one change to a car or engine: who made it, when, the
+// version it produced and the fields it changed. Seq orders entries in the
+// order they were recorded.
+type AuditEntry struct {
+	Seq        int64                  `json:"seq"`
+	EntityType string                 `json:"entity_type"`
+	EntityID   uuid.UUID              `json:"entity_id"`
+	Action     AuditAction            `json:"action"`
+	Actor      string                 `json:"actor"`
+	At         time.Time              `json:"at"`
+	Version    int64                  `json:"version"`
+	Changes    map[string]FieldChange `json:"changes"`
+}
+
+// AuditListOptions is a history request as received from a client.
+type AuditListOptions struct {
+	Limit  int
+	Cursor string
+}
+
+// AuditListQuery is what services hand to stores when listing the history of
+// one entity, newest first. After and Before are Seq boundaries, as in
+// CarListQuery.
+type AuditListQuery struct {
+	EntityType string
+	EntityID   uuid.UUID
+	Limit      int
+	After      *int64
+	Before     *int64
+}
+
+type AuditPage struct {
+	Entries    []AuditEntry `json:"entries"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+	PrevCursor string       `json:"prev_cursor,omitempty"`
+}
+
+// AuditSort is the only order histories are listed in.
+var AuditSort = []SortField{{Field: "seq", Desc: true}}
+
+// NewAuditCursor returns a cursor pointing at entry in a history.
+func NewAuditCursor(entry AuditEntry, before bool) Cursor {
+	return Cursor{Sort: AuditSort, Values: []string{strconv.FormatInt(entry.Seq, 10)}, Before: before}
+}
+
+// AuditSeq returns the Seq boundary the cursor points at.
+func (c Cursor) AuditSeq() (int64, error) {
+	seq, err := strconv.ParseInt(c.Values[0], 10, 64)
+	if err != nil {
+		var errs ValidationErrors
+		errs.add("cursor", CodeInvalidFormat, "cursor is malformed")
+		return 0, errs
+	}
+	return seq, nil
+}
+
+// AuditState returns the fields of a car its history tracks, keyed by their
+// JSON names. The engine is tracked by id; changes to the engine itself are
+// in the engine's history. Deleting a car, even into the trash, is recorded
+// as all of them going away and restoring it as them coming back.
+func (c Car) AuditState() map[string]any {
+	return map[string]any{
+		"name":      c.Name,
+		"year":      c.Year,
+		"brand":     c.Brand,
+		"fuel_type": c.FuelType,
+		"engine_id": c.Engine.EngineID.String(),
+		"price":     c.Price,
+	}
+}
+
+// AuditState is the engine counterpart of Car.AuditState.
+func (e Engine) AuditState() map[string]any {
+	return map[string]any{
+		"displacement":  e.Displacement,
+		"noOfCylinders": e.NoOfCylinders,
+		"carRange":      e.CarRange,
+	}
+}
+
+// AuditDiff returns the fields whose values differ between two AuditState
+// results. Either may be nil, for a creation or a deletion.
+func AuditDiff(before, after map[string]any) map[string]FieldChange {
+	changes := make(map[string]FieldChange)
+	for _, state := range []map[string]any{before, after} {
+		for field := range state {
+			if before[field] != after[field] {
+				changes[field] = FieldChange{Before: before[field], After: after[field]}
+			}
+		}
+	}
+	return changes
+}
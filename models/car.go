@@ -1,8 +1,8 @@
 package models
 
 import (
-	"errors"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -18,6 +18,28 @@ type Car struct {
 	Price     float64   `json:"price"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	// Version starts at 1 and goes up by one with every change. It leads
+	// the car's ETag, followed by its engine's version.
+	Version int64 `json:"version"`
+	// DeletedAt is set while the car is in the trash.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// fields, when set, are the only fields the car marshals.
+	fields *FieldSet
+}
+
+// MaxBatchIDs is the most cars a batch get may ask for.
+const MaxBatchIDs = MaxPageSize
+
+// CarBatch answers a batch get: the cars found, in the order they were
+// asked for, and the ids of those that were not.
+type CarBatch struct {
+	Cars    []Car    `json:"cars"`
+	Missing []string `json:"missing"`
+}
+
+// RevertRequest selects the earlier version of a car to go back to.
+type RevertRequest struct {
+	Version int64 `json:"version"`
 }
 
 type CarRequest struct {
@@ -29,84 +51,78 @@ type CarRequest struct {
 	Price    float64 `json:"price"`
 }
 
-func validateName(name string) error {
+func validateName(errs *ValidationErrors, name string) {
 	if name == "" {
-		return errors.New("name is required")
+		errs.add("name", CodeRequired, "name is required")
 	}
-	return nil
 }
-func validateYear(year string) error {
+func validateYear(errs *ValidationErrors, year string) {
 	if year == "" {
-		return errors.New("year is required")
+		errs.add("year", CodeRequired, "year is required")
+		return
 	}
-	_, err := strconv.Atoi(year)
+	yearInt, err := strconv.Atoi(year)
 	if err != nil {
-		return errors.New("year must be a number")
+		errs.add("year", CodeInvalidFormat, "year must be a number")
+		return
 	}
 	currentYear := time.Now().Year()
-	if yearInt, _ := strconv.Atoi(year); yearInt < 1886 || yearInt > currentYear {
-		return errors.New("year must be between 1886 and current year")
+	if yearInt < 1886 || yearInt > currentYear {
+		errs.add("year", CodeOutOfRange, "year must be between 1886 and current year")
 	}
-	return nil
 }
-func validateBrand(brand string) error {
+func validateBrand(errs *ValidationErrors, brand string) {
 	if brand == "" {
-		return errors.New("brand is required")
+		errs.add("brand", CodeRequired, "brand is required")
 	}
-	return nil
 }
-func validateFuelType(fuelType string) error {
-	validateFuelType := []string{"Petrol", "Diesel", "Electric", "Hybrid"}
-
-	for _, v := range validateFuelType {
-		if v == fuelType {
-			return nil
-		}
+func validateFuelType(errs *ValidationErrors, fuelType string) {
+	if contains(FuelTypes, fuelType) {
+		return
 	}
-	return errors.New("fuel type must be gasoline, diesel or electric")
+	if fuelType == "" {
+		errs.add("fuel_type", CodeRequired, "fuel type is required")
+		return
+	}
+	errs.add("fuel_type", CodeInvalidChoice, "fuel type must be one of "+strings.Join(FuelTypes, ", "))
 }
 
-func validateEngine(engine Engine) error {
-	if engine.EngineID == uuid.Nil {
-		return errors.New("engine is required")
-	}
-	if engine.Displacement <= 0 {
-		return errors.New("displacement is required")
-	}
-	if engine.NoOfCylinders <= 0 {
-		return errors.New("no of cylinders is required")
-	}
-	if engine.CarRange <= 0 {
-		return errors.New("car range is required")
-	}
-	return nil
+func validateEngine(errs *ValidationErrors, engine Engine, requireID bool) {
+	if requireID && engine.EngineID == uuid.Nil {
+		errs.add("engine.engine_id", CodeRequired, "engine is required")
+	}
+	validateEngineSpec(errs, "engine", EngineRequest{
+		Displacement:  engine.Displacement,
+		NoOfCylinders: engine.NoOfCylinders,
+		CarRange:      engine.CarRange,
+	})
 }
 
-func validatePrice(price float64) error {
+func validatePrice(errs *ValidationErrors, price float64) {
 	if price <= 0 {
-		return errors.New("price is required")
+		errs.add("price", CodeOutOfRange, "price must be greater than 0")
 	}
-	return nil
 }
 
+// ValidateRequest checks every field of carReq and returns a
+// ValidationErrors listing all of the invalid ones, or nil.
 func ValidateRequest(carReq CarRequest) error {
-	if err := validateName(carReq.Name); err != nil {
-		return err
-	}
-	if err := validateYear(carReq.Year); err != nil {
-		return err
-	}
-	if err := validateBrand(carReq.Brand); err != nil {
-		return err
-	}
-	if err := validateFuelType(carReq.FuelType); err != nil {
-		return err
-	}
-	if err := validateEngine(carReq.Engine); err != nil {
-		return err
-	}
-	if err := validatePrice(carReq.Price); err != nil {
-		return err
-	}
-	return nil
+	return validateRequest(carReq, true)
+}
+
+// ValidateCreateRequest is ValidateRequest for new cars, whose engine may
+// leave engine_id empty to have the engine created along with the car.
+func ValidateCreateRequest(carReq CarRequest) error {
+	return validateRequest(carReq, false)
+}
+
+func validateRequest(carReq CarRequest, requireEngineID bool) error {
+	var errs ValidationErrors
+	validateName(&errs, carReq.Name)
+	validateYear(&errs, carReq.Year)
+	validateBrand(&errs, carReq.Brand)
+	validateFuelType(&errs, carReq.FuelType)
+	validateEngine(&errs, carReq.Engine, requireEngineID)
+	validatePrice(&errs, carReq.Price)
+	return errs.err()
 }
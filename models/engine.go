@@ -2,6 +2,9 @@ package models
 
 import (
 	"errors"
+	"fmt"
+	"golangSecond/filterexpr"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -11,42 +14,129 @@ type Engine struct {
 	Displacement  int64     `json:"displacement"`
 	NoOfCylinders int64     `json:"noOfCylinders"`
 	CarRange      int64     `json:"carRange"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	Version       int64     `json:"version,omitempty"`
+	// fields, when set, are the only fields the engine marshals.
+	fields *FieldSet
 }
+
+// EngineExprFields are the fields an engine filter expression may reference.
+var EngineExprFields = filterexpr.Fields{
+	"displacement":  filterexpr.Number,
+	"noOfCylinders": filterexpr.Number,
+	"carRange":      filterexpr.Number,
+}
+
+// EngineFieldValue returns the value of an EngineExprFields field of engine.
+func EngineFieldValue(engine Engine, field string) any {
+	switch field {
+	case "displacement":
+		return float64(engine.Displacement)
+	case "noOfCylinders":
+		return float64(engine.NoOfCylinders)
+	case "carRange":
+		return float64(engine.CarRange)
+	}
+	return nil
+}
+
 type EngineRequest struct {
 	Displacement  int64 `json:"displacement"`
 	NoOfCylinders int64 `json:"noOfCylinders"`
 	CarRange      int64 `json:"carRange"`
 }
 
+// ValidateEngineRequest checks every field of EngineReq and returns a
+// ValidationErrors listing all of the invalid ones, or nil.
 func ValidateEngineRequest(EngineReq EngineRequest) error {
-	if err := ValidateDisplacement(EngineReq.Displacement); err != nil {
-		return err
-	}
-	if err := ValidateNoOfCylinders(EngineReq.NoOfCylinders); err != nil {
-		return err
+	var errs ValidationErrors
+	validateEngineSpec(&errs, "", EngineReq)
+	return errs.err()
+}
+
+func validateEngineSpec(errs *ValidationErrors, prefix string, engineReq EngineRequest) {
+	checks := []error{
+		ValidateDisplacement(engineReq.Displacement),
+		ValidateNoOfCylinders(engineReq.NoOfCylinders),
+		ValidateCarRange(engineReq.CarRange),
 	}
-	if err := ValidateCarRange(EngineReq.CarRange); err != nil {
-		return err
+	for _, err := range checks {
+		var fieldErr FieldError
+		if errors.As(err, &fieldErr) {
+			errs.add(fieldPath(prefix, fieldErr.Field), fieldErr.Code, fieldErr.Message)
+		}
 	}
-	return nil
 }
 
 func ValidateDisplacement(displacement int64) error {
 	if displacement <= 0 {
-		return errors.New("displacement must be greater than 0")
+		return FieldError{Field: "displacement", Code: CodeOutOfRange, Message: "displacement must be greater than 0"}
 	}
 	return nil
 }
 
 func ValidateNoOfCylinders(noOfCylinders int64) error {
 	if noOfCylinders <= 0 {
-		return errors.New("no of cylinders must be greater than 0")
+		return FieldError{Field: "noOfCylinders", Code: CodeOutOfRange, Message: "no of cylinders must be greater than 0"}
 	}
 	return nil
 }
 func ValidateCarRange(carRange int64) error {
 	if carRange <= 0 {
-		return errors.New("car range must be greater than 0")
+		return FieldError{Field: "carRange", Code: CodeOutOfRange, Message: "car range must be greater than 0"}
 	}
 	return nil
 }
+
+// EngineDeleteStrategy says what happens to the cars that still use an
+// engine when it is deleted.
+type EngineDeleteStrategy string
+
+const (
+	// EngineDeleteRestrict refuses to delete an engine that cars still use.
+	EngineDeleteRestrict EngineDeleteStrategy = "restrict"
+	// EngineDeleteCascade deletes the dependent cars along with the engine.
+	EngineDeleteCascade EngineDeleteStrategy = "cascade"
+	// EngineDeleteDetach moves the dependent cars to a replacement engine.
+	EngineDeleteDetach EngineDeleteStrategy = "detach"
+)
+
+// EngineDeleteOptions controls EngineDelete. The zero value restricts.
+type EngineDeleteOptions struct {
+	Strategy EngineDeleteStrategy
+	// ReplacementID is the engine dependent cars are moved to when detaching.
+	ReplacementID string
+}
+
+// Validate checks the options for deleting the engine with the given id.
+func (o EngineDeleteOptions) Validate(id string) error {
+	var errs ValidationErrors
+	switch o.Strategy {
+	case "", EngineDeleteRestrict, EngineDeleteCascade:
+		if o.ReplacementID != "" {
+			errs.add("replacement_id", CodeInvalidChoice, "replacement_id is only allowed with the detach strategy")
+		}
+	case EngineDeleteDetach:
+		if o.ReplacementID == "" {
+			errs.add("replacement_id", CodeRequired, "replacement_id is required with the detach strategy")
+		} else if replacementID, err := uuid.Parse(o.ReplacementID); err != nil {
+			errs.add("replacement_id", CodeInvalidFormat, "replacement_id must be a UUID")
+		} else if engineID, err := uuid.Parse(id); err == nil && replacementID == engineID {
+			errs.add("replacement_id", CodeInvalidChoice, "replacement_id must differ from the engine being deleted")
+		}
+	default:
+		errs.add("strategy", CodeInvalidChoice, "strategy must be one of restrict, cascade, detach")
+	}
+	return errs.err()
+}
+
+// EngineInUseError reports the cars that prevent an engine from being
+// deleted.
+type EngineInUseError struct {
+	EngineID uuid.UUID   `json:"engine_id"`
+	CarIDs   []uuid.UUID `json:"car_ids"`
+}
+
+func (e *EngineInUseError) Error() string {
+	return fmt.Sprintf("engine %s is still used by %d car(s)", e.EngineID, len(e.CarIDs))
+}
@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// ExportPageSize is how many rows exports read from a store at a time.
+const ExportPageSize = 500
+
+// CarExportColumns are the columns of a car export, matching the cells
+// Car.ExportCells returns. They include those an import needs, so that an
+// export can be imported again.
+var CarExportColumns = []string{
+	"id", "name", "year", "brand", "fuel_type", "price",
+	"engine_id", "displacement", "no_of_cylinders", "car_range",
+	"created_at", "updated_at", "version",
+}
+
+// ExportCells returns the car as a row of an export.
+func (c Car) ExportCells() []any {
+	return []any{
+		c.ID.String(), c.Name, c.Year, c.Brand, c.FuelType, c.Price,
+		c.Engine.EngineID.String(), c.Engine.Displacement, c.Engine.NoOfCylinders, c.Engine.CarRange,
+		c.CreatedAt.UTC().Format(time.RFC3339), c.UpdatedAt.UTC().Format(time.RFC3339), c.Version,
+	}
+}
+
+// EngineExportColumns are the columns of an engine export, matching the
+// cells Engine.ExportCells returns.
+var EngineExportColumns = []string{"engine_id", "displacement", "no_of_cylinders", "car_range", "version"}
+
+// ExportCells returns the engine as a row of an export.
+func (e Engine) ExportCells() []any {
+	return []any{e.EngineID.String(), e.Displacement, e.NoOfCylinders, e.CarRange, e.Version}
+}
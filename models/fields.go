@@ -0,0 +1,309 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"strings"
+)
+
+// FieldSet is a sparse fieldset: the fields of a car or engine a client
+// asked for with ?fields= and ?include=. Stores read only those, along with
+// the ids and versions that ETags, cursors and joins need, and cars and
+// engines marked with it marshal only those. The zero value is AllFields.
+type FieldSet struct {
+	// Fields are the JSON names of the resource's own fields, nil for all of
+	// them. They always hold the id.
+	Fields []string
+	// OmitEngine leaves out a car's engine but for its id, which is only
+	// marshalled when Fields is nil.
+	OmitEngine bool
+	// EngineFields are the fields of a car's engine, nil for all of them.
+	// They always hold engine_id.
+	EngineFields []string
+}
+
+// AllFields asks for every field of a car and its engine.
+var AllFields = FieldSet{}
+
+// CarFields lists the fields of a car ?fields= may name besides its engine,
+// whose fields are named engine.<field>.
+var CarFields = []string{"id", "name", "year", "brand", "fuel_type", "price", "created_at", "updated_at", "version", "deleted_at"}
+
+// EngineFields lists the fields of an engine ?fields= may name.
+var EngineFields = []string{"engine_id", "displacement", "noOfCylinders", "carRange", "updated_at", "version"}
+
+// CarRelations lists what ?include= may embed in a car.
+var CarRelations = []string{"engine"}
+
+// ParseCarFieldSet reads the ?fields= and ?include= values of a car read.
+// includeEngine says whether the endpoint embeds the engine when neither
+// asks for it; naming engine fields includes it too.
+func ParseCarFieldSet(fields, include string, includeEngine bool) (FieldSet, error) {
+	var errs ValidationErrors
+	relations, names := splitList(include), splitList(fields)
+	if len(relations) > 0 || len(names) > 0 {
+		includeEngine = false
+	}
+	for _, relation := range relations {
+		if !slices.Contains(CarRelations, relation) {
+			errs.add("include", CodeInvalidChoice, "cannot include "+relation+", expected one of "+strings.Join(CarRelations, ", "))
+			continue
+		}
+		includeEngine = true
+	}
+
+	set := FieldSet{}
+	if len(names) > 0 {
+		set.Fields = []string{"id"}
+	}
+	allEngine := false
+	for _, name := range names {
+		switch engineField, ok := strings.CutPrefix(name, "engine."); {
+		case name == "engine":
+			includeEngine, allEngine = true, true
+		case ok && slices.Contains(EngineFields, engineField):
+			includeEngine = true
+			set.EngineFields = addField(set.EngineFields, "engine_id", engineField)
+		case !ok && slices.Contains(CarFields, name):
+			set.Fields = addField(set.Fields, name)
+		default:
+			errs.add("fields", CodeInvalidChoice, "unknown field "+name)
+		}
+	}
+	if len(errs) > 0 {
+		return FieldSet{}, errs
+	}
+	if allEngine {
+		set.EngineFields = nil
+	}
+	set.OmitEngine = !includeEngine
+	return set, nil
+}
+
+// ParseEngineFieldSet reads the ?fields= and ?include= values of an engine
+// read. Engines embed nothing, so include must be empty.
+func ParseEngineFieldSet(fields, include string) (FieldSet, error) {
+	var errs ValidationErrors
+	for _, relation := range splitList(include) {
+		errs.add("include", CodeInvalidChoice, "cannot include "+relation+", engines embed nothing")
+	}
+	set := FieldSet{}
+	for _, name := range splitList(fields) {
+		if !slices.Contains(EngineFields, name) {
+			errs.add("fields", CodeInvalidChoice, "unknown field "+name)
+			continue
+		}
+		set.Fields = addField(set.Fields, "engine_id", name)
+	}
+	if len(errs) > 0 {
+		return FieldSet{}, errs
+	}
+	return set, nil
+}
+
+// IsAll reports whether the set asks for everything, as AllFields does.
+func (s FieldSet) IsAll() bool {
+	return s.Fields == nil && !s.OmitEngine && s.EngineFields == nil
+}
+
+// Has reports whether the set asks for a field of the resource itself.
+func (s FieldSet) Has(field string) bool {
+	return s.Fields == nil || slices.Contains(s.Fields, field)
+}
+
+// HasEngine reports whether the set asks for a field of a car's engine.
+func (s FieldSet) HasEngine(field string) bool {
+	return !s.OmitEngine && (s.EngineFields == nil || slices.Contains(s.EngineFields, field))
+}
+
+// JoinsEngine reports whether reading the set needs more of a car's engine
+// than the id the car holds.
+func (s FieldSet) JoinsEngine() bool {
+	return !s.OmitEngine && (s.EngineFields == nil || len(s.EngineFields) > 1)
+}
+
+// With returns the set also asking for fields of the resource itself, for
+// reads that need them beyond what the client asked for, such as the sort
+// fields cursors are built from.
+func (s FieldSet) With(fields ...string) FieldSet {
+	if s.Fields != nil {
+		s.Fields = addField(slices.Clone(s.Fields), fields...)
+	}
+	return s
+}
+
+// WithSort is With for the fields of a sort order.
+func (s FieldSet) WithSort(sort []SortField) FieldSet {
+	for _, sf := range sort {
+		s = s.With(sf.Field)
+	}
+	return s
+}
+
+// Select returns the car marked to marshal only the fields of set. A car
+// marked with AllFields marshals as an unmarked one does.
+func (c Car) Select(set FieldSet) Car {
+	c.fields = nil
+	if !set.IsAll() {
+		c.fields = &set
+	}
+	return c
+}
+
+// Select returns the engine marked to marshal only the fields of set.
+func (e Engine) Select(set FieldSet) Engine {
+	e.fields = nil
+	if !set.IsAll() {
+		e.fields = &set
+	}
+	return e
+}
+
+// SelectCars marks every car of cars, in place, with set.
+func SelectCars(cars []Car, set FieldSet) []Car {
+	for i := range cars {
+		cars[i] = cars[i].Select(set)
+	}
+	return cars
+}
+
+// SelectEngines is the engine counterpart of SelectCars.
+func SelectEngines(engines []Engine, set FieldSet) []Engine {
+	for i := range engines {
+		engines[i] = engines[i].Select(set)
+	}
+	return engines
+}
+
+func (c Car) MarshalJSON() ([]byte, error) {
+	// car has the fields of Car but not its methods, so that marshalling
+	// it does not come back here.
+	type car Car
+	if c.fields == nil {
+		return json.Marshal(car(c))
+	}
+	set := *c.fields
+	var members []member
+	for _, name := range carMembers {
+		if name != "engine" && !set.Has(name) {
+			continue
+		}
+		switch name {
+		case "id":
+			members = append(members, member{name, c.ID})
+		case "name":
+			members = append(members, member{name, c.Name})
+		case "year":
+			members = append(members, member{name, c.Year})
+		case "brand":
+			members = append(members, member{name, c.Brand})
+		case "fuel_type":
+			members = append(members, member{name, c.FuelType})
+		case "engine":
+			switch {
+			case !set.OmitEngine && set.EngineFields == nil:
+				members = append(members, member{name, c.Engine})
+			case !set.OmitEngine:
+				members = append(members, member{name, c.Engine.Select(FieldSet{Fields: set.EngineFields})})
+			case set.Fields == nil:
+				members = append(members, member{name, c.Engine.Select(FieldSet{Fields: []string{"engine_id"}})})
+			}
+		case "price":
+			members = append(members, member{name, c.Price})
+		case "created_at":
+			members = append(members, member{name, c.CreatedAt})
+		case "updated_at":
+			members = append(members, member{name, c.UpdatedAt})
+		case "version":
+			members = append(members, member{name, c.Version})
+		case "deleted_at":
+			// Left out when unset unless asked for, as in a whole car.
+			if c.DeletedAt != nil || set.Fields != nil {
+				members = append(members, member{name, c.DeletedAt})
+			}
+		}
+	}
+	return marshalMembers(members)
+}
+
+// carMembers is the order a car's fields are marshalled in.
+var carMembers = []string{"id", "name", "year", "brand", "fuel_type", "engine", "price", "created_at", "updated_at", "version", "deleted_at"}
+
+func (e Engine) MarshalJSON() ([]byte, error) {
+	type engine Engine
+	if e.fields == nil {
+		return json.Marshal(engine(e))
+	}
+	var members []member
+	for _, name := range EngineFields {
+		if !e.fields.Has(name) {
+			continue
+		}
+		switch name {
+		case "engine_id":
+			members = append(members, member{name, e.EngineID})
+		case "displacement":
+			members = append(members, member{name, e.Displacement})
+		case "noOfCylinders":
+			members = append(members, member{name, e.NoOfCylinders})
+		case "carRange":
+			members = append(members, member{name, e.CarRange})
+		case "updated_at":
+			members = append(members, member{name, e.UpdatedAt})
+		case "version":
+			members = append(members, member{name, e.Version})
+		}
+	}
+	return marshalMembers(members)
+}
+
+// member is one name and value of a JSON object.
+type member struct {
+	name  string
+	value any
+}
+
+// marshalMembers writes members as a JSON object, keeping their order.
+func marshalMembers(members []member) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, m := range members {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, err := json.Marshal(m.name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(m.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// addField appends the fields not already in list.
+func addField(list []string, fields ...string) []string {
+	for _, field := range fields {
+		if !slices.Contains(list, field) {
+			list = append(list, field)
+		}
+	}
+	return list
+}
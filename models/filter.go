@@ -0,0 +1,170 @@
+package models
+
+import (
+	"strconv"
+	"strings"
+
+	"golangSecond/filterexpr"
+)
+
+// FuelTypes lists the accepted values of Car.FuelType.
+var FuelTypes = []string{"Petrol", "Diesel", "Electric", "Hybrid"}
+
+// CarFilter narrows a car listing. Nil bounds and empty values are ignored;
+// ranges are inclusive.
+type CarFilter struct {
+	NameContains    string
+	FuelTypes       []string
+	PriceMin        *float64
+	PriceMax        *float64
+	YearMin         *int
+	YearMax         *int
+	DisplacementMin *int64
+	DisplacementMax *int64
+	CylindersMin    *int64
+	CylindersMax    *int64
+	RangeMin        *int64
+	RangeMax        *int64
+
+	// Expr is an optional filter expression checked against CarExprFields.
+	Expr *filterexpr.Expr
+}
+
+// CarExprFields are the fields a car filter expression may reference.
+var CarExprFields = filterexpr.Fields{
+	"name":                 filterexpr.String,
+	"brand":                filterexpr.String,
+	"fuel_type":            filterexpr.String,
+	"year":                 filterexpr.Number,
+	"price":                filterexpr.Number,
+	"engine.displacement":  filterexpr.Number,
+	"engine.noOfCylinders": filterexpr.Number,
+	"engine.carRange":      filterexpr.Number,
+}
+
+// CarFieldValue returns the value of a CarExprFields field of car.
+func CarFieldValue(car Car, field string) any {
+	switch field {
+	case "name":
+		return car.Name
+	case "brand":
+		return car.Brand
+	case "fuel_type":
+		return car.FuelType
+	case "year":
+		year, _ := strconv.ParseFloat(car.Year, 64)
+		return year
+	case "price":
+		return car.Price
+	case "engine.displacement":
+		return float64(car.Engine.Displacement)
+	case "engine.noOfCylinders":
+		return float64(car.Engine.NoOfCylinders)
+	case "engine.carRange":
+		return float64(car.Engine.CarRange)
+	}
+	return nil
+}
+
+// Validate reports unknown fuel types and ranges whose minimum exceeds
+// their maximum.
+func (f CarFilter) Validate() error {
+	var errs ValidationErrors
+	for _, fuelType := range f.FuelTypes {
+		if !contains(FuelTypes, fuelType) {
+			errs.add("fuel_type", CodeInvalidChoice, "fuel type must be one of "+strings.Join(FuelTypes, ", "))
+			break
+		}
+	}
+	checkRange(&errs, "price", f.PriceMin, f.PriceMax)
+	checkRange(&errs, "year", f.YearMin, f.YearMax)
+	checkRange(&errs, "displacement", f.DisplacementMin, f.DisplacementMax)
+	checkRange(&errs, "cylinders", f.CylindersMin, f.CylindersMax)
+	checkRange(&errs, "range", f.RangeMin, f.RangeMax)
+	return errs.err()
+}
+
+// Matches reports whether car passes the filter. The car's engine must be
+// populated for the engine bounds to apply.
+func (f CarFilter) Matches(car Car) bool {
+	if f.NameContains != "" && !strings.Contains(strings.ToLower(car.Name), strings.ToLower(f.NameContains)) {
+		return false
+	}
+	if len(f.FuelTypes) > 0 && !contains(f.FuelTypes, car.FuelType) {
+		return false
+	}
+	year, _ := strconv.Atoi(car.Year)
+	if !inRange(car.Price, f.PriceMin, f.PriceMax) ||
+		!inRange(year, f.YearMin, f.YearMax) ||
+		!inRange(car.Engine.Displacement, f.DisplacementMin, f.DisplacementMax) ||
+		!inRange(car.Engine.NoOfCylinders, f.CylindersMin, f.CylindersMax) ||
+		!inRange(car.Engine.CarRange, f.RangeMin, f.RangeMax) {
+		return false
+	}
+	if f.Expr != nil {
+		return f.Expr.Eval(func(field string) any { return CarFieldValue(car, field) })
+	}
+	return true
+}
+
+// UsesEngine reports whether the filter tests fields of the car's engine,
+// so that a listing needs the engine even when it does not return it.
+func (f CarFilter) UsesEngine() bool {
+	if f.DisplacementMin != nil || f.DisplacementMax != nil || f.CylindersMin != nil ||
+		f.CylindersMax != nil || f.RangeMin != nil || f.RangeMax != nil {
+		return true
+	}
+	return f.Expr != nil && f.Expr.References(func(field string) bool {
+		return strings.HasPrefix(field, "engine.")
+	})
+}
+
+func checkRange[T int | int64 | float64](errs *ValidationErrors, field string, min, max *T) {
+	if min != nil && max != nil && *min > *max {
+		errs.add(field, CodeOutOfRange, field+"_min must not be greater than "+field+"_max")
+	}
+}
+
+func inRange[T int | int64 | float64](v T, min, max *T) bool {
+	if min != nil && v < *min {
+		return false
+	}
+	if max != nil && v > *max {
+		return false
+	}
+	return true
+}
+
+// EngineFilter narrows an engine listing. Nil bounds are ignored; ranges
+// are inclusive.
+type EngineFilter struct {
+	DisplacementMin *int64
+	DisplacementMax *int64
+	CylindersMin    *int64
+	CylindersMax    *int64
+	RangeMin        *int64
+	RangeMax        *int64
+
+	// Expr is an optional filter expression checked against EngineExprFields.
+	Expr *filterexpr.Expr
+}
+
+func (f EngineFilter) Validate() error {
+	var errs ValidationErrors
+	checkRange(&errs, "displacement", f.DisplacementMin, f.DisplacementMax)
+	checkRange(&errs, "cylinders", f.CylindersMin, f.CylindersMax)
+	checkRange(&errs, "range", f.RangeMin, f.RangeMax)
+	return errs.err()
+}
+
+func (f EngineFilter) Matches(engine Engine) bool {
+	if !inRange(engine.Displacement, f.DisplacementMin, f.DisplacementMax) ||
+		!inRange(engine.NoOfCylinders, f.CylindersMin, f.CylindersMax) ||
+		!inRange(engine.CarRange, f.RangeMin, f.RangeMax) {
+		return false
+	}
+	if f.Expr != nil {
+		return f.Expr.Eval(func(field string) any { return EngineFieldValue(engine, field) })
+	}
+	return true
+}
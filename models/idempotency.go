@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// MaxIdempotencyKeyLength is the longest idempotency key accepted.
+const MaxIdempotencyKeyLength = 255
+
+// IdempotencyRecord is what is kept of a request made with an idempotency
+// key. Keys belong to the actor that made the request, so that one client
+// cannot replay another's responses. Fingerprint identifies the request, so
+// that the key cannot be reused for a different one. Response is nil while
+// the request is running.
+type IdempotencyRecord struct {
+	Actor       string
+	Key         string
+	Fingerprint string
+	Response    *StoredResponse
+	ExpiresAt   time.Time
+}
+
+// StoredResponse is a response kept to be replayed to retries.
+type StoredResponse struct {
+	Status int                 `json:"status"`
+	Header map[string][]string `json:"header"`
+	Body   []byte              `json:"body"`
+}
@@ -0,0 +1,69 @@
+package models
+
+// ImportMode says how an import handles rows that cannot be created.
+type ImportMode string
+
+const (
+	// ImportAtomic creates every row or none: one invalid row rejects the
+	// whole import.
+	ImportAtomic ImportMode = "atomic"
+	// ImportPerRow creates each valid row on its own and reports the others.
+	ImportPerRow ImportMode = "per_row"
+)
+
+const (
+	// MaxImportRows is the most rows one import may hold.
+	MaxImportRows = 10000
+	// ImportBatchSize is how many cars an atomic import inserts at a time.
+	ImportBatchSize = 500
+)
+
+// ImportOptions is an import request as received from a client. The zero
+// value imports atomically.
+type ImportOptions struct {
+	Mode   ImportMode
+	DryRun bool
+}
+
+// Validate checks the options of an import.
+func (o ImportOptions) Validate() error {
+	var errs ValidationErrors
+	switch o.Mode {
+	case "", ImportAtomic, ImportPerRow:
+	default:
+		errs.add("mode", CodeInvalidChoice, "mode must be one of atomic, per_row")
+	}
+	return errs.err()
+}
+
+// ImportRow is one car read from an import file. Line is where it starts in
+// the file; Errors holds what was wrong with the row before it could even
+// be validated, such as a price that is not a number.
+type ImportRow struct {
+	Line   int
+	Car    CarRequest
+	Errors ValidationErrors
+}
+
+// ImportedCar is a row an import created, or with a dry run would create.
+type ImportedCar struct {
+	Line int    `json:"line"`
+	ID   string `json:"id,omitempty"`
+}
+
+// ImportRowError lists everything wrong with one row of an import.
+type ImportRowError struct {
+	Line   int          `json:"line"`
+	Errors []FieldError `json:"errors"`
+}
+
+// ImportReport is the outcome of an import. Created lists the rows that
+// were created, or for a dry run the rows that would be; Errors lists the
+// others. An atomic import with any errors creates nothing.
+type ImportReport struct {
+	Mode    ImportMode       `json:"mode"`
+	DryRun  bool             `json:"dry_run"`
+	Total   int              `json:"total"`
+	Created []ImportedCar    `json:"created"`
+	Errors  []ImportRowError `json:"errors"`
+}
@@ -0,0 +1,349 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// CarSortFields lists the fields cars can be ordered by.
+var CarSortFields = []string{"price", "year", "created_at", "name"}
+
+// EngineSortFields lists the fields engines can be ordered by.
+var EngineSortFields = []string{"displacement", "noOfCylinders", "carRange"}
+
+type SortField struct {
+	Field string `json:"f"`
+	Desc  bool   `json:"d,omitempty"`
+}
+
+// CarListOptions is a listing request as received from a client.
+type CarListOptions struct {
+	Limit  int
+	Sort   string
+	Cursor string
+	Filter CarFilter
+	// Expression is a filterexpr expression, e.g. `price < 30000`.
+	Expression string
+	Fields     FieldSet
+}
+
+// CarListQuery is what services hand to stores when listing cars. At most
+// one of After and Before is set; both are keyset boundaries and the row
+// itself is excluded. Stores always return rows in Sort order.
+type CarListQuery struct {
+	Filter CarFilter
+	Sort   []SortField
+	Limit  int
+	After  *Car
+	Before *Car
+	// Deleted lists the cars in the trash instead of the live ones.
+	Deleted bool
+	Fields  FieldSet
+}
+
+type CarPage struct {
+	Cars       []Car  `json:"cars"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+}
+
+// EngineListOptions is an engine listing request as received from a client.
+type EngineListOptions struct {
+	Limit      int
+	Sort       string
+	Cursor     string
+	Filter     EngineFilter
+	Expression string
+	Fields     FieldSet
+}
+
+// EngineListQuery is the engine counterpart of CarListQuery.
+type EngineListQuery struct {
+	Filter EngineFilter
+	Sort   []SortField
+	Limit  int
+	After  *Engine
+	Before *Engine
+	Fields FieldSet
+}
+
+type EnginePage struct {
+	Engines    []Engine `json:"engines"`
+	NextCursor string   `json:"next_cursor,omitempty"`
+	PrevCursor string   `json:"prev_cursor,omitempty"`
+}
+
+// Cursor marks the position of a car within a sorted listing.
+type Cursor struct {
+	Sort   []SortField `json:"s"`
+	Values []string    `json:"v"`
+	ID     uuid.UUID   `json:"id"`
+	Before bool        `json:"b,omitempty"`
+}
+
+// ParseSort parses a comma separated sort spec such as "-price,name", where a
+// leading minus means descending. An empty spec yields defaultSort.
+func ParseSort(raw string, allowed []string, defaultSort ...SortField) ([]SortField, error) {
+	var errs ValidationErrors
+	var sort []SortField
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		field := SortField{Field: strings.TrimPrefix(part, "-"), Desc: strings.HasPrefix(part, "-")}
+		if !contains(allowed, field.Field) {
+			errs.add("sort", CodeInvalidChoice, "cannot sort by "+field.Field+", expected one of "+strings.Join(allowed, ", "))
+			continue
+		}
+		if seen[field.Field] {
+			continue
+		}
+		seen[field.Field] = true
+		sort = append(sort, field)
+	}
+	if len(sort) == 0 && len(errs) == 0 {
+		sort = defaultSort
+	}
+	return sort, errs.err()
+}
+
+// ValidatePageSize applies the default page size and rejects sizes outside
+// 1..MaxPageSize.
+func ValidatePageSize(limit int) (int, error) {
+	if limit == 0 {
+		return DefaultPageSize, nil
+	}
+	if limit < 1 || limit > MaxPageSize {
+		var errs ValidationErrors
+		errs.add("limit", CodeOutOfRange, "limit must be between 1 and "+strconv.Itoa(MaxPageSize))
+		return 0, errs
+	}
+	return limit, nil
+}
+
+// NewCarCursor returns a cursor pointing at car for the given sort order.
+func NewCarCursor(car Car, sort []SortField, before bool) Cursor {
+	values := make([]string, len(sort))
+	for i, s := range sort {
+		values[i] = carSortValue(car, s.Field)
+	}
+	return Cursor{Sort: sort, Values: values, ID: car.ID, Before: before}
+}
+
+// NewEngineCursor returns a cursor pointing at engine for the given sort order.
+func NewEngineCursor(engine Engine, sort []SortField, before bool) Cursor {
+	values := make([]string, len(sort))
+	for i, s := range sort {
+		values[i] = strconv.FormatInt(engineSortValue(engine, s.Field), 10)
+	}
+	return Cursor{Sort: sort, Values: values, ID: engine.EngineID, Before: before}
+}
+
+func (c Cursor) Encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func DecodeCursor(encoded string) (Cursor, error) {
+	var c Cursor
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err == nil {
+		err = json.Unmarshal(raw, &c)
+	}
+	if err != nil || len(c.Values) != len(c.Sort) {
+		var errs ValidationErrors
+		errs.add("cursor", CodeInvalidFormat, "cursor is malformed")
+		return c, errs
+	}
+	return c, nil
+}
+
+// Car rebuilds the keyset boundary the cursor points at. Only the sort
+// fields and the ID are set.
+func (c Cursor) Car() (Car, error) {
+	car := Car{ID: c.ID}
+	for i, s := range c.Sort {
+		if err := setCarSortValue(&car, s.Field, c.Values[i]); err != nil {
+			var errs ValidationErrors
+			errs.add("cursor", CodeInvalidFormat, "cursor is malformed")
+			return car, errs
+		}
+	}
+	return car, nil
+}
+
+// Engine rebuilds the keyset boundary the cursor points at. Only the sort
+// fields and the ID are set.
+func (c Cursor) Engine() (Engine, error) {
+	engine := Engine{EngineID: c.ID}
+	for i, s := range c.Sort {
+		v, err := strconv.ParseInt(c.Values[i], 10, 64)
+		if err != nil {
+			var errs ValidationErrors
+			errs.add("cursor", CodeInvalidFormat, "cursor is malformed")
+			return engine, errs
+		}
+		switch s.Field {
+		case "displacement":
+			engine.Displacement = v
+		case "noOfCylinders":
+			engine.NoOfCylinders = v
+		case "carRange":
+			engine.CarRange = v
+		}
+	}
+	return engine, nil
+}
+
+// SameSort reports whether the cursor was issued for the given sort order.
+func (c Cursor) SameSort(sort []SortField) bool {
+	if len(c.Sort) != len(sort) {
+		return false
+	}
+	for i := range sort {
+		if c.Sort[i] != sort[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// CompareCars orders a and b by sort, falling back to the ID so that the
+// order is total. It returns -1, 0 or 1.
+func CompareCars(a, b Car, sort []SortField) int {
+	for _, s := range sort {
+		c := compareCarField(a, b, s.Field)
+		if s.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return strings.Compare(a.ID.String(), b.ID.String())
+}
+
+// CompareEngines is the engine counterpart of CompareCars.
+func CompareEngines(a, b Engine, sort []SortField) int {
+	for _, s := range sort {
+		c := compareOrdered(engineSortValue(a, s.Field), engineSortValue(b, s.Field))
+		if s.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return strings.Compare(a.EngineID.String(), b.EngineID.String())
+}
+
+// TrimPage turns the rows a store returned for a listing into a page. The
+// store is asked for limit+1 rows so that the extra row tells whether there
+// is more data in the direction of travel; cursor is the one the request
+// came with, if any. encode builds a cursor for a row.
+func TrimPage[T any](rows []T, limit int, cursor *Cursor, encode func(row T, before bool) string) (page []T, next, prev string) {
+	backward := cursor != nil && cursor.Before
+	hasMore := len(rows) > limit
+	if hasMore && backward {
+		rows = rows[1:]
+	} else if hasMore {
+		rows = rows[:limit]
+	}
+	if len(rows) == 0 {
+		return []T{}, "", ""
+	}
+	first, last := rows[0], rows[len(rows)-1]
+	if hasMore || backward {
+		next = encode(last, false)
+	}
+	if (cursor != nil && !backward) || (backward && hasMore) {
+		prev = encode(first, true)
+	}
+	return rows, next, prev
+}
+
+func engineSortValue(engine Engine, field string) int64 {
+	switch field {
+	case "displacement":
+		return engine.Displacement
+	case "noOfCylinders":
+		return engine.NoOfCylinders
+	case "carRange":
+		return engine.CarRange
+	}
+	return 0
+}
+
+func compareCarField(a, b Car, field string) int {
+	switch field {
+	case "price":
+		return compareOrdered(a.Price, b.Price)
+	case "year":
+		return strings.Compare(a.Year, b.Year)
+	case "created_at":
+		return a.CreatedAt.Compare(b.CreatedAt)
+	case "name":
+		return strings.Compare(a.Name, b.Name)
+	}
+	return 0
+}
+
+func carSortValue(car Car, field string) string {
+	switch field {
+	case "price":
+		return strconv.FormatFloat(car.Price, 'f', -1, 64)
+	case "year":
+		return car.Year
+	case "created_at":
+		return car.CreatedAt.Format(time.RFC3339Nano)
+	case "name":
+		return car.Name
+	}
+	return ""
+}
+
+func setCarSortValue(car *Car, field, value string) error {
+	var err error
+	switch field {
+	case "price":
+		car.Price, err = strconv.ParseFloat(value, 64)
+	case "year":
+		car.Year = value
+	case "created_at":
+		car.CreatedAt, err = time.Parse(time.RFC3339Nano, value)
+	case "name":
+		car.Name = value
+	}
+	return err
+}
+
+func compareOrdered[T int64 | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package models
+
+import "github.com/google/uuid"
+
+// PatchType is the format of a PATCH request body, named by its media type.
+type PatchType string
+
+const (
+	MergePatch PatchType = "application/merge-patch+json"
+	JSONPatch  PatchType = "application/json-patch+json"
+)
+
+// Patch is a PATCH request body.
+type Patch struct {
+	Type PatchType
+	Body []byte
+}
+
+// CarPatch holds the car columns a partial update changes. Nil fields are
+// left as they are.
+type CarPatch struct {
+	Name     *string
+	Year     *string
+	Brand    *string
+	FuelType *string
+	EngineID *uuid.UUID
+	Price    *float64
+}
+
+func (p CarPatch) IsEmpty() bool {
+	return p == CarPatch{}
+}
+
+// DiffCar returns the columns that differ between current and next. The
+// engine is compared by id only, since a car just references it.
+func DiffCar(current Car, next CarRequest) CarPatch {
+	var p CarPatch
+	if next.Name != current.Name {
+		p.Name = &next.Name
+	}
+	if next.Year != current.Year {
+		p.Year = &next.Year
+	}
+	if next.Brand != current.Brand {
+		p.Brand = &next.Brand
+	}
+	if next.FuelType != current.FuelType {
+		p.FuelType = &next.FuelType
+	}
+	if next.Engine.EngineID != current.Engine.EngineID {
+		p.EngineID = &next.Engine.EngineID
+	}
+	if next.Price != current.Price {
+		p.Price = &next.Price
+	}
+	return p
+}
+
+// Apply returns car with the patch applied.
+func (p CarPatch) Apply(car Car) Car {
+	if p.Name != nil {
+		car.Name = *p.Name
+	}
+	if p.Year != nil {
+		car.Year = *p.Year
+	}
+	if p.Brand != nil {
+		car.Brand = *p.Brand
+	}
+	if p.FuelType != nil {
+		car.FuelType = *p.FuelType
+	}
+	if p.EngineID != nil {
+		car.Engine = Engine{EngineID: *p.EngineID}
+	}
+	if p.Price != nil {
+		car.Price = *p.Price
+	}
+	return car
+}
+
+// EnginePatch is the engine counterpart of CarPatch.
+type EnginePatch struct {
+	Displacement  *int64
+	NoOfCylinders *int64
+	CarRange      *int64
+}
+
+func (p EnginePatch) IsEmpty() bool {
+	return p == EnginePatch{}
+}
+
+// DiffEngine returns the columns that differ between current and next.
+func DiffEngine(current Engine, next EngineRequest) EnginePatch {
+	var p EnginePatch
+	if next.Displacement != current.Displacement {
+		p.Displacement = &next.Displacement
+	}
+	if next.NoOfCylinders != current.NoOfCylinders {
+		p.NoOfCylinders = &next.NoOfCylinders
+	}
+	if next.CarRange != current.CarRange {
+		p.CarRange = &next.CarRange
+	}
+	return p
+}
+
+// Apply returns engine with the patch applied.
+func (p EnginePatch) Apply(engine Engine) Engine {
+	if p.Displacement != nil {
+		engine.Displacement = *p.Displacement
+	}
+	if p.NoOfCylinders != nil {
+		engine.NoOfCylinders = *p.NoOfCylinders
+	}
+	if p.CarRange != nil {
+		engine.CarRange = *p.CarRange
+	}
+	return engine
+}
+
+// ToRequest returns the request that would recreate car as it is, which is
+// the document PATCH requests are applied to.
+func (c Car) ToRequest() CarRequest {
+	return CarRequest{
+		Name:     c.Name,
+		Year:     c.Year,
+		Brand:    c.Brand,
+		FuelType: c.FuelType,
+		Engine:   c.Engine,
+		Price:    c.Price,
+	}
+}
+
+// ToRequest is the engine counterpart of Car.ToRequest.
+func (e Engine) ToRequest() EngineRequest {
+	return EngineRequest{
+		Displacement:  e.Displacement,
+		NoOfCylinders: e.NoOfCylinders,
+		CarRange:      e.CarRange,
+	}
+}
@@ -0,0 +1,24 @@
+package models
+
+// Precondition is a parsed If-Match header: the versions of a resource a
+// write is allowed to replace. The zero value places no condition.
+type Precondition struct {
+	// Present is set when the request carried If-Match at all.
+	Present bool
+	// Any is set for If-Match: *, which matches every existing version.
+	Any      bool
+	Versions []int64
+}
+
+// Matches reports whether a resource at version satisfies the precondition.
+func (p Precondition) Matches(version int64) bool {
+	if !p.Present || p.Any {
+		return true
+	}
+	for _, v := range p.Versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package models
+
+import "strings"
+
+// Validation error codes returned to clients alongside each field path.
+const (
+	CodeRequired      = "required"
+	CodeInvalidFormat = "invalid_format"
+	CodeOutOfRange    = "out_of_range"
+	CodeInvalidChoice = "invalid_choice"
+)
+
+// FieldError describes a single invalid field, e.g. engine.displacement.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (f FieldError) Error() string {
+	return f.Field + ": " + f.Message
+}
+
+// ValidationErrors is the full report of a failed validation. It is only
+// ever returned as an error when it holds at least one entry.
+type ValidationErrors []FieldError
+
+func (v ValidationErrors) Error() string {
+	messages := make([]string, len(v))
+	for i, f := range v {
+		messages[i] = f.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (v *ValidationErrors) add(field, code, message string) {
+	*v = append(*v, FieldError{Field: field, Code: code, Message: message})
+}
+
+func (v ValidationErrors) err() error {
+	if len(v) == 0 {
+		return nil
+	}
+	return v
+}
+
+func fieldPath(prefix, field string) string {
+	if prefix == "" {
+		return field
+	}
+	return prefix + "." + field
+}
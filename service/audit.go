@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+
+	"golangSecond/apperrors"
+	"golangSecond/models"
+	"golangSecond/store"
+
+	"github.com/google/uuid"
+)
+
+type actorKey struct{}
+
+// SystemActor is recorded for changes made without a request actor, such
+// as those by background jobs.
+const SystemActor = "system"
+
+// WithActor returns a context whose changes are recorded as made by actor.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// Actor returns who changes made with ctx are recorded as made by.
+func Actor(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return SystemActor
+}
+
+// Audit records a change to an entity. before and after are AuditState
+// results, nil for a creation or a deletion; version is the entity's
+// version after the change. Call it with the context of the unit of work
+// making the change, so that the entry and the change commit together.
+func Audit(ctx context.Context, audits store.AuditStoreInterface, entityType string, id uuid.UUID, action models.AuditAction, version int64, before, after map[string]any) error {
+	_, err := audits.RecordAudit(ctx, models.AuditEntry{
+		EntityType: entityType,
+		EntityID:   id,
+		Action:     action,
+		Actor:      Actor(ctx),
+		Version:    version,
+		Changes:    models.AuditDiff(before, after),
+	})
+	return err
+}
+
+// History returns one page of an entity's audit trail, newest first.
+func History(ctx context.Context, audits store.AuditStoreInterface, entityType, id string, opts models.AuditListOptions) (*models.AuditPage, error) {
+	entityID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, apperrors.Validation("invalid %s id %q", entityType, id)
+	}
+	limit, err := models.ValidatePageSize(opts.Limit)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.KindValidation, err)
+	}
+	query := models.AuditListQuery{EntityType: entityType, EntityID: entityID, Limit: limit + 1}
+	cursor, err := DecodeCursor(opts.Cursor, models.AuditSort)
+	if err != nil {
+		return nil, err
+	}
+	if cursor != nil {
+		seq, err := cursor.AuditSeq()
+		if err != nil {
+			return nil, apperrors.Wrap(apperrors.KindValidation, err)
+		}
+		if cursor.Before {
+			query.Before = &seq
+		} else {
+			query.After = &seq
+		}
+	}
+
+	entries, err := audits.ListAudit(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	page := &models.AuditPage{}
+	page.Entries, page.NextCursor, page.PrevCursor = models.TrimPage(entries, limit, cursor, func(entry models.AuditEntry, before bool) string {
+		return models.NewAuditCursor(entry, before).Encode()
+	})
+	return page, nil
+}
@@ -2,60 +2,626 @@ package car
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"golangSecond/apperrors"
 	"golangSecond/models"
+	"golangSecond/service"
 	"golangSecond/store"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
 )
 
 type CarService struct {
-	store store.CarStoreInterface
+	store       store.CarStoreInterface
+	engineStore store.EngineStoreInterface
+	audit       store.AuditStoreInterface
+	tx          store.TxManager
 }
 
-func NewCarService(store store.CarStoreInterface) *CarService {
+func NewCarService(store store.CarStoreInterface, engineStore store.EngineStoreInterface, audit store.AuditStoreInterface, tx store.TxManager) *CarService {
 	return &CarService{
-		store: store,
+		store:       store,
+		engineStore: engineStore,
+		audit:       audit,
+		tx:          tx,
 	}
 }
 
-func (s *CarService) GetCarByID(ctx context.Context, id string) (*models.Car, error) {
-	car, err := s.store.GetCarById(ctx, id)
+// GetCarByID returns a car with the fields asked for.
+func (s *CarService) GetCarByID(ctx context.Context, id string, fields models.FieldSet) (*models.Car, error) {
+	car, err := s.store.GetCarById(ctx, id, fields)
 	if err != nil {
 		return nil, err
 	}
+	car = car.Select(fields)
 	return &car, nil
 }
-func (s *CarService) GetCarByBrand(ctx context.Context, brand string, isEngine bool) ([]models.Car, error) {
-	cars, err := s.store.GetCarByBrand(ctx, brand, isEngine)
+
+// GetCarsByIDs fetches several cars, with the fields asked for, in one
+// store call.
+// The cars come back in the order of ids, each once however often it was
+// asked for; ids of cars that do not exist or are in the trash are listed
+// in Missing.
+func (s *CarService) GetCarsByIDs(ctx context.Context, ids []string, fields models.FieldSet) (*models.CarBatch, error) {
+	if len(ids) == 0 || len(ids) > models.MaxBatchIDs {
+		return nil, apperrors.Validation("ids must list 1 to %d car ids", models.MaxBatchIDs)
+	}
+	parsed := make([]uuid.UUID, 0, len(ids))
+	seen := make(map[uuid.UUID]bool, len(ids))
+	for _, id := range ids {
+		carID, err := uuid.Parse(id)
+		if err != nil {
+			return nil, apperrors.Validation("invalid car id %q", id)
+		}
+		if !seen[carID] {
+			seen[carID] = true
+			parsed = append(parsed, carID)
+		}
+	}
+
+	cars, err := s.store.GetCarsByIDs(ctx, parsed, fields)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[uuid.UUID]models.Car, len(cars))
+	for _, car := range cars {
+		found[car.ID] = car.Select(fields)
+	}
+	batch := &models.CarBatch{Cars: []models.Car{}, Missing: []string{}}
+	for _, id := range parsed {
+		if car, ok := found[id]; ok {
+			batch.Cars = append(batch.Cars, car)
+		} else {
+			batch.Missing = append(batch.Missing, id.String())
+		}
+	}
+	return batch, nil
+}
+
+// GetCarAsOf returns a car, with its engine, as they were at the given
+// moment. Versions are always read whole; fields only trims what the car
+// marshals.
+func (s *CarService) GetCarAsOf(ctx context.Context, id string, at time.Time, fields models.FieldSet) (*models.Car, error) {
+	car, err := s.store.GetCarAsOf(ctx, id, at)
 	if err != nil {
 		return nil, err
 	}
-	return cars, nil
+	car = car.Select(fields)
+	return &car, nil
 }
 
-func (s *CarService) CreateCar(ctx context.Context, car *models.CarRequest) (*models.Car, error) {
-	if err := models.ValidateRequest(*car); err != nil {
+func (s *CarService) GetCarByBrand(ctx context.Context, brand string, fields models.FieldSet) ([]models.Car, error) {
+	cars, err := s.store.GetCarByBrand(ctx, brand, fields)
+	if err != nil {
 		return nil, err
 	}
-	createdCar, err := s.store.CreateCar(ctx, car)
+	return models.SelectCars(cars, fields), nil
+}
+
+// CreateCar creates a car. If the request's engine has no engine_id, the
+// engine is created from its spec in the same transaction as the car.
+func (s *CarService) CreateCar(ctx context.Context, car *models.CarRequest) (*models.Car, error) {
+	if err := models.ValidateCreateRequest(*car); err != nil {
+		return nil, apperrors.Wrap(apperrors.KindValidation, err)
+	}
+	var createdCar models.Car
+	err := s.tx.WithinTx(ctx, func(ctx context.Context) error {
+		carReq := *car
+		if carReq.Engine.EngineID == uuid.Nil {
+			engine, err := s.engineStore.EngineCreate(ctx, &models.EngineRequest{
+				Displacement:  carReq.Engine.Displacement,
+				NoOfCylinders: carReq.Engine.NoOfCylinders,
+				CarRange:      carReq.Engine.CarRange,
+			})
+			if err != nil {
+				return err
+			}
+			if err := service.Audit(ctx, s.audit, models.AuditEngine, engine.EngineID, models.AuditCreate, engine.Version, nil, engine.AuditState()); err != nil {
+				return err
+			}
+			carReq.Engine = engine
+		}
+		var err error
+		if createdCar, err = s.store.CreateCar(ctx, &carReq); err != nil {
+			return err
+		}
+		return service.Audit(ctx, s.audit, models.AuditCar, createdCar.ID, models.AuditCreate, createdCar.Version, nil, createdCar.AuditState())
+	})
 	if err != nil {
 		return nil, err
 	}
 	return &createdCar, nil
 }
-func (s *CarService) UpdateCar(ctx context.Context, id string, carReq *models.CarRequest) (*models.Car, error) {
-	if err := models.ValidateRequest(*carReq); err != nil {
+
+// ImportCars creates the cars read from an import file. Every row is
+// validated and has its engine resolved first. An atomic import then
+// creates all rows in batches within one transaction, or none if any row is
+// invalid; a per-row import creates each valid row in its own transaction.
+// A dry run stops after validating.
+func (s *CarService) ImportCars(ctx context.Context, rows []models.ImportRow, opts models.ImportOptions) (*models.ImportReport, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, apperrors.Wrap(apperrors.KindValidation, err)
+	}
+	if opts.Mode == "" {
+		opts.Mode = models.ImportAtomic
+	}
+	if len(rows) == 0 {
+		return nil, apperrors.Validation("the import holds no rows")
+	}
+	if len(rows) > models.MaxImportRows {
+		return nil, apperrors.Validation("an import may hold at most %d rows", models.MaxImportRows)
+	}
+
+	report := &models.ImportReport{Mode: opts.Mode, DryRun: opts.DryRun, Total: len(rows), Created: []models.ImportedCar{}, Errors: []models.ImportRowError{}}
+	var valid []models.ImportRow
+	engines := make(map[uuid.UUID]*models.Engine)
+	for _, row := range rows {
+		errs, err := s.checkImportRow(ctx, &row, engines)
+		if err != nil {
+			return nil, err
+		}
+		if len(errs) > 0 {
+			report.Errors = append(report.Errors, models.ImportRowError{Line: row.Line, Errors: errs})
+			continue
+		}
+		valid = append(valid, row)
+	}
+
+	switch {
+	case opts.DryRun:
+		for _, row := range valid {
+			report.Created = append(report.Created, models.ImportedCar{Line: row.Line})
+		}
+	case opts.Mode == models.ImportPerRow:
+		for _, row := range valid {
+			car, err := s.importRow(ctx, row)
+			if err != nil && !rowError(err) {
+				return nil, err
+			}
+			if err != nil {
+				report.Errors = append(report.Errors, models.ImportRowError{Line: row.Line, Errors: fieldErrors(err)})
+				continue
+			}
+			report.Created = append(report.Created, models.ImportedCar{Line: row.Line, ID: car.ID.String()})
+		}
+		sort.Slice(report.Errors, func(i, j int) bool { return report.Errors[i].Line < report.Errors[j].Line })
+	case len(report.Errors) == 0:
+		created, err := s.importBatches(ctx, valid)
+		if err != nil {
+			return nil, err
+		}
+		report.Created = created
+	}
+	return report, nil
+}
+
+// checkImportRow validates row, filling in its engine from the store. It
+// returns what is wrong with the row, fields that could not even be read
+// first; engines caches the engines looked up so far, nil for those that
+// do not exist.
+func (s *CarService) checkImportRow(ctx context.Context, row *models.ImportRow, engines map[uuid.UUID]*models.Engine) (models.ValidationErrors, error) {
+	if hasField(row.Errors, "row") {
+		return row.Errors, nil
+	}
+	engineID := row.Car.Engine.EngineID
+	engine, ok := engines[engineID]
+	if !ok && engineID != uuid.Nil {
+		found, err := s.engineStore.EngineById(ctx, engineID.String(), models.AllFields)
+		if err != nil && !apperrors.IsNotFound(err) {
+			return nil, err
+		}
+		if err == nil {
+			engine = &found
+		}
+		engines[engineID] = engine
+	}
+	if engine != nil {
+		row.Car.Engine = *engine
+	}
+
+	var errs models.ValidationErrors
+	errors.As(models.ValidateRequest(row.Car), &errs)
+	if engine == nil {
+		// Without an engine its spec is missing too; only report the id.
+		var carErrs models.ValidationErrors
+		for _, fieldErr := range errs {
+			if !strings.HasPrefix(fieldErr.Field, "engine.") {
+				carErrs = append(carErrs, fieldErr)
+			}
+		}
+		errs = carErrs
+		if engineID == uuid.Nil {
+			errs = append(errs, models.FieldError{Field: "engine.engine_id", Code: models.CodeRequired, Message: "engine_id is required"})
+		} else {
+			errs = append(errs, models.FieldError{Field: "engine.engine_id", Code: models.CodeInvalidChoice, Message: "engine " + engineID.String() + " does not exist"})
+		}
+	}
+
+	all := row.Errors
+	for _, fieldErr := range errs {
+		if !hasField(row.Errors, fieldErr.Field) {
+			all = append(all, fieldErr)
+		}
+	}
+	return all, nil
+}
+
+func hasField(errs models.ValidationErrors, field string) bool {
+	for _, fieldErr := range errs {
+		if fieldErr.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// importRow creates the car of one row of a per-row import.
+func (s *CarService) importRow(ctx context.Context, row models.ImportRow) (models.Car, error) {
+	var createdCar models.Car
+	err := s.tx.WithinTx(ctx, func(ctx context.Context) error {
+		var err error
+		if createdCar, err = s.store.CreateCar(ctx, &row.Car); err != nil {
+			return err
+		}
+		return service.Audit(ctx, s.audit, models.AuditCar, createdCar.ID, models.AuditCreate, createdCar.Version, nil, createdCar.AuditState())
+	})
+	return createdCar, err
+}
+
+// importBatches creates the cars of an atomic import.
+func (s *CarService) importBatches(ctx context.Context, rows []models.ImportRow) ([]models.ImportedCar, error) {
+	var imported []models.ImportedCar
+	err := s.tx.WithinTx(ctx, func(ctx context.Context) error {
+		for start := 0; start < len(rows); start += models.ImportBatchSize {
+			batch := rows[start:min(start+models.ImportBatchSize, len(rows))]
+			carReqs := make([]models.CarRequest, len(batch))
+			for i, row := range batch {
+				carReqs[i] = row.Car
+			}
+			cars, err := s.store.CreateCars(ctx, carReqs)
+			if err != nil {
+				return err
+			}
+			for i, car := range cars {
+				if err := service.Audit(ctx, s.audit, models.AuditCar, car.ID, models.AuditCreate, car.Version, nil, car.AuditState()); err != nil {
+					return err
+				}
+				imported = append(imported, models.ImportedCar{Line: batch[i].Line, ID: car.ID.String()})
+			}
+		}
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
-	updatedCar, err := s.store.UpdateCar(ctx, id, carReq)
+	return imported, nil
+}
+
+// rowError reports whether err is about the row being imported rather
+// than a failure that should stop the import.
+func rowError(err error) bool {
+	switch apperrors.KindOf(err) {
+	case apperrors.KindValidation, apperrors.KindConflict, apperrors.KindNotFound:
+		return true
+	}
+	return false
+}
+
+func fieldErrors(err error) []models.FieldError {
+	var fieldErrs models.ValidationErrors
+	if errors.As(err, &fieldErrs) {
+		return fieldErrs
+	}
+	message := err.Error()
+	var appErr *apperrors.Error
+	if errors.As(err, &appErr) {
+		message = appErr.Message
+	}
+	return []models.FieldError{{Field: "row", Code: models.CodeInvalidChoice, Message: message}}
+}
+
+// UpdateCar replaces a car, provided it is at a version pre allows.
+func (s *CarService) UpdateCar(ctx context.Context, id string, carReq *models.CarRequest, pre models.Precondition) (*models.Car, error) {
+	if err := models.ValidateRequest(*carReq); err != nil {
+		return nil, apperrors.Wrap(apperrors.KindValidation, err)
+	}
+	var updatedCar models.Car
+	err := s.tx.WithinTx(ctx, func(ctx context.Context) error {
+		current, err := s.store.GetCarById(ctx, id, models.AllFields)
+		if err != nil {
+			return err
+		}
+		if err := service.CheckPrecondition(pre, "car", id, current.Version); err != nil {
+			return err
+		}
+		if updatedCar, err = s.store.UpdateCar(ctx, id, carReq, current.Version); err != nil {
+			return service.Stale(err, pre)
+		}
+		return service.Audit(ctx, s.audit, models.AuditCar, updatedCar.ID, models.AuditUpdate, updatedCar.Version, current.AuditState(), updatedCar.AuditState())
+	})
 	if err != nil {
 		return nil, err
 	}
 	return &updatedCar, nil
 }
 
-func (s *CarService) DeleteCar(ctx context.Context, id string) (*models.Car, error) {
-	deletedCar, err := s.store.DeleteCar(ctx, id)
+// PatchCar applies a merge patch or JSON patch to a car. Only the patched
+// result is validated and only the columns it changes are written. The
+// engine is referenced by engine_id; its details are changed through the
+// engine itself. The write is made against the version the patch was
+// applied to, so a concurrent change fails it rather than being lost.
+func (s *CarService) PatchCar(ctx context.Context, id string, patch models.Patch, pre models.Precondition) (*models.Car, error) {
+	var patchedCar models.Car
+	err := s.tx.WithinTx(ctx, func(ctx context.Context) error {
+		current, err := s.store.GetCarById(ctx, id, models.AllFields)
+		if err != nil {
+			return err
+		}
+		if err := service.CheckPrecondition(pre, "car", id, current.Version); err != nil {
+			return err
+		}
+		var next models.CarRequest
+		if err := service.ApplyPatch(current.ToRequest(), patch, &next); err != nil {
+			return err
+		}
+		if next.Engine.EngineID == current.Engine.EngineID && next.Engine.ToRequest() != current.Engine.ToRequest() {
+			return apperrors.Wrap(apperrors.KindValidation, models.ValidationErrors{{
+				Field:   "engine",
+				Code:    models.CodeInvalidChoice,
+				Message: "engine details cannot be changed through a car, patch the engine instead",
+			}})
+		}
+		if next.Engine.EngineID != current.Engine.EngineID && next.Engine.EngineID != uuid.Nil {
+			engine, err := s.engineStore.EngineById(ctx, next.Engine.EngineID.String(), models.AllFields)
+			if apperrors.IsNotFound(err) {
+				return apperrors.Validation("engine %s does not exist", next.Engine.EngineID)
+			}
+			if err != nil {
+				return err
+			}
+			next.Engine = engine
+		}
+		if err := models.ValidateRequest(next); err != nil {
+			return apperrors.Wrap(apperrors.KindValidation, err)
+		}
+
+		changes := models.DiffCar(current, next)
+		if changes.IsEmpty() {
+			patchedCar = current
+			return nil
+		}
+		if patchedCar, err = s.store.PatchCar(ctx, id, changes, current.Version); err != nil {
+			return service.Stale(err, pre)
+		}
+		return service.Audit(ctx, s.audit, models.AuditCar, patchedCar.ID, models.AuditUpdate, patchedCar.Version, current.AuditState(), patchedCar.AuditState())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &patchedCar, nil
+}
+
+// DeleteCar deletes a car, provided it is at a version pre allows.
+func (s *CarService) DeleteCar(ctx context.Context, id string, pre models.Precondition) (*models.Car, error) {
+	var deletedCar models.Car
+	err := s.tx.WithinTx(ctx, func(ctx context.Context) error {
+		current, err := s.store.GetCarById(ctx, id, models.AllFields)
+		if err != nil {
+			return err
+		}
+		if err := service.CheckPrecondition(pre, "car", id, current.Version); err != nil {
+			return err
+		}
+		if deletedCar, err = s.store.DeleteCar(ctx, id, current.Version); err != nil {
+			return service.Stale(err, pre)
+		}
+		return service.Audit(ctx, s.audit, models.AuditCar, deletedCar.ID, models.AuditDelete, deletedCar.Version, current.AuditState(), nil)
+	})
 	if err != nil {
 		return nil, err
 	}
 	return &deletedCar, nil
 }
+
+// RevertCar sets a car's fields back to those of an earlier version. The
+// revert is a change of its own with a new version, made like a patch
+// against the current one, provided that is at a version pre allows. The
+// engine the earlier version used must still exist.
+func (s *CarService) RevertCar(ctx context.Context, id string, version int64, pre models.Precondition) (*models.Car, error) {
+	var revertedCar models.Car
+	err := s.tx.WithinTx(ctx, func(ctx context.Context) error {
+		current, err := s.store.GetCarById(ctx, id, models.AllFields)
+		if err != nil {
+			return err
+		}
+		if err := service.CheckPrecondition(pre, "car", id, current.Version); err != nil {
+			return err
+		}
+		if version < 1 || version >= current.Version {
+			return apperrors.Wrap(apperrors.KindValidation, models.ValidationErrors{{
+				Field:   "version",
+				Code:    models.CodeOutOfRange,
+				Message: fmt.Sprintf("version must be between 1 and %d, the versions before the current one", current.Version-1),
+			}})
+		}
+		target, err := s.store.GetCarVersion(ctx, id, version)
+		if err != nil {
+			return err
+		}
+		changes := models.DiffCar(current, target.ToRequest())
+		if changes.IsEmpty() {
+			revertedCar = current
+			return nil
+		}
+		if changes.EngineID != nil {
+			_, err := s.engineStore.EngineById(ctx, changes.EngineID.String(), models.AllFields)
+			if apperrors.IsNotFound(err) {
+				return apperrors.Conflict("engine %s used by version %d of car %s no longer exists", changes.EngineID, version, id)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if revertedCar, err = s.store.PatchCar(ctx, id, changes, current.Version); err != nil {
+			return service.Stale(err, pre)
+		}
+		return service.Audit(ctx, s.audit, models.AuditCar, revertedCar.ID, models.AuditRevert, revertedCar.Version, current.AuditState(), revertedCar.AuditState())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &revertedCar, nil
+}
+
+// History returns one page of a car's audit trail, newest first. It is kept
+// after the car is deleted.
+func (s *CarService) History(ctx context.Context, id string, opts models.AuditListOptions) (*models.AuditPage, error) {
+	return service.History(ctx, s.audit, models.AuditCar, id, opts)
+}
+
+// RestoreCar takes a deleted car out of the trash.
+func (s *CarService) RestoreCar(ctx context.Context, id string) (*models.Car, error) {
+	var restoredCar models.Car
+	err := s.tx.WithinTx(ctx, func(ctx context.Context) error {
+		var err error
+		if restoredCar, err = s.store.RestoreCar(ctx, id); err != nil {
+			return err
+		}
+		return service.Audit(ctx, s.audit, models.AuditCar, restoredCar.ID, models.AuditRestore, restoredCar.Version, nil, restoredCar.AuditState())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &restoredCar, nil
+}
+
+// PurgeTrash permanently removes the cars that have been in the trash for
+// longer than olderThan and returns how many there were. Each is audited
+// in the same transaction.
+func (s *CarService) PurgeTrash(ctx context.Context, olderThan time.Duration) (int64, error) {
+	if olderThan < 0 {
+		return 0, apperrors.Validation("retention %s must not be negative", olderThan)
+	}
+	var purged []models.Car
+	err := s.tx.WithinTx(ctx, func(ctx context.Context) error {
+		var err error
+		if purged, err = s.store.PurgeDeletedCars(ctx, time.Now().Add(-olderThan)); err != nil {
+			return err
+		}
+		for _, car := range purged {
+			if err := service.Audit(ctx, s.audit, models.AuditCar, car.ID, models.AuditPurge, car.Version, car.AuditState(), nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(purged)), nil
+}
+
+// ListCars returns one page of cars using keyset pagination. Cursors encode
+// the sort order they were issued for and are rejected if it changes.
+func (s *CarService) ListCars(ctx context.Context, opts models.CarListOptions) (*models.CarPage, error) {
+	return s.listCars(ctx, opts, false)
+}
+
+// ListTrash is ListCars for the cars in the trash.
+func (s *CarService) ListTrash(ctx context.Context, opts models.CarListOptions) (*models.CarPage, error) {
+	return s.listCars(ctx, opts, true)
+}
+
+// ExportCars returns a function that calls fn with every live car matching
+// the filters of opts, in the order opts sorts them by. Options are
+// checked up front; cars are then read a page at a time as fn consumes
+// them, so memory use does not grow with the number of cars. An export is
+// not a snapshot: a car changed while it runs may appear as it was before
+// or after the change.
+func (s *CarService) ExportCars(ctx context.Context, opts models.CarListOptions) (func(fn func(models.Car) error) error, error) {
+	query, err := carListQuery(opts, false)
+	if err != nil {
+		return nil, err
+	}
+	query.Limit = models.ExportPageSize
+	return func(fn func(models.Car) error) error {
+		for {
+			cars, err := s.store.ListCars(ctx, query)
+			if err != nil {
+				return err
+			}
+			for _, car := range cars {
+				if err := fn(car); err != nil {
+					return err
+				}
+			}
+			if len(cars) < query.Limit {
+				return nil
+			}
+			query.After = &cars[len(cars)-1]
+		}
+	}, nil
+}
+
+// carListQuery checks the sort and filters of opts and turns them into a
+// store query with neither limit nor boundary set.
+func carListQuery(opts models.CarListOptions, deleted bool) (models.CarListQuery, error) {
+	sort, err := models.ParseSort(opts.Sort, models.CarSortFields, models.SortField{Field: "created_at"})
+	if err != nil {
+		return models.CarListQuery{}, apperrors.Wrap(apperrors.KindValidation, err)
+	}
+	if err := opts.Filter.Validate(); err != nil {
+		return models.CarListQuery{}, apperrors.Wrap(apperrors.KindValidation, err)
+	}
+	if opts.Expression != "" {
+		if opts.Filter.Expr, err = service.ParseExpression(opts.Expression, models.CarExprFields); err != nil {
+			return models.CarListQuery{}, err
+		}
+	}
+	// Cursors are built from the sort fields, so they are read whatever
+	// fields were asked for.
+	return models.CarListQuery{Filter: opts.Filter, Sort: sort, Deleted: deleted, Fields: opts.Fields.WithSort(sort)}, nil
+}
+
+func (s *CarService) listCars(ctx context.Context, opts models.CarListOptions, deleted bool) (*models.CarPage, error) {
+	limit, err := models.ValidatePageSize(opts.Limit)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.KindValidation, err)
+	}
+	query, err := carListQuery(opts, deleted)
+	if err != nil {
+		return nil, err
+	}
+	query.Limit = limit + 1
+	sort := query.Sort
+	cursor, err := service.DecodeCursor(opts.Cursor, sort)
+	if err != nil {
+		return nil, err
+	}
+	if cursor != nil {
+		boundary, err := cursor.Car()
+		if err != nil {
+			return nil, apperrors.Wrap(apperrors.KindValidation, err)
+		}
+		if cursor.Before {
+			query.Before = &boundary
+		} else {
+			query.After = &boundary
+		}
+	}
+
+	cars, err := s.store.ListCars(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	page := &models.CarPage{}
+	page.Cars, page.NextCursor, page.PrevCursor = models.TrimPage(cars, limit, cursor, func(car models.Car, before bool) string {
+		return models.NewCarCursor(car, sort, before).Encode()
+	})
+	page.Cars = models.SelectCars(page.Cars, opts.Fields)
+	return page, nil
+}
@@ -0,0 +1,60 @@
+package car
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"golangSecond/apperrors"
+	"golangSecond/models"
+	"golangSecond/store/memory"
+
+	"github.com/google/uuid"
+)
+
+// failingCarStore fails every CreateCar, keeping the engine it was asked to
+// put the car on.
+type failingCarStore struct {
+	*memory.CarStore
+	engineID uuid.UUID
+}
+
+var errCarInsert = errors.New("car insert failed")
+
+func (s *failingCarStore) CreateCar(ctx context.Context, carReq *models.CarRequest) (models.Car, error) {
+	s.engineID = carReq.Engine.EngineID
+	return models.Car{}, errCarInsert
+}
+
+func TestCreateCarRollsBackItsInlineEngine(t *testing.T) {
+	ctx := context.Background()
+	db := memory.NewDB()
+	cars := &failingCarStore{CarStore: memory.NewCarStore(db)}
+	engines := memory.NewEngineStore(db)
+	audits := memory.NewAuditStore(db)
+	svc := NewCarService(cars, engines, audits, db)
+
+	_, err := svc.CreateCar(ctx, &models.CarRequest{
+		Name: "Nexon", Year: "2021", Brand: "Tata", FuelType: "Petrol", Price: 10000,
+		Engine: models.Engine{Displacement: 1998, NoOfCylinders: 4, CarRange: 550},
+	})
+	if !errors.Is(err, errCarInsert) {
+		t.Fatalf("CreateCar = %v, want %v", err, errCarInsert)
+	}
+	if cars.engineID == uuid.Nil {
+		t.Fatal("CreateCar did not create the inline engine before the car")
+	}
+
+	_, err = engines.EngineById(ctx, cars.engineID.String(), models.AllFields)
+	if apperrors.KindOf(err) != apperrors.KindNotFound {
+		t.Errorf("EngineById of the inline engine = %v, want not found", err)
+	}
+	left, err := engines.ListEngines(ctx, models.EngineListQuery{Sort: []models.SortField{{Field: "engine_id"}}, Limit: 10})
+	if err != nil || len(left) != 0 {
+		t.Errorf("ListEngines = %+v, %v, want no engines", left, err)
+	}
+	entries, err := audits.ListAudit(ctx, models.AuditListQuery{EntityType: models.AuditEngine, EntityID: cars.engineID, Limit: 10})
+	if err != nil || len(entries) != 0 {
+		t.Errorf("audit of the inline engine = %+v, %v, want none", entries, err)
+	}
+}
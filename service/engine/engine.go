@@ -2,54 +2,277 @@ package engine
 
 import (
 	"context"
+	"golangSecond/apperrors"
 	"golangSecond/models"
+	"golangSecond/service"
 	"golangSecond/store"
+
+	"github.com/google/uuid"
 )
 
 type EngineService struct {
-	store store.EngineStoreInterface
+	store    store.EngineStoreInterface
+	carStore store.CarStoreInterface
+	audit    store.AuditStoreInterface
+	tx       store.TxManager
 }
 
-func NewEngineService(store store.EngineStoreInterface) *EngineService {
+func NewEngineService(store store.EngineStoreInterface, carStore store.CarStoreInterface, audit store.AuditStoreInterface, tx store.TxManager) *EngineService {
 	return &EngineService{
-		store: store,
+		store:    store,
+		carStore: carStore,
+		audit:    audit,
+		tx:       tx,
 	}
 }
 
-func (s *EngineService) GetEngineByID(ctx context.Context, id string) (*models.Engine, error) {
-	engine, err := s.store.EngineById(ctx, id)
+// GetEngineByID returns an engine with the fields asked for.
+func (s *EngineService) GetEngineByID(ctx context.Context, id string, fields models.FieldSet) (*models.Engine, error) {
+	engine, err := s.store.EngineById(ctx, id, fields)
 	if err != nil {
 		return nil, err
 	}
+	engine = engine.Select(fields)
 	return &engine, nil
 }
 
 func (s *EngineService) CreateEngine(ctx context.Context, engineReq *models.EngineRequest) (*models.Engine, error) {
 	if err := models.ValidateEngineRequest(*engineReq); err != nil {
-		return nil, err
+		return nil, apperrors.Wrap(apperrors.KindValidation, err)
 	}
-	createdEngine, err := s.store.EngineCreate(ctx, engineReq)
+	var createdEngine models.Engine
+	err := s.tx.WithinTx(ctx, func(ctx context.Context) error {
+		var err error
+		if createdEngine, err = s.store.EngineCreate(ctx, engineReq); err != nil {
+			return err
+		}
+		return service.Audit(ctx, s.audit, models.AuditEngine, createdEngine.EngineID, models.AuditCreate, createdEngine.Version, nil, createdEngine.AuditState())
+	})
 	if err != nil {
 		return nil, err
 	}
 	return &createdEngine, nil
 }
 
-func (s *EngineService) UpdateEngine(ctx context.Context, id string, engineReq *models.EngineRequest) (*models.Engine, error) {
+// UpdateEngine replaces an engine, provided it is at a version pre allows.
+func (s *EngineService) UpdateEngine(ctx context.Context, id string, engineReq *models.EngineRequest, pre models.Precondition) (*models.Engine, error) {
 	if err := models.ValidateEngineRequest(*engineReq); err != nil {
-		return nil, err
+		return nil, apperrors.Wrap(apperrors.KindValidation, err)
 	}
-	updatedEngine, err := s.store.EngineUpdate(ctx, id, engineReq)
+	var updatedEngine models.Engine
+	err := s.tx.WithinTx(ctx, func(ctx context.Context) error {
+		current, err := s.store.EngineById(ctx, id, models.AllFields)
+		if err != nil {
+			return err
+		}
+		if err := service.CheckPrecondition(pre, "engine", id, current.Version); err != nil {
+			return err
+		}
+		if updatedEngine, err = s.store.EngineUpdate(ctx, id, engineReq, current.Version); err != nil {
+			return service.Stale(err, pre)
+		}
+		return service.Audit(ctx, s.audit, models.AuditEngine, updatedEngine.EngineID, models.AuditUpdate, updatedEngine.Version, current.AuditState(), updatedEngine.AuditState())
+	})
 	if err != nil {
 		return nil, err
 	}
 	return &updatedEngine, nil
 }
 
-func (s *EngineService) DeleteEngine(ctx context.Context, id string) (*models.Engine, error) {
-	deletedEngine, err := s.store.EngineDelete(ctx, id)
+// PatchEngine applies a merge patch or JSON patch to an engine. Only the
+// patched result is validated and only the columns it changes are written,
+// against the version the patch was applied to.
+func (s *EngineService) PatchEngine(ctx context.Context, id string, patch models.Patch, pre models.Precondition) (*models.Engine, error) {
+	var patchedEngine models.Engine
+	err := s.tx.WithinTx(ctx, func(ctx context.Context) error {
+		current, err := s.store.EngineById(ctx, id, models.AllFields)
+		if err != nil {
+			return err
+		}
+		if err := service.CheckPrecondition(pre, "engine", id, current.Version); err != nil {
+			return err
+		}
+		var next models.EngineRequest
+		if err := service.ApplyPatch(current.ToRequest(), patch, &next); err != nil {
+			return err
+		}
+		if err := models.ValidateEngineRequest(next); err != nil {
+			return apperrors.Wrap(apperrors.KindValidation, err)
+		}
+		changes := models.DiffEngine(current, next)
+		if changes.IsEmpty() {
+			patchedEngine = current
+			return nil
+		}
+		if patchedEngine, err = s.store.EnginePatch(ctx, id, changes, current.Version); err != nil {
+			return service.Stale(err, pre)
+		}
+		return service.Audit(ctx, s.audit, models.AuditEngine, patchedEngine.EngineID, models.AuditUpdate, patchedEngine.Version, current.AuditState(), patchedEngine.AuditState())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &patchedEngine, nil
+}
+
+// DeleteEngine deletes an engine. By default it is refused with a conflict
+// listing the cars that still use the engine; opts can instead cascade the
+// delete to those cars or move them to a replacement engine. Like updates,
+// it only goes ahead if the engine is at a version pre allows. The cars
+// the delete removes or moves, in the trash or not, are recorded in their
+// own histories as the store reports them, after it has locked the engine.
+func (s *EngineService) DeleteEngine(ctx context.Context, id string, opts models.EngineDeleteOptions, pre models.Precondition) (*models.Engine, error) {
+	if err := opts.Validate(id); err != nil {
+		return nil, apperrors.Wrap(apperrors.KindValidation, err)
+	}
+	var deletedEngine models.Engine
+	err := s.tx.WithinTx(ctx, func(ctx context.Context) error {
+		current, err := s.store.EngineById(ctx, id, models.AllFields)
+		if err != nil {
+			return err
+		}
+		if err := service.CheckPrecondition(pre, "engine", id, current.Version); err != nil {
+			return err
+		}
+		var cars []models.Car
+		if deletedEngine, cars, err = s.store.EngineDelete(ctx, id, opts, current.Version); err != nil {
+			return service.Stale(err, pre)
+		}
+		if err := service.Audit(ctx, s.audit, models.AuditEngine, deletedEngine.EngineID, models.AuditDelete, deletedEngine.Version, deletedEngine.AuditState(), nil); err != nil {
+			return err
+		}
+		return s.auditDependents(ctx, deletedEngine.EngineID, cars, opts)
+	})
 	if err != nil {
 		return nil, err
 	}
 	return &deletedEngine, nil
 }
+
+// auditDependents records what deleting the engine engineID did to the cars
+// that used it: cars is what EngineDelete returned. Removed cars that were
+// in the trash are recorded as purged.
+func (s *EngineService) auditDependents(ctx context.Context, engineID uuid.UUID, cars []models.Car, opts models.EngineDeleteOptions) error {
+	for _, car := range cars {
+		var err error
+		switch {
+		case opts.Strategy == models.EngineDeleteDetach:
+			before := car
+			before.Engine = models.Engine{EngineID: engineID}
+			err = service.Audit(ctx, s.audit, models.AuditCar, car.ID, models.AuditUpdate, car.Version, before.AuditState(), car.AuditState())
+		case car.DeletedAt != nil:
+			err = service.Audit(ctx, s.audit, models.AuditCar, car.ID, models.AuditPurge, car.Version, car.AuditState(), nil)
+		default:
+			err = service.Audit(ctx, s.audit, models.AuditCar, car.ID, models.AuditDelete, car.Version, car.AuditState(), nil)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// History returns one page of the engine's audit trail, newest first.
+func (s *EngineService) History(ctx context.Context, id string, opts models.AuditListOptions) (*models.AuditPage, error) {
+	return service.History(ctx, s.audit, models.AuditEngine, id, opts)
+}
+
+// ListEngines returns one page of engines using keyset pagination, ordered
+// by id unless a sort is given.
+func (s *EngineService) ListEngines(ctx context.Context, opts models.EngineListOptions) (*models.EnginePage, error) {
+	limit, err := models.ValidatePageSize(opts.Limit)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.KindValidation, err)
+	}
+	query, err := engineListQuery(opts)
+	if err != nil {
+		return nil, err
+	}
+	query.Limit = limit + 1
+	sort := query.Sort
+	cursor, err := service.DecodeCursor(opts.Cursor, sort)
+	if err != nil {
+		return nil, err
+	}
+	if cursor != nil {
+		boundary, err := cursor.Engine()
+		if err != nil {
+			return nil, apperrors.Wrap(apperrors.KindValidation, err)
+		}
+		if cursor.Before {
+			query.Before = &boundary
+		} else {
+			query.After = &boundary
+		}
+	}
+
+	engines, err := s.store.ListEngines(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	page := &models.EnginePage{}
+	page.Engines, page.NextCursor, page.PrevCursor = models.TrimPage(engines, limit, cursor, func(engine models.Engine, before bool) string {
+		return models.NewEngineCursor(engine, sort, before).Encode()
+	})
+	page.Engines = models.SelectEngines(page.Engines, opts.Fields)
+	return page, nil
+}
+
+// ExportEngines is the engine counterpart of CarService.ExportCars.
+func (s *EngineService) ExportEngines(ctx context.Context, opts models.EngineListOptions) (func(fn func(models.Engine) error) error, error) {
+	query, err := engineListQuery(opts)
+	if err != nil {
+		return nil, err
+	}
+	query.Limit = models.ExportPageSize
+	return func(fn func(models.Engine) error) error {
+		for {
+			engines, err := s.store.ListEngines(ctx, query)
+			if err != nil {
+				return err
+			}
+			for _, engine := range engines {
+				if err := fn(engine); err != nil {
+					return err
+				}
+			}
+			if len(engines) < query.Limit {
+				return nil
+			}
+			query.After = &engines[len(engines)-1]
+		}
+	}, nil
+}
+
+// engineListQuery is the engine counterpart of carListQuery.
+func engineListQuery(opts models.EngineListOptions) (models.EngineListQuery, error) {
+	sort, err := models.ParseSort(opts.Sort, models.EngineSortFields)
+	if err != nil {
+		return models.EngineListQuery{}, apperrors.Wrap(apperrors.KindValidation, err)
+	}
+	if err := opts.Filter.Validate(); err != nil {
+		return models.EngineListQuery{}, apperrors.Wrap(apperrors.KindValidation, err)
+	}
+	if opts.Expression != "" {
+		if opts.Filter.Expr, err = service.ParseExpression(opts.Expression, models.EngineExprFields); err != nil {
+			return models.EngineListQuery{}, err
+		}
+	}
+	return models.EngineListQuery{Filter: opts.Filter, Sort: sort, Fields: opts.Fields.WithSort(sort)}, nil
+}
+
+// GetCarsByEngineID returns every car that uses the engine, with the fields
+// asked for. It fails with not found if the engine itself does not exist.
+func (s *EngineService) GetCarsByEngineID(ctx context.Context, id string, fields models.FieldSet) ([]models.Car, error) {
+	if _, err := s.store.EngineById(ctx, id, models.FieldSet{Fields: []string{"engine_id"}}); err != nil {
+		return nil, err
+	}
+	cars, err := s.carStore.GetCarsByEngineID(ctx, id, fields)
+	if err != nil {
+		return nil, err
+	}
+	if cars == nil {
+		cars = []models.Car{}
+	}
+	return models.SelectCars(cars, fields), nil
+}
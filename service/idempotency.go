@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"golangSecond/apperrors"
+	"golangSecond/models"
+	"golangSecond/store"
+)
+
+// IdempotencyLockTimeout is how long a request holds its idempotency key
+// before a retry may take it over, should the server have stopped before
+// the request finished. Requests that are still running renew it with
+// Hold, however long they take.
+const IdempotencyLockTimeout = time.Minute
+
+// Idempotency keeps the responses to requests made with an idempotency key
+// for ttl, so that retries get the first response instead of repeating its
+// changes.
+type Idempotency struct {
+	keys store.IdempotencyStoreInterface
+	ttl  time.Duration
+}
+
+func NewIdempotency(keys store.IdempotencyStoreInterface, ttl time.Duration) *Idempotency {
+	return &Idempotency{
+		keys: keys,
+		ttl:  ttl,
+	}
+}
+
+// Begin reserves key, for the actor of ctx, for the request identified by
+// fingerprint. When an
+// earlier request with the key has finished, its response is returned and
+// the request must not run; otherwise it must run and then be passed to
+// Finish or Abandon. Reusing a key for a different request is refused as
+// unprocessable, and retrying one that is still running as a conflict.
+func (i *Idempotency) Begin(ctx context.Context, key, fingerprint string) (*models.StoredResponse, error) {
+	if err := validateIdempotencyKey(key); err != nil {
+		return nil, err
+	}
+	record, reserved, err := i.keys.ReserveIdempotencyKey(ctx, models.IdempotencyRecord{
+		Actor:       Actor(ctx),
+		Key:         key,
+		Fingerprint: fingerprint,
+		ExpiresAt:   time.Now().Add(IdempotencyLockTimeout),
+	})
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case reserved:
+		return nil, nil
+	case record.Fingerprint != fingerprint:
+		return nil, apperrors.Unprocessable("idempotency key %q was already used for a different request", key)
+	case record.Response == nil:
+		return nil, apperrors.Conflict("a request with idempotency key %q is still in progress", key)
+	}
+	return record.Response, nil
+}
+
+// Hold keeps the key of a request started with Begin from expiring while
+// the request runs, renewing it every third of IdempotencyLockTimeout until
+// stop is called. stop must be called before Finish or Abandon.
+func (i *Idempotency) Hold(ctx context.Context, key string) (stop func()) {
+	actor := Actor(ctx)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(IdempotencyLockTimeout / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := i.keys.ExtendIdempotencyKey(ctx, actor, key, time.Now().Add(IdempotencyLockTimeout)); err != nil {
+					log.Println("Error extending idempotency key:", err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// Finish stores the response of a request started with Begin.
+func (i *Idempotency) Finish(ctx context.Context, key string, response models.StoredResponse) error {
+	return i.keys.CompleteIdempotencyKey(ctx, Actor(ctx), key, response, time.Now().Add(i.ttl))
+}
+
+// Abandon frees the key of a request started with Begin that failed in a
+// way worth retrying, so that a retry runs it again.
+func (i *Idempotency) Abandon(ctx context.Context, key string) error {
+	return i.keys.ReleaseIdempotencyKey(ctx, Actor(ctx), key)
+}
+
+// Purge deletes the records that have expired.
+func (i *Idempotency) Purge(ctx context.Context) (int64, error) {
+	return i.keys.PurgeIdempotencyKeys(ctx, time.Now())
+}
+
+func validateIdempotencyKey(key string) error {
+	if key == "" || len(key) > models.MaxIdempotencyKeyLength {
+		return apperrors.Validation("idempotency key must be 1 to %d characters long", models.MaxIdempotencyKeyLength)
+	}
+	for _, c := range []byte(key) {
+		if c < 0x21 || c > 0x7e {
+			return apperrors.Validation("idempotency key must be printable ASCII without spaces")
+		}
+	}
+	return nil
+}
@@ -3,18 +3,44 @@ package service
 import (
 	"context"
 	"golangSecond/models"
+	"time"
 )
 
 type CarServiceInterface interface {
-	GetCarByID(ctx context.Context, id string) (*models.Car, error)
-	GetCarByBrand(ctx context.Context, brand string, isEngine bool) ([]models.Car, error)
+	GetCarByID(ctx context.Context, id string, fields models.FieldSet) (*models.Car, error)
+	GetCarAsOf(ctx context.Context, id string, at time.Time, fields models.FieldSet) (*models.Car, error)
+	GetCarsByIDs(ctx context.Context, ids []string, fields models.FieldSet) (*models.CarBatch, error)
+	GetCarByBrand(ctx context.Context, brand string, fields models.FieldSet) ([]models.Car, error)
+	ListCars(ctx context.Context, opts models.CarListOptions) (*models.CarPage, error)
+	ExportCars(ctx context.Context, opts models.CarListOptions) (func(fn func(models.Car) error) error, error)
 	CreateCar(ctx context.Context, carReq *models.CarRequest) (*models.Car, error)
-	UpdateCar(ctx context.Context, id string, carReq *models.CarRequest) (*models.Car, error)
-	DeleteCar(ctx context.Context, id string) (*models.Car, error)
+	ImportCars(ctx context.Context, rows []models.ImportRow, opts models.ImportOptions) (*models.ImportReport, error)
+	UpdateCar(ctx context.Context, id string, carReq *models.CarRequest, pre models.Precondition) (*models.Car, error)
+	PatchCar(ctx context.Context, id string, patch models.Patch, pre models.Precondition) (*models.Car, error)
+	DeleteCar(ctx context.Context, id string, pre models.Precondition) (*models.Car, error)
+	ListTrash(ctx context.Context, opts models.CarListOptions) (*models.CarPage, error)
+	RestoreCar(ctx context.Context, id string) (*models.Car, error)
+	RevertCar(ctx context.Context, id string, version int64, pre models.Precondition) (*models.Car, error)
+	PurgeTrash(ctx context.Context, olderThan time.Duration) (int64, error)
+	History(ctx context.Context, id string, opts models.AuditListOptions) (*models.AuditPage, error)
 }
 type EngineServiceInterface interface {
-	GetEngineByID(ctx context.Context, id string) (*models.Engine, error)
+	GetEngineByID(ctx context.Context, id string, fields models.FieldSet) (*models.Engine, error)
+	ListEngines(ctx context.Context, opts models.EngineListOptions) (*models.EnginePage, error)
+	ExportEngines(ctx context.Context, opts models.EngineListOptions) (func(fn func(models.Engine) error) error, error)
+	GetCarsByEngineID(ctx context.Context, id string, fields models.FieldSet) ([]models.Car, error)
 	CreateEngine(ctx context.Context, engineReq *models.EngineRequest) (*models.Engine, error)
-	UpdateEngine(ctx context.Context, id string, engineReq *models.EngineRequest) (*models.Engine, error)
-	DeleteEngine(ctx context.Context, id string) (*models.Engine, error)
+	UpdateEngine(ctx context.Context, id string, engineReq *models.EngineRequest, pre models.Precondition) (*models.Engine, error)
+	PatchEngine(ctx context.Context, id string, patch models.Patch, pre models.Precondition) (*models.Engine, error)
+	DeleteEngine(ctx context.Context, id string, opts models.EngineDeleteOptions, pre models.Precondition) (*models.Engine, error)
+	History(ctx context.Context, id string, opts models.AuditListOptions) (*models.AuditPage, error)
+}
+
+// IdempotencyServiceInterface is implemented by Idempotency.
+type IdempotencyServiceInterface interface {
+	Begin(ctx context.Context, key, fingerprint string) (*models.StoredResponse, error)
+	Hold(ctx context.Context, key string) (stop func())
+	Finish(ctx context.Context, key string, response models.StoredResponse) error
+	Abandon(ctx context.Context, key string) error
+	Purge(ctx context.Context) (int64, error)
 }
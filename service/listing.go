@@ -0,0 +1,36 @@
+package service
+
+import (
+	"golangSecond/apperrors"
+	"golangSecond/filterexpr"
+	"golangSecond/models"
+)
+
+// DecodeCursor returns nil for an empty cursor and rejects cursors issued
+// for a different sort order.
+func DecodeCursor(encoded string, sort []models.SortField) (*models.Cursor, error) {
+	if encoded == "" {
+		return nil, nil
+	}
+	cursor, err := models.DecodeCursor(encoded)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.KindValidation, err)
+	}
+	if !cursor.SameSort(sort) {
+		return nil, apperrors.Validation("cursor was issued for a different sort order")
+	}
+	return &cursor, nil
+}
+
+// ParseExpression parses a filter expression and checks it against fields,
+// reporting any problem as a validation error.
+func ParseExpression(input string, fields filterexpr.Fields) (*filterexpr.Expr, error) {
+	expr, err := filterexpr.Parse(input)
+	if err == nil {
+		err = expr.Check(fields)
+	}
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.KindValidation, err)
+	}
+	return expr, nil
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+
+	"golangSecond/apperrors"
+	"golangSecond/jsonpatch"
+	"golangSecond/models"
+)
+
+// ApplyPatch applies patch to the JSON form of current and decodes the
+// result into out. Fields out does not have are rejected, and a failed JSON
+// Patch "test" operation is reported as a conflict.
+func ApplyPatch(current any, patch models.Patch, out any) error {
+	doc, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	var patched []byte
+	switch patch.Type {
+	case models.MergePatch:
+		patched, err = jsonpatch.MergePatch(doc, patch.Body)
+	case models.JSONPatch:
+		patched, err = jsonpatch.Apply(doc, patch.Body)
+	default:
+		return apperrors.Validation("unsupported patch type %q", patch.Type)
+	}
+	if errors.Is(err, jsonpatch.ErrTestFailed) {
+		return apperrors.Wrap(apperrors.KindConflict, err)
+	}
+	if err != nil {
+		return apperrors.Wrap(apperrors.KindValidation, err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(patched))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(out); err != nil {
+		return apperrors.Validation("patched document is invalid: %v", err)
+	}
+	return nil
+}
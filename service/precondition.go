@@ -0,0 +1,26 @@
+package service
+
+import (
+	"golangSecond/apperrors"
+	"golangSecond/models"
+)
+
+// CheckPrecondition fails with precondition failed unless a resource at
+// version satisfies pre. kind and id name the resource in the error.
+func CheckPrecondition(pre models.Precondition, kind, id string, version int64) error {
+	if !pre.Matches(version) {
+		return apperrors.PreconditionFailed("%s %s is at version %d, which If-Match does not name", kind, id, version)
+	}
+	return nil
+}
+
+// Stale adjusts the error of a write made against the version a service
+// has just read. Without If-Match, a version mismatch only means the
+// resource changed in between: that is a conflict worth retrying rather
+// than a failed precondition the client never set.
+func Stale(err error, pre models.Precondition) error {
+	if !pre.Present && apperrors.KindOf(err) == apperrors.KindPreconditionFailed {
+		return apperrors.Wrap(apperrors.KindConflict, err)
+	}
+	return err
+}
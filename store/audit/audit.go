@@ -0,0 +1,97 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"golangSecond/driver"
+	"golangSecond/models"
+	"time"
+)
+
+// Store is the Postgres implementation of store.AuditStoreInterface. Entries
+// are written through driver.Conn, so they commit or roll back with the
+// change they describe.
+type Store struct {
+	db *sql.DB
+}
+
+func New(db *sql.DB) *Store {
+	return &Store{
+		db: db,
+	}
+}
+
+func (s *Store) RecordAudit(ctx context.Context, entry models.AuditEntry) (models.AuditEntry, error) {
+	if entry.At.IsZero() {
+		entry.At = time.Now()
+	}
+	changes, err := json.Marshal(entry.Changes)
+	if err != nil {
+		return models.AuditEntry{}, err
+	}
+	err = driver.Conn(ctx, s.db).QueryRowContext(ctx, `
+	INSERT INTO audit_log (entity_type, entity_id, action, actor, at, version, changes)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING seq`,
+		entry.EntityType,
+		entry.EntityID,
+		entry.Action,
+		entry.Actor,
+		entry.At,
+		entry.Version,
+		changes).Scan(&entry.Seq)
+	if err != nil {
+		return models.AuditEntry{}, driver.MapError(err)
+	}
+	return entry, nil
+}
+
+// ListAudit returns one page of an entity's history, newest first.
+func (s *Store) ListAudit(ctx context.Context, query models.AuditListQuery) ([]models.AuditEntry, error) {
+	args := []any{query.EntityType, query.EntityID, query.Limit}
+	sqlQuery := `SELECT seq, entity_type, entity_id, action, actor, at, version, changes
+	FROM audit_log
+	WHERE entity_type = $1 AND entity_id = $2`
+	order := "DESC"
+	switch {
+	case query.After != nil:
+		args = append(args, *query.After)
+		sqlQuery += fmt.Sprintf(" AND seq < $%d", len(args))
+	case query.Before != nil:
+		args = append(args, *query.Before)
+		sqlQuery += fmt.Sprintf(" AND seq > $%d", len(args))
+		order = "ASC"
+	}
+	sqlQuery += " ORDER BY seq " + order + " LIMIT $3"
+
+	rows, err := driver.Conn(ctx, s.db).QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, driver.MapError(err)
+	}
+	defer rows.Close()
+
+	var entries []models.AuditEntry
+	for rows.Next() {
+		var entry models.AuditEntry
+		var changes []byte
+		if err := rows.Scan(&entry.Seq, &entry.EntityType, &entry.EntityID, &entry.Action, &entry.Actor, &entry.At, &entry.Version, &changes); err != nil {
+			return nil, driver.MapError(err)
+		}
+		if err := json.Unmarshal(changes, &entry.Changes); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, driver.MapError(err)
+	}
+
+	if query.Before != nil {
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+		}
+	}
+	return entries, nil
+}
@@ -4,281 +4,682 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"golangSecond/apperrors"
+	"golangSecond/driver"
 	"golangSecond/models"
+	"golangSecond/store/sqlbuilder"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
-type Store struct {
-	db *sql.DB
+// carColumns and engineColumns are the columns queries select for a car
+// aliased c and its engine aliased e, in the order scanCar and
+// scanCarWithEngine read them.
+const (
+	carColumns    = `c.id, c.name, c.year, c.brand, c.fuel_type, c.price, c.engine_id, c.created_at, c.updated_at, c.version, c.deleted_at`
+	engineColumns = `e.id, e.displacement, e.no_of_cylinders, e.car_range, e.updated_at, e.version`
+)
+
+type scanner interface {
+	Scan(dest ...any) error
 }
 
-func new(db *sql.DB) *Store {
-	return &Store{
-		db: db,
-	}
+func carFields(car *models.Car) []any {
+	return []any{&car.ID, &car.Name, &car.Year, &car.Brand, &car.FuelType, &car.Price, &car.Engine.EngineID, &car.CreatedAt, &car.UpdatedAt, &car.Version, &car.DeletedAt}
 }
-func (s *Store) GetCarById(ctx context.Context, id string) (models.Car, error) {
+
+func engineFields(engine *models.Engine) []any {
+	return []any{&engine.EngineID, &engine.Displacement, &engine.NoOfCylinders, &engine.CarRange, &engine.UpdatedAt, &engine.Version}
+}
+
+func scanCar(row scanner) (models.Car, error) {
+	var car models.Car
+	err := row.Scan(carFields(&car)...)
+	return car, err
+}
+
+func scanCarWithEngine(row scanner) (models.Car, error) {
 	var car models.Car
-	query := `SELECT c.id, c.name, c.year, c.brand, c.fuel_type, c.price, c.engine_id, c.created_at, c.updated_at, e.id, e.displacement, e.no_of_cylinders, e.car_range 
-	from cars c 
-	left join engines e on c.engine_id = e.id 
-	where c.id = $1`
-
-	row := s.db.QueryRowContext(ctx, query, id)
-	if err := row.Scan(
-		&car.ID,
-		&car.Name,
-		&car.Year,
-		&car.Brand,
-		&car.FuelType,
-		&car.Price,
-		&car.Engine.EngineID,
-		&car.CreatedAt,
-		&car.UpdatedAt,
-		&car.Engine.EngineID,
-		&car.Engine.Displacement,
-		&car.Engine.NoOfCylinders,
-		&car.Engine.CarRange,
-	); err != nil {
-		if err == sql.ErrNoRows {
-			return car, nil
+	err := row.Scan(append(carFields(&car), engineFields(&car.Engine)...)...)
+	return car, err
+}
+
+// projectedColumn is a column reads may select, the field of
+// models.CarFields or models.EngineFields it holds and where it is scanned.
+// Columns marked always are selected by every read: ETags, cursors and the
+// engine join need them.
+type projectedColumn struct {
+	field  string
+	column string
+	always bool
+	dest   func(car *models.Car) any
+}
+
+var (
+	projectedCarColumns = []projectedColumn{
+		{"id", "c.id", true, func(car *models.Car) any { return &car.ID }},
+		{"name", "c.name", false, func(car *models.Car) any { return &car.Name }},
+		{"year", "c.year", false, func(car *models.Car) any { return &car.Year }},
+		{"brand", "c.brand", false, func(car *models.Car) any { return &car.Brand }},
+		{"fuel_type", "c.fuel_type", false, func(car *models.Car) any { return &car.FuelType }},
+		{"price", "c.price", false, func(car *models.Car) any { return &car.Price }},
+		{"engine_id", "c.engine_id", true, func(car *models.Car) any { return &car.Engine.EngineID }},
+		{"created_at", "c.created_at", false, func(car *models.Car) any { return &car.CreatedAt }},
+		{"updated_at", "c.updated_at", true, func(car *models.Car) any { return &car.UpdatedAt }},
+		{"version", "c.version", true, func(car *models.Car) any { return &car.Version }},
+		{"deleted_at", "c.deleted_at", false, func(car *models.Car) any { return &car.DeletedAt }},
+	}
+	projectedEngineColumns = []projectedColumn{
+		{"displacement", "e.displacement", false, func(car *models.Car) any { return &car.Engine.Displacement }},
+		{"noOfCylinders", "e.no_of_cylinders", false, func(car *models.Car) any { return &car.Engine.NoOfCylinders }},
+		{"carRange", "e.car_range", false, func(car *models.Car) any { return &car.Engine.CarRange }},
+		{"updated_at", "e.updated_at", true, func(car *models.Car) any { return &car.Engine.UpdatedAt }},
+		{"version", "e.version", true, func(car *models.Car) any { return &car.Engine.Version }},
+	}
+)
+
+// projection is what a read of the fields of a models.FieldSet selects
+// from cars aliased c and, when join is set, engines aliased e.
+type projection struct {
+	columns string
+	join    bool
+	dests   []func(car *models.Car) any
+}
+
+func project(fields models.FieldSet) projection {
+	var p projection
+	var columns []string
+	add := func(candidates []projectedColumn, has func(field string) bool) {
+		for _, c := range candidates {
+			if c.always || has(c.field) {
+				columns = append(columns, c.column)
+				p.dests = append(p.dests, c.dest)
+			}
 		}
-		return car, err
 	}
+	add(projectedCarColumns, fields.Has)
+	if p.join = fields.JoinsEngine(); p.join {
+		add(projectedEngineColumns, fields.HasEngine)
+	}
+	p.columns = strings.Join(columns, ", ")
+	return p
+}
 
-	return car, nil
+// from is the FROM clause of the read, joining engines when the projection
+// or a filter using them needs it.
+func (p projection) from(filterJoins bool) string {
+	if p.join || filterJoins {
+		return "from cars c\n\tleft join engines e on c.engine_id = e.id"
+	}
+	return "from cars c"
 }
-func (s Store) GetCarByBrand(ctx context.Context, brand string, isEngine bool) ([]models.Car, error) {
-	var cars []models.Car
-	var query string
-	if isEngine {
-		query = `SELECT c.id, c.name, c.year, c.brand, c.fuel_type, c.price, c.engine_id, c.created_at, c.updated_at, e.id, e.displacement, e.no_of_cylinders, e.car_range 
-		from car c 
-		left join engines e on c.engine_id = e.id 
-		where c.brand = $1`
-	} else {
-		query = `SELECT id, name, year, brand, fuel_type, price, engine_id, created_at, updated_at
-		from car
-		where brand = $1`
+
+func (p projection) scan(row scanner) (models.Car, error) {
+	var car models.Car
+	dest := make([]any, len(p.dests))
+	for i, d := range p.dests {
+		dest[i] = d(&car)
 	}
-	rows, err := s.db.QueryContext(ctx, query, brand)
+	err := row.Scan(dest...)
+	return car, err
+}
+
+// queryCars runs a read selecting the projection's columns and scans its
+// rows.
+func (p projection) queryCars(ctx context.Context, conn driver.Querier, query string, args ...any) ([]models.Car, error) {
+	rows, err := conn.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, driver.MapError(err)
 	}
 	defer rows.Close()
 
+	var cars []models.Car
 	for rows.Next() {
-		var car models.Car
-		if isEngine {
-			var engine models.Engine
-			err := rows.Scan(
-				&car.ID,
-				&car.Name,
-				&car.Year,
-				&car.Brand,
-				&car.FuelType,
-				&car.Price,
-				&car.Engine.EngineID,
-				&car.CreatedAt,
-				&car.UpdatedAt,
-				&engine.EngineID,
-				&engine.Displacement,
-				&engine.NoOfCylinders,
-				&engine.CarRange)
-			if err != nil {
-				return nil, err
-			}
-			car.Engine = engine
-		} else {
-			err := rows.Scan(
-				&car.ID,
-				&car.Name,
-				&car.Year,
-				&car.Brand,
-				&car.FuelType,
-				&car.Price,
-				&car.Engine.EngineID,
-				&car.CreatedAt,
-				&car.UpdatedAt)
-			if err != nil {
-				return nil, err
-			}
+		car, err := p.scan(rows)
+		if err != nil {
+			return nil, driver.MapError(err)
 		}
-
 		cars = append(cars, car)
 	}
-
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return nil, driver.MapError(err)
 	}
 	return cars, nil
-
 }
 
-func (s *Store) CreateCar(ctx context.Context, carReq *models.CarRequest) (models.Car, error) {
-	var createdCar models.Car
-	var engineID uuid.UUID
+// liveCarVersion is the version query driver.MissingOrStale needs for
+// writes to cars that are not in the trash.
+const liveCarVersion = `SELECT version FROM cars WHERE id = $1 AND deleted_at IS NULL`
+
+type Store struct {
+	db *sql.DB
+	tx *driver.TxManager
+}
 
-	err := s.db.QueryRowContext(ctx, "SELECT id FROM engines WHERE id = $1", carReq.Engine.EngineID).Scan(&engineID)
+func New(db *sql.DB) *Store {
+	return &Store{
+		db: db,
+		tx: driver.NewTxManager(db),
+	}
+}
+func (s *Store) GetCarById(ctx context.Context, id string, fields models.FieldSet) (models.Car, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return models.Car{}, apperrors.Validation("invalid car id %q", id)
+	}
+	p := project(fields)
+	query := `SELECT ` + p.columns + `
+	` + p.from(false) + `
+	where c.id = $1 and c.deleted_at is null`
 
+	car, err := p.scan(driver.Conn(ctx, s.db).QueryRowContext(ctx, query, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return createdCar, errors.New("engine id does not found in the engines table")
+			return car, apperrors.NotFound("car %s not found", id)
 		}
-		return createdCar, err
+		return car, driver.MapError(err)
 	}
+
+	return car, nil
+}
+func (s Store) GetCarByBrand(ctx context.Context, brand string, fields models.FieldSet) ([]models.Car, error) {
+	p := project(fields)
+	return p.queryCars(ctx, driver.Conn(ctx, s.db), `SELECT `+p.columns+`
+	`+p.from(false)+`
+	where c.brand = $1 and c.deleted_at is null
+	order by c.created_at, c.id`, brand)
+}
+
+// CreateCar inserts a car whose engine must already exist. Creating the
+// engine in the same unit of work is up to the caller.
+func (s *Store) CreateCar(ctx context.Context, carReq *models.CarRequest) (models.Car, error) {
+	var createdCar models.Car
 	carId := uuid.New()
 	createdAt := time.Now()
-	updatedAt := createdAt
-	newCar := models.Car{
-		ID:        carId,
-		Name:      carReq.Name,
-		Year:      carReq.Year,
-		Brand:     carReq.Brand,
-		FuelType:  carReq.FuelType,
-		Engine:    carReq.Engine,
-		Price:     carReq.Price,
-		CreatedAt: createdAt,
-		UpdatedAt: updatedAt,
-	}
-	// Begain the Transaction
-	tx, err := s.db.BeginTx(ctx, nil)
-	if err != nil {
-		return createdCar, err
-	}
-	defer func() {
+	err := s.tx.WithinTx(ctx, func(ctx context.Context) error {
+		conn := driver.Conn(ctx, s.db)
+		engine, err := existingEngine(ctx, conn, carReq.Engine.EngineID)
+		if err != nil {
+			return err
+		}
+
+		// Insert the car into the cars table
+
+		query := `
+	INSERT INTO cars AS c (id, name, year, brand, fuel_type, engine_id, price, created_at, updated_at, version) 
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8, 1)
+	RETURNING ` + carColumns
+		createdCar, err = scanCar(conn.QueryRowContext(ctx, query,
+			carId,
+			carReq.Name,
+			carReq.Year,
+			carReq.Brand,
+			carReq.FuelType,
+			engine.EngineID,
+			carReq.Price,
+			createdAt))
 		if err != nil {
-			tx.Rollback()
-			return
+			return driver.MapError(err)
 		}
-		err = tx.Commit()
-	}()
-
-	// Insert the car into the cars table
-
-	query := `
-	INSERT INTO cars (id, name, year, brand, fuel_type, engine_id, price, created_at, updated_at) 
-	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
-	RETURNING id, name, year, brand, fuel_type, engine_id, price, created_at, updated_at;
-	`
-	err = tx.QueryRowContext(ctx, query,
-		newCar.ID,
-		newCar.Name,
-		newCar.Year,
-		newCar.Brand,
-		newCar.FuelType,
-		newCar.Engine.EngineID,
-		newCar.Price,
-		newCar.CreatedAt,
-		newCar.UpdatedAt).Scan(
-		&createdCar.ID,
-		&createdCar.Name,
-		&createdCar.Year,
-		&createdCar.Brand,
-		&createdCar.FuelType,
-		&createdCar.Engine.EngineID,
-		&createdCar.Price,
-		&createdCar.CreatedAt,
-		&createdCar.UpdatedAt,
-	)
+		createdCar.Engine = engine
+		return nil
+	})
 	if err != nil {
-		return createdCar, err
+		return models.Car{}, err
 	}
-
 	return createdCar, nil
+}
+
+// CreateCars inserts a batch of cars with a single statement. Like
+// CreateCar, it needs their engines to exist already.
+func (s *Store) CreateCars(ctx context.Context, carReqs []models.CarRequest) ([]models.Car, error) {
+	if len(carReqs) == 0 {
+		return nil, nil
+	}
+	createdCars := make([]models.Car, len(carReqs))
+	createdAt := time.Now()
+	err := s.tx.WithinTx(ctx, func(ctx context.Context) error {
+		conn := driver.Conn(ctx, s.db)
+		engines := make(map[uuid.UUID]models.Engine)
+		positions := make(map[uuid.UUID]int, len(carReqs))
+		values := make([]string, len(carReqs))
+		var args []any
+		for i, carReq := range carReqs {
+			engineID := carReq.Engine.EngineID
+			if _, ok := engines[engineID]; !ok {
+				engine, err := existingEngine(ctx, conn, engineID)
+				if err != nil {
+					return err
+				}
+				engines[engineID] = engine
+			}
+			carID := uuid.New()
+			positions[carID] = i
+			n := len(args)
+			values[i] = fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, 1)", n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8, n+8)
+			args = append(args, carID, carReq.Name, carReq.Year, carReq.Brand, carReq.FuelType, engineID, carReq.Price, createdAt)
+		}
 
+		rows, err := conn.QueryContext(ctx, `
+	INSERT INTO cars AS c (id, name, year, brand, fuel_type, engine_id, price, created_at, updated_at, version)
+	VALUES `+strings.Join(values, ", ")+`
+	RETURNING `+carColumns, args...)
+		if err != nil {
+			return driver.MapError(err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			car, err := scanCar(rows)
+			if err != nil {
+				return driver.MapError(err)
+			}
+			car.Engine = engines[car.Engine.EngineID]
+			createdCars[positions[car.ID]] = car
+		}
+		return driver.MapError(rows.Err())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return createdCars, nil
 }
-func (s *Store) UpdateCar(ctx context.Context, id string, carReq *models.CarRequest) (models.Car, error) {
+
+// UpdateCar replaces a car. A non-zero ifVersion makes the update
+// conditional on the car still being at that version.
+func (s *Store) UpdateCar(ctx context.Context, id string, carReq *models.CarRequest, ifVersion int64) (models.Car, error) {
 	var updatedCar models.Car
-	tx, err := s.db.BeginTx(ctx, nil)
+	if _, err := uuid.Parse(id); err != nil {
+		return updatedCar, apperrors.Validation("invalid car id %q", id)
+	}
+	err := s.tx.WithinTx(ctx, func(ctx context.Context) error {
+		conn := driver.Conn(ctx, s.db)
+		engine, err := existingEngine(ctx, conn, carReq.Engine.EngineID)
+		if err != nil {
+			return err
+		}
+
+		query := `
+	UPDATE cars AS c
+	SET name = $2, year = $3, brand = $4, fuel_type = $5, engine_id = $6, price = $7, updated_at = $8, version = c.version + 1
+	WHERE c.id = $1 AND c.deleted_at IS NULL AND ($9::bigint = 0 OR c.version = $9)
+	RETURNING ` + carColumns
+		updatedCar, err = scanCar(conn.QueryRowContext(ctx, query,
+			id,
+			carReq.Name,
+			carReq.Year,
+			carReq.Brand,
+			carReq.FuelType,
+			engine.EngineID,
+			carReq.Price,
+			time.Now(),
+			ifVersion))
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return driver.MissingOrStale(ctx, conn, liveCarVersion, "car", id, ifVersion)
+			}
+			return driver.MapError(err)
+		}
+		updatedCar.Engine = engine
+		return nil
+	})
 	if err != nil {
-		return updatedCar, err
+		return models.Car{}, err
 	}
-	defer func() {
+	return updatedCar, nil
+}
+
+// PatchCar updates only the columns set in patch and returns the car with
+// its engine joined. ifVersion works as for UpdateCar.
+func (s *Store) PatchCar(ctx context.Context, id string, patch models.CarPatch, ifVersion int64) (models.Car, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return models.Car{}, apperrors.Validation("invalid car id %q", id)
+	}
+	var patchedCar models.Car
+	err := s.tx.WithinTx(ctx, func(ctx context.Context) error {
+		conn := driver.Conn(ctx, s.db)
+		if patch.EngineID != nil {
+			if _, err := existingEngine(ctx, conn, *patch.EngineID); err != nil {
+				return err
+			}
+		}
+
+		args := []any{id, ifVersion}
+		sets := []string{"version = c.version + 1"}
+		set := func(column string, value any) {
+			args = append(args, value)
+			sets = append(sets, fmt.Sprintf("%s = $%d", column, len(args)))
+		}
+		if patch.Name != nil {
+			set("name", *patch.Name)
+		}
+		if patch.Year != nil {
+			set("year", *patch.Year)
+		}
+		if patch.Brand != nil {
+			set("brand", *patch.Brand)
+		}
+		if patch.FuelType != nil {
+			set("fuel_type", *patch.FuelType)
+		}
+		if patch.EngineID != nil {
+			set("engine_id", *patch.EngineID)
+		}
+		if patch.Price != nil {
+			set("price", *patch.Price)
+		}
+		set("updated_at", time.Now())
+
+		result, err := conn.ExecContext(ctx, `UPDATE cars AS c SET `+strings.Join(sets, ", ")+` WHERE c.id = $1 AND c.deleted_at IS NULL AND ($2::bigint = 0 OR c.version = $2)`, args...)
+		if err != nil {
+			return driver.MapError(err)
+		}
+		rowsAffected, err := result.RowsAffected()
 		if err != nil {
-			tx.Rollback()
-			return
+			return driver.MapError(err)
+		}
+		if rowsAffected == 0 {
+			return driver.MissingOrStale(ctx, conn, liveCarVersion, "car", id, ifVersion)
 		}
-		err = tx.Commit()
-	}()
-	query := `
-	UPDATE cars
-	SET name = $2, year = $3, brand = $4, fuel_type = $5, engine_id = $6, price = $7, updated_at = $8
-	WHERE id = $1 
-	RETURNING id, name, year, brand, fuel_type, engine_id, price, created_at, updated_at;
-	`
-	err = tx.QueryRowContext(ctx, query,
-		id,
-		carReq.Name,
-		carReq.Year,
-		carReq.Brand,
-		carReq.FuelType,
-		carReq.Engine.EngineID,
-		carReq.Price,
-		time.Now()).Scan(
-		&updatedCar.ID,
-		&updatedCar.Name,
-		&updatedCar.Year,
-		&updatedCar.Brand,
-		&updatedCar.FuelType,
-		&updatedCar.Engine.EngineID,
-		&updatedCar.Price,
-		&updatedCar.CreatedAt,
-		&updatedCar.UpdatedAt,
-	)
+		patchedCar, err = s.GetCarById(ctx, id, models.AllFields)
+		return err
+	})
 	if err != nil {
-		return updatedCar, err
+		return models.Car{}, err
 	}
+	return patchedCar, nil
+}
 
-	return updatedCar, nil
+// DeleteCar moves a car to the trash and returns it. ifVersion works as for
+// UpdateCar.
+func (s *Store) DeleteCar(ctx context.Context, id string, ifVersion int64) (models.Car, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return models.Car{}, apperrors.Validation("invalid car id %q", id)
+	}
+	var deletedCar models.Car
+	err := s.tx.WithinTx(ctx, func(ctx context.Context) error {
+		conn := driver.Conn(ctx, s.db)
+		var err error
+		deletedCar, err = scanCar(conn.QueryRowContext(ctx, `UPDATE cars AS c SET deleted_at = $3, version = c.version + 1
+	WHERE c.id = $1 AND c.deleted_at IS NULL AND ($2::bigint = 0 OR c.version = $2)
+	RETURNING `+carColumns, id, ifVersion, time.Now()))
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return driver.MissingOrStale(ctx, conn, liveCarVersion, "car", id, ifVersion)
+			}
+			return driver.MapError(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return models.Car{}, err
+	}
+	return deletedCar, nil
+}
 
+// RestoreCar takes a car out of the trash.
+func (s *Store) RestoreCar(ctx context.Context, id string) (models.Car, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return models.Car{}, apperrors.Validation("invalid car id %q", id)
+	}
+	var restoredCar models.Car
+	err := s.tx.WithinTx(ctx, func(ctx context.Context) error {
+		conn := driver.Conn(ctx, s.db)
+		result, err := conn.ExecContext(ctx, `UPDATE cars SET deleted_at = NULL, updated_at = $2, version = version + 1 WHERE id = $1 AND deleted_at IS NOT NULL`, id, time.Now())
+		if err != nil {
+			return driver.MapError(err)
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return driver.MapError(err)
+		}
+		if rowsAffected == 0 {
+			return apperrors.NotFound("car %s is not in the trash", id)
+		}
+		restoredCar, err = s.GetCarById(ctx, id, models.AllFields)
+		return err
+	})
+	if err != nil {
+		return models.Car{}, err
+	}
+	return restoredCar, nil
 }
-func (s *Store) DeleteCar(ctx context.Context, id string) (models.Car, error) {
-	var deltedCar models.Car
 
-	tx, err := s.db.BeginTx(ctx, nil)
+// PurgeDeletedCars removes the cars moved to the trash before the given
+// time for good, and returns them.
+func (s *Store) PurgeDeletedCars(ctx context.Context, before time.Time) ([]models.Car, error) {
+	rows, err := driver.Conn(ctx, s.db).QueryContext(ctx, `DELETE FROM cars AS c WHERE c.deleted_at < $1 RETURNING `+carColumns, before)
 	if err != nil {
-		return deltedCar, err
+		return nil, driver.MapError(err)
 	}
-	defer func() {
+	defer rows.Close()
+	var purged []models.Car
+	for rows.Next() {
+		car, err := scanCar(rows)
 		if err != nil {
-			tx.Rollback()
-			return
+			return nil, driver.MapError(err)
 		}
-		err = tx.Commit()
-	}()
-	err = tx.QueryRowContext(ctx, `SELECT id, name, year, brand, fuel_type, engine_id, price, created_at, updated_at FROM engines WHERE id = $1`, id).Scan(
-		&deltedCar.ID,
-		&deltedCar.Name,
-		&deltedCar.Year,
-		&deltedCar.Brand,
-		&deltedCar.FuelType,
-		&deltedCar.Engine.EngineID,
-		&deltedCar.Price,
-		&deltedCar.CreatedAt,
-		&deltedCar.UpdatedAt,
-	)
+		purged = append(purged, car)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, driver.MapError(err)
+	}
+	return purged, nil
+}
+
+// carVersionQuery selects one row of car_versions aliased c, joined to the
+// version its engine was at at the moment given by engineAt. where picks
+// the car version and must leave the best match first.
+func carVersionQuery(where, engineAt string) string {
+	return `SELECT ` + carColumns + `, ` + engineColumns + `
+	FROM car_versions c
+	LEFT JOIN LATERAL (
+		SELECT * FROM engine_versions ev
+		WHERE ev.id = c.engine_id AND ev.valid_from <= ` + engineAt + `
+		ORDER BY ev.version DESC LIMIT 1
+	) e ON true
+	WHERE ` + where
+}
+
+// GetCarAsOf returns a car, with its engine, as they were at the given
+// moment. A car that did not exist then or was in the trash is not found.
+func (s *Store) GetCarAsOf(ctx context.Context, id string, at time.Time) (models.Car, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return models.Car{}, apperrors.Validation("invalid car id %q", id)
+	}
+	query := carVersionQuery(`c.id = $1 AND c.valid_from <= $2 ORDER BY c.version DESC LIMIT 1`, `$2`)
+	car, err := scanCarWithEngine(driver.Conn(ctx, s.db).QueryRowContext(ctx, query, id, at))
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && car.DeletedAt != nil) {
+		return models.Car{}, apperrors.NotFound("car %s did not exist at %s", id, at.Format(time.RFC3339))
+	}
+	if err != nil {
+		return models.Car{}, driver.MapError(err)
+	}
+	return car, nil
+}
+
+// GetCarVersion returns a car as it was at one of its versions, with its
+// engine as it was when that version was written.
+func (s *Store) GetCarVersion(ctx context.Context, id string, version int64) (models.Car, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return models.Car{}, apperrors.Validation("invalid car id %q", id)
+	}
+	query := carVersionQuery(`c.id = $1 AND c.version = $2`, `c.valid_from`)
+	car, err := scanCarWithEngine(driver.Conn(ctx, s.db).QueryRowContext(ctx, query, id, version))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Car{}, apperrors.NotFound("car %s has no version %d", id, version)
+	}
+	if err != nil {
+		return models.Car{}, driver.MapError(err)
+	}
+	return car, nil
+}
+
+// existingEngine loads the engine a car is about to reference, locking it
+// so that it cannot be deleted before the car row is written.
+func existingEngine(ctx context.Context, conn driver.Querier, id uuid.UUID) (models.Engine, error) {
+	var engine models.Engine
+	err := conn.QueryRowContext(ctx, `SELECT `+engineColumns+` FROM engines e WHERE e.id = $1 FOR SHARE`, id).Scan(engineFields(&engine)...)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return models.Car{}, errors.New("car not found")
+			return engine, apperrors.Validation("engine %s does not exist", id)
 		}
-		return models.Car{}, err
+		return engine, driver.MapError(err)
 	}
+	return engine, nil
+}
 
-	result, err := tx.ExecContext(ctx, `DELETE FROM engines WHERE id = $1`, id)
-	if err != nil {
-		return models.Car{}, err
+// sortColumns maps the sortable car fields to SQL. Text columns use the C
+// collation so that ordering matches byte order in every backend.
+var sortColumns = map[string]string{
+	"price":      "c.price",
+	"year":       `c.year COLLATE "C"`,
+	"created_at": "c.created_at",
+	"name":       `c.name COLLATE "C"`,
+}
+
+func (s *Store) ListCars(ctx context.Context, query models.CarListQuery) ([]models.Car, error) {
+	boundary := query.After
+	backward := query.Before != nil
+	if backward {
+		boundary = query.Before
 	}
-	rowsAffected, err := result.RowsAffected()
+
+	keys := make([]sqlbuilder.SortKey, 0, len(query.Sort)+1)
+	for _, sf := range query.Sort {
+		column, ok := sortColumns[sf.Field]
+		if !ok {
+			return nil, apperrors.Validation("cannot sort by %s", sf.Field)
+		}
+		key := sqlbuilder.SortKey{Column: column, Desc: sf.Desc}
+		if boundary != nil {
+			switch sf.Field {
+			case "price":
+				key.Value = boundary.Price
+			case "year":
+				key.Value = boundary.Year
+			case "created_at":
+				key.Value = boundary.CreatedAt
+			case "name":
+				key.Value = boundary.Name
+			}
+		}
+		keys = append(keys, key)
+	}
+	idKey := sqlbuilder.SortKey{Column: "c.id"}
+	if boundary != nil {
+		idKey.Value = boundary.ID
+	}
+	keys = append(keys, idKey)
+
+	var where sqlbuilder.Where
+	if query.Deleted {
+		where.AddRaw("c.deleted_at IS NOT NULL")
+	} else {
+		where.AddRaw("c.deleted_at IS NULL")
+	}
+	if err := applyFilter(&where, query.Filter); err != nil {
+		return nil, err
+	}
+	orderBy := where.Keyset(keys, boundary != nil, backward)
+
+	p := project(query.Fields)
+	sqlQuery := `SELECT ` + p.columns + `
+	` + p.from(query.Filter.UsesEngine()) + `
+	` + where.SQL()
+	sqlQuery += fmt.Sprintf("\n\torder by %s\n\tlimit %s", orderBy, where.Arg(query.Limit))
+
+	cars, err := p.queryCars(ctx, driver.Conn(ctx, s.db), sqlQuery, where.Args()...)
 	if err != nil {
-		return models.Car{}, err
+		return nil, err
+	}
+
+	if backward {
+		for i, j := 0, len(cars)-1; i < j; i, j = i+1, j-1 {
+			cars[i], cars[j] = cars[j], cars[i]
+		}
+	}
+	return cars, nil
+}
+
+// exprColumns maps models.CarExprFields to SQL. Text columns use the C
+// collation so that comparisons match the in-memory store.
+var exprColumns = map[string]string{
+	"name":                 `c.name COLLATE "C"`,
+	"brand":                `c.brand COLLATE "C"`,
+	"fuel_type":            `c.fuel_type COLLATE "C"`,
+	"year":                 "c.year::int",
+	"price":                "c.price",
+	"engine.displacement":  "e.displacement",
+	"engine.noOfCylinders": "e.no_of_cylinders",
+	"engine.carRange":      "e.car_range",
+}
+
+// applyFilter adds the conditions of filter to where. It expects cars to be
+// aliased c and joined engines e.
+func applyFilter(where *sqlbuilder.Where, filter models.CarFilter) error {
+	if filter.NameContains != "" {
+		where.Add(`c.name ILIKE ? ESCAPE '\'`, "%"+sqlbuilder.EscapeLike(filter.NameContains)+"%")
+	}
+	sqlbuilder.In(where, "c.fuel_type", filter.FuelTypes)
+	if filter.PriceMin != nil {
+		where.Add("c.price >= ?", *filter.PriceMin)
+	}
+	if filter.PriceMax != nil {
+		where.Add("c.price <= ?", *filter.PriceMax)
 	}
-	if rowsAffected == 0 {
-		return models.Car{}, errors.New("car not found")
+	if filter.YearMin != nil {
+		where.Add("c.year::int >= ?", *filter.YearMin)
 	}
+	if filter.YearMax != nil {
+		where.Add("c.year::int <= ?", *filter.YearMax)
+	}
+	if filter.DisplacementMin != nil {
+		where.Add("e.displacement >= ?", *filter.DisplacementMin)
+	}
+	if filter.DisplacementMax != nil {
+		where.Add("e.displacement <= ?", *filter.DisplacementMax)
+	}
+	if filter.CylindersMin != nil {
+		where.Add("e.no_of_cylinders >= ?", *filter.CylindersMin)
+	}
+	if filter.CylindersMax != nil {
+		where.Add("e.no_of_cylinders <= ?", *filter.CylindersMax)
+	}
+	if filter.RangeMin != nil {
+		where.Add("e.car_range >= ?", *filter.RangeMin)
+	}
+	if filter.RangeMax != nil {
+		where.Add("e.car_range <= ?", *filter.RangeMax)
+	}
+	if filter.Expr != nil {
+		return filter.Expr.AddTo(where, exprColumns)
+	}
+	return nil
+}
 
-	return models.Car{}, nil
+func (s *Store) GetCarsByIDs(ctx context.Context, ids []uuid.UUID, fields models.FieldSet) ([]models.Car, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	var where sqlbuilder.Where
+	sqlbuilder.In(&where, "c.id", ids)
+	where.Add("c.deleted_at is null")
+	p := project(fields)
+	return p.queryCars(ctx, driver.Conn(ctx, s.db), `SELECT `+p.columns+`
+	`+p.from(false)+`
+	`+where.SQL(), where.Args()...)
+}
 
+// GetCarsByEngineID returns every car whose engine_id references engineID.
+func (s *Store) GetCarsByEngineID(ctx context.Context, engineID string, fields models.FieldSet) ([]models.Car, error) {
+	if _, err := uuid.Parse(engineID); err != nil {
+		return nil, apperrors.Validation("invalid engine id %q", engineID)
+	}
+	p := project(fields)
+	return p.queryCars(ctx, driver.Conn(ctx, s.db), `SELECT `+p.columns+`
+	`+p.from(false)+`
+	where c.engine_id = $1 and c.deleted_at is null
+	order by c.created_at, c.id`, engineID)
 }
@@ -5,162 +5,372 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"golangSecond/apperrors"
+	"golangSecond/driver"
 	"golangSecond/models"
+	"golangSecond/store/sqlbuilder"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
 
 type EngineStore struct {
 	db *sql.DB
+	tx *driver.TxManager
 }
 
 func New(db *sql.DB) *EngineStore {
 	return &EngineStore{
 		db: db,
+		tx: driver.NewTxManager(db),
 	}
 }
-func (e EngineStore) EngineById(ctx context.Context, id string) (models.Engine, error) {
+
+// engineColumns are the columns every engine query returns, in the order
+// scanEngine reads them.
+const engineColumns = `id, displacement, no_of_cylinders, car_range, updated_at, version`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanEngine(row scanner) (models.Engine, error) {
 	var engine models.Engine
-	tx, err := e.db.BeginTx(ctx, nil)
-	if err != nil {
-		return engine, err
+	err := row.Scan(&engine.EngineID, &engine.Displacement, &engine.NoOfCylinders, &engine.CarRange, &engine.UpdatedAt, &engine.Version)
+	return engine, err
+}
+
+// projectedColumns are the columns reads of a models.FieldSet may select,
+// keyed by the field of models.EngineFields they hold. The id, updated_at
+// and version are always selected, for ETags, Last-Modified and cursors.
+var projectedColumns = []struct {
+	field  string
+	column string
+	dest   func(engine *models.Engine) any
+}{
+	{"engine_id", "id", func(engine *models.Engine) any { return &engine.EngineID }},
+	{"displacement", "displacement", func(engine *models.Engine) any { return &engine.Displacement }},
+	{"noOfCylinders", "no_of_cylinders", func(engine *models.Engine) any { return &engine.NoOfCylinders }},
+	{"carRange", "car_range", func(engine *models.Engine) any { return &engine.CarRange }},
+	{"updated_at", "updated_at", func(engine *models.Engine) any { return &engine.UpdatedAt }},
+	{"version", "version", func(engine *models.Engine) any { return &engine.Version }},
+}
+
+// project returns the columns to select for fields and a scan reading them.
+func project(fields models.FieldSet) (string, func(row scanner) (models.Engine, error)) {
+	var columns []string
+	var dests []func(engine *models.Engine) any
+	for _, c := range projectedColumns {
+		if c.field == "engine_id" || c.field == "updated_at" || c.field == "version" || fields.Has(c.field) {
+			columns = append(columns, c.column)
+			dests = append(dests, c.dest)
+		}
 	}
-	defer func() {
-		if err != nil {
-			if rbErr := tx.Rollback(); rbErr != nil {
-				fmt.Printf("error rolling back transaction: %v", rbErr)
-			}
-		} else {
-			if cmErr := tx.Commit(); cmErr != nil {
-				fmt.Printf("error committing transaction: %v", cmErr)
-			}
+	return strings.Join(columns, ", "), func(row scanner) (models.Engine, error) {
+		var engine models.Engine
+		dest := make([]any, len(dests))
+		for i, d := range dests {
+			dest[i] = d(&engine)
 		}
-		err = tx.Commit()
-	}()
-	err = tx.QueryRowContext(ctx, `SELECT id, displacement, no_of_cylinders, car_range FROM engine WHERE id = $1`, id).Scan(
-		&engine.EngineID,
-		&engine.Displacement,
-		&engine.NoOfCylinders,
-		&engine.CarRange,
-	)
+		err := row.Scan(dest...)
+		return engine, err
+	}
+}
+
+func (e EngineStore) EngineById(ctx context.Context, id string, fields models.FieldSet) (models.Engine, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return models.Engine{}, apperrors.Validation("invalid engine id %q", id)
+	}
+	columns, scan := project(fields)
+	engine, err := scan(driver.Conn(ctx, e.db).QueryRowContext(ctx, `SELECT `+columns+` FROM engines WHERE id = $1`, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return engine, nil
+			return engine, apperrors.NotFound("engine %s not found", id)
 		}
-		return engine, err
+		return engine, driver.MapError(err)
 	}
-	return engine, err
+	return engine, nil
 }
-func (e EngineStore) EngineCreated(ctx context.Context, engineReq *models.EngineRequest) (models.Engine, error) {
-
-	tx, err := e.db.BeginTx(ctx, nil)
+func (e EngineStore) EngineCreate(ctx context.Context, engineReq *models.EngineRequest) (models.Engine, error) {
+	engine, err := scanEngine(driver.Conn(ctx, e.db).QueryRowContext(ctx, `INSERT INTO engines (id, displacement, no_of_cylinders, car_range, updated_at, version) VALUES ($1, $2, $3, $4, $5, 1)
+	RETURNING `+engineColumns, uuid.New(), engineReq.Displacement, engineReq.NoOfCylinders, engineReq.CarRange, time.Now()))
 	if err != nil {
-		return models.Engine{}, err
+		return models.Engine{}, driver.MapError(err)
 	}
-	defer func() {
-		if err != nil {
-			if rbErr := tx.Rollback(); rbErr != nil {
-				fmt.Printf("error rolling back transaction: %v", rbErr)
-			}
-		} else {
-			if cmErr := tx.Commit(); cmErr != nil {
-				fmt.Printf("error committing transaction: %v", cmErr)
-			}
-		}
-	}()
-	engineID := uuid.New()
-	_, err = tx.ExecContext(ctx, `INSERT INTO engine (id, displacement, no_of_cylinders, car_range) VALUES ($1, $2, $3, $4)`, engineID, engineReq.Displacement, engineReq.NoOfCylinders, engineReq.CarRange)
+	return engine, nil
+}
+
+// EngineUpdate replaces an engine. A non-zero ifVersion makes the update
+// conditional on the engine still being at that version.
+func (e EngineStore) EngineUpdate(ctx context.Context, id string, engineReq *models.EngineRequest, ifVersion int64) (models.Engine, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return models.Engine{}, apperrors.Validation("invalid engine id %q", id)
+	}
+	conn := driver.Conn(ctx, e.db)
+	engine, err := scanEngine(conn.QueryRowContext(ctx, `UPDATE engines SET displacement = $2, no_of_cylinders = $3, car_range = $4, updated_at = $6, version = version + 1
+	WHERE id = $1 AND ($5::bigint = 0 OR version = $5)
+	RETURNING `+engineColumns, id, engineReq.Displacement, engineReq.NoOfCylinders, engineReq.CarRange, ifVersion, time.Now()))
 	if err != nil {
-		return models.Engine{}, err
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Engine{}, driver.MissingOrStale(ctx, conn, `SELECT version FROM engines WHERE id = $1`, "engine", id, ifVersion)
+		}
+		return models.Engine{}, driver.MapError(err)
+	}
+	return engine, nil
+}
+
+// EnginePatch updates only the columns set in patch. ifVersion works as for
+// EngineUpdate.
+func (e EngineStore) EnginePatch(ctx context.Context, id string, patch models.EnginePatch, ifVersion int64) (models.Engine, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return models.Engine{}, apperrors.Validation("invalid engine id %q", id)
+	}
+	args := []any{id, ifVersion}
+	sets := []string{"version = version + 1"}
+	set := func(column string, value any) {
+		args = append(args, value)
+		sets = append(sets, fmt.Sprintf("%s = $%d", column, len(args)))
 	}
-	engine := models.Engine{
-		EngineID:      engineID,
-		Displacement:  engineReq.Displacement,
-		NoOfCylinders: engineReq.NoOfCylinders,
-		CarRange:      engineReq.CarRange,
+	if patch.Displacement != nil {
+		set("displacement", *patch.Displacement)
+	}
+	if patch.NoOfCylinders != nil {
+		set("no_of_cylinders", *patch.NoOfCylinders)
+	}
+	if patch.CarRange != nil {
+		set("car_range", *patch.CarRange)
+	}
+	set("updated_at", time.Now())
+	conn := driver.Conn(ctx, e.db)
+	engine, err := scanEngine(conn.QueryRowContext(ctx, `UPDATE engines SET `+strings.Join(sets, ", ")+` WHERE id = $1 AND ($2::bigint = 0 OR version = $2) RETURNING `+engineColumns, args...))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Engine{}, driver.MissingOrStale(ctx, conn, `SELECT version FROM engines WHERE id = $1`, "engine", id, ifVersion)
+		}
+		return models.Engine{}, driver.MapError(err)
 	}
 	return engine, nil
 }
-func (e EngineStore) EngineUpdate(ctx context.Context, id string, engineReq *models.EngineRequest) (models.Engine, error) {
+
+// EngineDelete deletes an engine, dealing with the cars that still use it
+// as opts.Strategy says. A cascade removes the live cars for good rather
+// than moving them to the trash, since they could not be restored without
+// their engine. Cars in the trash never block the delete: they follow
+// their engine to the replacement on detach and are purged otherwise, for
+// the same reason. Everything
+// happens in one transaction; the engine row is locked first so that no car
+// can start using it meanwhile. ifVersion works as for EngineUpdate.
+func (e EngineStore) EngineDelete(ctx context.Context, id string, opts models.EngineDeleteOptions, ifVersion int64) (models.Engine, []models.Car, error) {
+	var engine models.Engine
+	var cars []models.Car
 	engineID, err := uuid.Parse(id)
 	if err != nil {
-		return models.Engine{}, fmt.Errorf("invalid engine id: %v", err)
+		return engine, nil, apperrors.Validation("invalid engine id %q", id)
 	}
-	tx, err := e.db.BeginTx(ctx, nil)
-	if err != nil {
-		return models.Engine{}, err
+	if opts.Strategy == models.EngineDeleteDetach {
+		replacementID, err := uuid.Parse(opts.ReplacementID)
+		if err != nil {
+			return engine, nil, apperrors.Validation("invalid engine id %q", opts.ReplacementID)
+		}
+		if replacementID == engineID {
+			return engine, nil, apperrors.Validation("replacement engine %s is the engine being deleted", opts.ReplacementID)
+		}
 	}
-	defer func() {
+	err = e.tx.WithinTx(ctx, func(ctx context.Context) error {
+		conn := driver.Conn(ctx, e.db)
+		var err error
+		engine, err = scanEngine(conn.QueryRowContext(ctx, `SELECT `+engineColumns+` FROM engines WHERE id = $1 AND ($2::bigint = 0 OR version = $2) FOR UPDATE`, id, ifVersion))
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return driver.MissingOrStale(ctx, conn, `SELECT version FROM engines WHERE id = $1`, "engine", id, ifVersion)
+			}
+			return driver.MapError(err)
+		}
+
+		carIDs, err := dependentCarIDs(ctx, conn, id)
 		if err != nil {
-			if rbErr := tx.Rollback(); rbErr != nil {
-				fmt.Printf("error rolling back transaction: %v", rbErr)
+			return driver.MapError(err)
+		}
+		switch opts.Strategy {
+		case models.EngineDeleteDetach:
+			var exists bool
+			err = conn.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM engines WHERE id = $1)`, opts.ReplacementID).Scan(&exists)
+			if err == nil && !exists {
+				return apperrors.Validation("replacement engine %s does not exist", opts.ReplacementID)
 			}
-		} else {
-			if cmErr := tx.Commit(); cmErr != nil {
-				fmt.Printf("error committing transaction: %v", cmErr)
+			if err == nil {
+				cars, err = queryDependentCars(ctx, conn, `UPDATE cars SET engine_id = $2, updated_at = $3, version = version + 1 WHERE engine_id = $1
+	RETURNING `+dependentCarColumns, id, opts.ReplacementID, time.Now())
 			}
+		default:
+			if len(carIDs) > 0 && opts.Strategy != models.EngineDeleteCascade {
+				return apperrors.Wrap(apperrors.KindConflict, &models.EngineInUseError{EngineID: engine.EngineID, CarIDs: carIDs})
+			}
+			cars, err = queryDependentCars(ctx, conn, `DELETE FROM cars WHERE engine_id = $1 RETURNING `+dependentCarColumns, id)
+		}
+		if err != nil {
+			return driver.MapError(err)
 		}
-	}()
-	results, err := tx.ExecContext(ctx, `UPDATE engine SET displacement = $2, no_of_cylinders = $3, car_range = $4 WHERE id = $1`, engineID, engineReq.Displacement, engineReq.NoOfCylinders, engineReq.CarRange)
+
+		if _, err := conn.ExecContext(ctx, `DELETE FROM engines WHERE id = $1`, id); err != nil {
+			return driver.MapError(err)
+		}
+		return nil
+	})
 	if err != nil {
-		return models.Engine{}, err
+		return models.Engine{}, nil, err
 	}
-	rowsAffected, err := results.RowsAffected()
+	return engine, cars, nil
+}
+
+// dependentCarColumns are the columns of the cars EngineDelete removes or
+// detaches, in the order queryDependentCars reads them.
+const dependentCarColumns = `id, name, year, brand, fuel_type, price, engine_id, created_at, updated_at, version, deleted_at`
+
+// queryDependentCars runs a statement returning dependentCarColumns and
+// reads the cars, ordered as dependentCarIDs orders them.
+func queryDependentCars(ctx context.Context, conn driver.Querier, query string, args ...any) ([]models.Car, error) {
+	rows, err := conn.QueryContext(ctx, query, args...)
 	if err != nil {
-		return models.Engine{}, err
+		return nil, err
 	}
-	if rowsAffected == 0 {
-		return models.Engine{}, errors.New("engine not found")
+	defer rows.Close()
+	var cars []models.Car
+	for rows.Next() {
+		var car models.Car
+		if err := rows.Scan(&car.ID, &car.Name, &car.Year, &car.Brand, &car.FuelType, &car.Price, &car.Engine.EngineID, &car.CreatedAt, &car.UpdatedAt, &car.Version, &car.DeletedAt); err != nil {
+			return nil, err
+		}
+		cars = append(cars, car)
 	}
-	engine := models.Engine{
-		EngineID:      engineID,
-		Displacement:  engineReq.Displacement,
-		NoOfCylinders: engineReq.NoOfCylinders,
-		CarRange:      engineReq.CarRange,
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
-	return engine, nil
+	sort.Slice(cars, func(i, j int) bool {
+		if !cars[i].CreatedAt.Equal(cars[j].CreatedAt) {
+			return cars[i].CreatedAt.Before(cars[j].CreatedAt)
+		}
+		return cars[i].ID.String() < cars[j].ID.String()
+	})
+	return cars, nil
 }
-func (e EngineStore) EngineDelete(ctx context.Context, id string) (models.Engine, error) {
-	var engine models.Engine
-	tx, err := e.db.BeginTx(ctx, nil)
+
+func dependentCarIDs(ctx context.Context, conn driver.Querier, engineID string) ([]uuid.UUID, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT id FROM cars WHERE engine_id = $1 AND deleted_at IS NULL ORDER BY created_at, id FOR UPDATE`, engineID)
 	if err != nil {
-		return models.Engine{}, err
+		return nil, err
 	}
-	defer func() {
-		if err != nil {
-			if rbErr := tx.Rollback(); rbErr != nil {
-				fmt.Printf("error rolling back transaction: %v", rbErr)
-			}
-		} else {
-			if cmErr := tx.Commit(); cmErr != nil {
-				fmt.Printf("error committing transaction: %v", cmErr)
+	defer rows.Close()
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+var sortColumns = map[string]string{
+	"displacement":  "displacement",
+	"noOfCylinders": "no_of_cylinders",
+	"carRange":      "car_range",
+}
+
+var exprColumns = map[string]string{
+	"displacement":  "displacement",
+	"noOfCylinders": "no_of_cylinders",
+	"carRange":      "car_range",
+}
+
+func (e EngineStore) ListEngines(ctx context.Context, query models.EngineListQuery) ([]models.Engine, error) {
+	boundary := query.After
+	backward := query.Before != nil
+	if backward {
+		boundary = query.Before
+	}
+
+	keys := make([]sqlbuilder.SortKey, 0, len(query.Sort)+1)
+	for _, sf := range query.Sort {
+		column, ok := sortColumns[sf.Field]
+		if !ok {
+			return nil, apperrors.Validation("cannot sort by %s", sf.Field)
+		}
+		key := sqlbuilder.SortKey{Column: column, Desc: sf.Desc}
+		if boundary != nil {
+			switch sf.Field {
+			case "displacement":
+				key.Value = boundary.Displacement
+			case "noOfCylinders":
+				key.Value = boundary.NoOfCylinders
+			case "carRange":
+				key.Value = boundary.CarRange
 			}
 		}
-	}()
-	err = tx.QueryRowContext(ctx, `SELECT id, displacement, no_of_cylinders, car_range FROM engine WHERE id = $1`, id).Scan(
-		&engine.EngineID,
-		&engine.Displacement,
-		&engine.NoOfCylinders,
-		&engine.CarRange,
-	)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return engine, nil
+		keys = append(keys, key)
+	}
+	idKey := sqlbuilder.SortKey{Column: "id"}
+	if boundary != nil {
+		idKey.Value = boundary.EngineID
+	}
+	keys = append(keys, idKey)
+
+	var where sqlbuilder.Where
+	filter := query.Filter
+	if filter.DisplacementMin != nil {
+		where.Add("displacement >= ?", *filter.DisplacementMin)
+	}
+	if filter.DisplacementMax != nil {
+		where.Add("displacement <= ?", *filter.DisplacementMax)
+	}
+	if filter.CylindersMin != nil {
+		where.Add("no_of_cylinders >= ?", *filter.CylindersMin)
+	}
+	if filter.CylindersMax != nil {
+		where.Add("no_of_cylinders <= ?", *filter.CylindersMax)
+	}
+	if filter.RangeMin != nil {
+		where.Add("car_range >= ?", *filter.RangeMin)
+	}
+	if filter.RangeMax != nil {
+		where.Add("car_range <= ?", *filter.RangeMax)
+	}
+	if filter.Expr != nil {
+		if err := filter.Expr.AddTo(&where, exprColumns); err != nil {
+			return nil, err
 		}
-		return engine, err
 	}
-	results, err := tx.ExecContext(ctx, `DELETE FROM engine WHERE id = $1`, id)
+	orderBy := where.Keyset(keys, boundary != nil, backward)
+
+	columns, scan := project(query.Fields)
+	sqlQuery := `SELECT ` + columns + ` FROM engines ` + where.SQL()
+	sqlQuery += fmt.Sprintf(" ORDER BY %s LIMIT %s", orderBy, where.Arg(query.Limit))
+
+	rows, err := driver.Conn(ctx, e.db).QueryContext(ctx, sqlQuery, where.Args()...)
 	if err != nil {
-		return models.Engine{}, err
+		return nil, driver.MapError(err)
 	}
-	rowsAffected, err := results.RowsAffected()
-	if err != nil {
-		return models.Engine{}, err
+	defer rows.Close()
+
+	var engines []models.Engine
+	for rows.Next() {
+		engine, err := scan(rows)
+		if err != nil {
+			return nil, driver.MapError(err)
+		}
+		engines = append(engines, engine)
 	}
-	if rowsAffected == 0 {
-		return models.Engine{}, errors.New("no rows deleted")
+	if err := rows.Err(); err != nil {
+		return nil, driver.MapError(err)
 	}
 
-	return engine, nil
-
+	if backward {
+		for i, j := 0, len(engines)-1; i < j; i, j = i+1, j-1 {
+			engines[i], engines[j] = engines[j], engines[i]
+		}
+	}
+	return engines, nil
 }
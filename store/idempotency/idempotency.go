@@ -0,0 +1,140 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"golangSecond/apperrors"
+	"golangSecond/driver"
+	"golangSecond/models"
+	"time"
+)
+
+// Store is the Postgres implementation of store.IdempotencyStoreInterface.
+type Store struct {
+	db *sql.DB
+}
+
+func New(db *sql.DB) *Store {
+	return &Store{
+		db: db,
+	}
+}
+
+// ReserveIdempotencyKey inserts the record, taking over the row of an
+// expired one. When a live row keeps it from doing so, that row is read
+// back; should it expire or be released in between, reserving starts over.
+func (s *Store) ReserveIdempotencyKey(ctx context.Context, record models.IdempotencyRecord) (models.IdempotencyRecord, bool, error) {
+	conn := driver.Conn(ctx, s.db)
+	for {
+		now := time.Now()
+		var key string
+		err := conn.QueryRowContext(ctx, `
+		INSERT INTO idempotency_keys (actor, key, fingerprint, expires_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (actor, key) DO UPDATE
+		SET fingerprint = EXCLUDED.fingerprint, status = NULL, header = NULL, body = NULL, expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at <= $5
+		RETURNING key`,
+			record.Actor,
+			record.Key,
+			record.Fingerprint,
+			record.ExpiresAt,
+			now).Scan(&key)
+		if err == nil {
+			record.Response = nil
+			return record, true, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return models.IdempotencyRecord{}, false, driver.MapError(err)
+		}
+
+		existing, err := s.live(ctx, record.Actor, record.Key, now)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return models.IdempotencyRecord{}, false, driver.MapError(err)
+		}
+		return existing, false, nil
+	}
+}
+
+func (s *Store) live(ctx context.Context, actor, key string, now time.Time) (models.IdempotencyRecord, error) {
+	var record models.IdempotencyRecord
+	var status sql.NullInt64
+	var header, body []byte
+	err := driver.Conn(ctx, s.db).QueryRowContext(ctx, `
+	SELECT actor, key, fingerprint, status, header, body, expires_at
+	FROM idempotency_keys
+	WHERE actor = $1 AND key = $2 AND expires_at > $3`, actor, key, now).Scan(
+		&record.Actor,
+		&record.Key,
+		&record.Fingerprint,
+		&status,
+		&header,
+		&body,
+		&record.ExpiresAt)
+	if err != nil {
+		return models.IdempotencyRecord{}, err
+	}
+	if status.Valid {
+		record.Response = &models.StoredResponse{Status: int(status.Int64), Body: body}
+		if err := json.Unmarshal(header, &record.Response.Header); err != nil {
+			return models.IdempotencyRecord{}, err
+		}
+	}
+	return record, nil
+}
+
+func (s *Store) ExtendIdempotencyKey(ctx context.Context, actor, key string, expiresAt time.Time) error {
+	_, err := driver.Conn(ctx, s.db).ExecContext(ctx, `
+	UPDATE idempotency_keys SET expires_at = $3
+	WHERE actor = $1 AND key = $2 AND status IS NULL`, actor, key, expiresAt)
+	return driver.MapError(err)
+}
+
+func (s *Store) CompleteIdempotencyKey(ctx context.Context, actor, key string, response models.StoredResponse, expiresAt time.Time) error {
+	header, err := json.Marshal(response.Header)
+	if err != nil {
+		return err
+	}
+	result, err := driver.Conn(ctx, s.db).ExecContext(ctx, `
+	UPDATE idempotency_keys SET status = $3, header = $4, body = $5, expires_at = $6
+	WHERE actor = $1 AND key = $2`,
+		actor,
+		key,
+		response.Status,
+		header,
+		response.Body,
+		expiresAt)
+	if err != nil {
+		return driver.MapError(err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return driver.MapError(err)
+	}
+	if updated == 0 {
+		return apperrors.NotFound("idempotency key %q not found", key)
+	}
+	return nil
+}
+
+func (s *Store) ReleaseIdempotencyKey(ctx context.Context, actor, key string) error {
+	_, err := driver.Conn(ctx, s.db).ExecContext(ctx, `DELETE FROM idempotency_keys WHERE actor = $1 AND key = $2`, actor, key)
+	return driver.MapError(err)
+}
+
+func (s *Store) PurgeIdempotencyKeys(ctx context.Context, before time.Time) (int64, error) {
+	result, err := driver.Conn(ctx, s.db).ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= $1`, before)
+	if err != nil {
+		return 0, driver.MapError(err)
+	}
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, driver.MapError(err)
+	}
+	return purged, nil
+}
@@ -3,19 +3,84 @@ package store
 import (
 	"context"
 	"golangSecond/models"
+	"time"
+
+	"github.com/google/uuid"
 )
 
+// CarStoreInterface and EngineStoreInterface bump a row's version on every
+// write. Writes that take ifVersion only apply when the row is still at
+// that version, failing with apperrors.KindPreconditionFailed otherwise; 0
+// skips the check. Reads that take a models.FieldSet, directly or in their
+// query, need only fill in its fields and join a car's engine when it asks
+// for more than the engine's id; models.AllFields reads everything.
 type CarStoreInterface interface {
-	GetCarById(ctx context.Context, id string) (models.Car, error)
-	GetCarByBrand(ctx context.Context, brand string, isEngine bool) ([]models.Car, error)
+	GetCarById(ctx context.Context, id string, fields models.FieldSet) (models.Car, error)
+	GetCarByBrand(ctx context.Context, brand string, fields models.FieldSet) ([]models.Car, error)
+	ListCars(ctx context.Context, query models.CarListQuery) ([]models.Car, error)
+	GetCarsByEngineID(ctx context.Context, engineID string, fields models.FieldSet) ([]models.Car, error)
+	// GetCarsByIDs returns the cars among ids in no particular order. Ids of
+	// cars that do not exist are left out.
+	GetCarsByIDs(ctx context.Context, ids []uuid.UUID, fields models.FieldSet) ([]models.Car, error)
 	CreateCar(ctx context.Context, carReq *models.CarRequest) (models.Car, error)
-	UpdateCar(ctx context.Context, id string, carReq *models.CarRequest) (models.Car, error)
-	DeleteCar(ctx context.Context, id string) (models.Car, error)
+	// CreateCars creates several cars at once, all of them or none. It is
+	// for batches of a bounded size such as models.ImportBatchSize.
+	CreateCars(ctx context.Context, carReqs []models.CarRequest) ([]models.Car, error)
+	UpdateCar(ctx context.Context, id string, carReq *models.CarRequest, ifVersion int64) (models.Car, error)
+	PatchCar(ctx context.Context, id string, patch models.CarPatch, ifVersion int64) (models.Car, error)
+	// DeleteCar moves a car to the trash. Every other method but RestoreCar,
+	// PurgeDeletedCars and ListCars with query.Deleted ignores cars there.
+	DeleteCar(ctx context.Context, id string, ifVersion int64) (models.Car, error)
+	RestoreCar(ctx context.Context, id string) (models.Car, error)
+	// PurgeDeletedCars removes the cars moved to the trash before the given
+	// time for good and returns them, without their engines.
+	PurgeDeletedCars(ctx context.Context, before time.Time) ([]models.Car, error)
+	// Every version a car has been at is kept, along with those of its
+	// engines. GetCarAsOf fails with not found for a car that did not exist
+	// at that moment or was in the trash.
+	GetCarAsOf(ctx context.Context, id string, at time.Time) (models.Car, error)
+	GetCarVersion(ctx context.Context, id string, version int64) (models.Car, error)
 }
 
 type EngineStoreInterface interface {
-	EngineById(ctx context.Context, id string) (models.Engine, error)
+	EngineById(ctx context.Context, id string, fields models.FieldSet) (models.Engine, error)
+	ListEngines(ctx context.Context, query models.EngineListQuery) ([]models.Engine, error)
 	EngineCreate(ctx context.Context, engineReq *models.EngineRequest) (models.Engine, error)
-	EngineUpdate(ctx context.Context, id string, engineReq *models.EngineRequest) (models.Engine, error)
-	EngineDelete(ctx context.Context, id string) (models.Engine, error)
+	EngineUpdate(ctx context.Context, id string, engineReq *models.EngineRequest, ifVersion int64) (models.Engine, error)
+	EnginePatch(ctx context.Context, id string, patch models.EnginePatch, ifVersion int64) (models.Engine, error)
+	// EngineDelete returns the deleted engine and the cars that used it, in
+	// the trash or not, as the delete left them: removed cars as they were,
+	// detached ones on their replacement engine.
+	EngineDelete(ctx context.Context, id string, opts models.EngineDeleteOptions, ifVersion int64) (models.Engine, []models.Car, error)
+}
+
+// AuditStoreInterface keeps the audit trail of cars and engines. Entries
+// are only ever added; RecordAudit assigns Seq and, when unset, At.
+type AuditStoreInterface interface {
+	RecordAudit(ctx context.Context, entry models.AuditEntry) (models.AuditEntry, error)
+	ListAudit(ctx context.Context, query models.AuditListQuery) ([]models.AuditEntry, error)
+}
+
+// IdempotencyStoreInterface keeps the responses to requests made with an
+// idempotency key. Records are identified by actor and key together. A
+// record past its ExpiresAt counts as absent, and reserving its key
+// replaces it.
+type IdempotencyStoreInterface interface {
+	// ReserveIdempotencyKey saves record, which has no Response yet, and
+	// returns it with true. When a live record with the same actor and key
+	// exists it is returned instead, with false.
+	ReserveIdempotencyKey(ctx context.Context, record models.IdempotencyRecord) (models.IdempotencyRecord, bool, error)
+	// ExtendIdempotencyKey moves the ExpiresAt of a record still without a
+	// Response; it does nothing to finished or missing ones.
+	ExtendIdempotencyKey(ctx context.Context, actor, key string, expiresAt time.Time) error
+	CompleteIdempotencyKey(ctx context.Context, actor, key string, response models.StoredResponse, expiresAt time.Time) error
+	ReleaseIdempotencyKey(ctx context.Context, actor, key string) error
+	PurgeIdempotencyKeys(ctx context.Context, before time.Time) (int64, error)
+}
+
+// TxManager runs several store calls as one unit of work. Store methods
+// called with the context handed to fn take part in the transaction, and a
+// nested WithinTx joins the outer one rather than starting its own.
+type TxManager interface {
+	WithinTx(ctx context.Context, fn func(ctx context.Context) error) error
 }
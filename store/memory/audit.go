@@ -0,0 +1,59 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"golangSecond/models"
+)
+
+// AuditStore is a thread-safe implementation of store.AuditStoreInterface.
+type AuditStore struct {
+	db *DB
+}
+
+func NewAuditStore(db *DB) *AuditStore {
+	return &AuditStore{
+		db: db,
+	}
+}
+
+func (a *AuditStore) RecordAudit(ctx context.Context, entry models.AuditEntry) (models.AuditEntry, error) {
+	defer a.db.lock(ctx)()
+
+	if entry.At.IsZero() {
+		entry.At = time.Now()
+	}
+	entry.Seq = int64(len(a.db.audit)) + 1
+	a.db.audit = append(a.db.audit, entry)
+	return entry, nil
+}
+
+func (a *AuditStore) ListAudit(ctx context.Context, query models.AuditListQuery) ([]models.AuditEntry, error) {
+	defer a.db.rlock(ctx)()
+
+	var entries []models.AuditEntry
+	if query.Before != nil {
+		// Walking backwards the page is the one just after the boundary,
+		// collected oldest first and then put back in newest first order.
+		for _, entry := range a.db.audit[min(max(*query.Before, 0), int64(len(a.db.audit))):] {
+			if len(entries) == query.Limit {
+				break
+			}
+			if entry.EntityType == query.EntityType && entry.EntityID == query.EntityID {
+				entries = append([]models.AuditEntry{entry}, entries...)
+			}
+		}
+		return entries, nil
+	}
+	end := len(a.db.audit)
+	if query.After != nil {
+		end = int(min(max(*query.After-1, 0), int64(end)))
+	}
+	for i := end - 1; i >= 0 && len(entries) < query.Limit; i-- {
+		if entry := a.db.audit[i]; entry.EntityType == query.EntityType && entry.EntityID == query.EntityID {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,274 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"golangSecond/apperrors"
+	"golangSecond/models"
+
+	"github.com/google/uuid"
+)
+
+// CarStore is a thread-safe implementation of store.CarStoreInterface.
+type CarStore struct {
+	db *DB
+}
+
+func NewCarStore(db *DB) *CarStore {
+	return &CarStore{
+		db: db,
+	}
+}
+
+func (s *CarStore) GetCarById(ctx context.Context, id string, fields models.FieldSet) (models.Car, error) {
+	carID, err := parseID("car", id)
+	if err != nil {
+		return models.Car{}, err
+	}
+	defer s.db.rlock(ctx)()
+
+	car, ok := s.db.liveCar(carID)
+	if !ok {
+		return models.Car{}, apperrors.NotFound("car %s not found", id)
+	}
+	return s.db.project(car, fields), nil
+}
+
+func (s *CarStore) GetCarByBrand(ctx context.Context, brand string, fields models.FieldSet) ([]models.Car, error) {
+	defer s.db.rlock(ctx)()
+
+	cars := s.db.sortedCars(func(car models.Car) bool {
+		return car.DeletedAt == nil && car.Brand == brand
+	})
+	for i := range cars {
+		cars[i] = s.db.project(cars[i], fields)
+	}
+	return cars, nil
+}
+
+func (s *CarStore) CreateCar(ctx context.Context, carReq *models.CarRequest) (models.Car, error) {
+	defer s.db.lock(ctx)()
+
+	engine, ok := s.db.engines[carReq.Engine.EngineID]
+	if !ok {
+		return models.Car{}, apperrors.Validation("engine %s does not exist", carReq.Engine.EngineID)
+	}
+	now := time.Now()
+	car := models.Car{
+		ID:        uuid.New(),
+		Name:      carReq.Name,
+		Year:      carReq.Year,
+		Brand:     carReq.Brand,
+		FuelType:  carReq.FuelType,
+		Engine:    models.Engine{EngineID: engine.EngineID},
+		Price:     carReq.Price,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Version:   1,
+	}
+	s.db.putCar(car)
+	car.Engine = engine
+	return car, nil
+}
+
+func (s *CarStore) CreateCars(ctx context.Context, carReqs []models.CarRequest) ([]models.Car, error) {
+	defer s.db.lock(ctx)()
+
+	for _, carReq := range carReqs {
+		if _, ok := s.db.engines[carReq.Engine.EngineID]; !ok {
+			return nil, apperrors.Validation("engine %s does not exist", carReq.Engine.EngineID)
+		}
+	}
+	cars := make([]models.Car, len(carReqs))
+	now := time.Now()
+	for i, carReq := range carReqs {
+		car := models.Car{
+			ID:        uuid.New(),
+			Name:      carReq.Name,
+			Year:      carReq.Year,
+			Brand:     carReq.Brand,
+			FuelType:  carReq.FuelType,
+			Engine:    models.Engine{EngineID: carReq.Engine.EngineID},
+			Price:     carReq.Price,
+			CreatedAt: now,
+			UpdatedAt: now,
+			Version:   1,
+		}
+		s.db.putCar(car)
+		cars[i] = s.db.withEngine(car)
+	}
+	return cars, nil
+}
+
+func (s *CarStore) UpdateCar(ctx context.Context, id string, carReq *models.CarRequest, ifVersion int64) (models.Car, error) {
+	carID, err := parseID("car", id)
+	if err != nil {
+		return models.Car{}, err
+	}
+	defer s.db.lock(ctx)()
+
+	car, ok := s.db.liveCar(carID)
+	if !ok {
+		return models.Car{}, apperrors.NotFound("car %s not found", id)
+	}
+	if err := checkVersion("car", id, car.Version, ifVersion); err != nil {
+		return models.Car{}, err
+	}
+	if _, ok := s.db.engines[carReq.Engine.EngineID]; !ok {
+		return models.Car{}, apperrors.Validation("engine %s does not exist", carReq.Engine.EngineID)
+	}
+	car.Name = carReq.Name
+	car.Year = carReq.Year
+	car.Brand = carReq.Brand
+	car.FuelType = carReq.FuelType
+	car.Engine = models.Engine{EngineID: carReq.Engine.EngineID}
+	car.Price = carReq.Price
+	car.UpdatedAt = time.Now()
+	car.Version++
+	s.db.putCar(car)
+	return s.db.withEngine(car), nil
+}
+
+func (s *CarStore) PatchCar(ctx context.Context, id string, patch models.CarPatch, ifVersion int64) (models.Car, error) {
+	carID, err := parseID("car", id)
+	if err != nil {
+		return models.Car{}, err
+	}
+	defer s.db.lock(ctx)()
+
+	car, ok := s.db.liveCar(carID)
+	if !ok {
+		return models.Car{}, apperrors.NotFound("car %s not found", id)
+	}
+	if err := checkVersion("car", id, car.Version, ifVersion); err != nil {
+		return models.Car{}, err
+	}
+	if patch.EngineID != nil {
+		if _, ok := s.db.engines[*patch.EngineID]; !ok {
+			return models.Car{}, apperrors.Validation("engine %s does not exist", *patch.EngineID)
+		}
+	}
+	car = patch.Apply(car)
+	car.UpdatedAt = time.Now()
+	car.Version++
+	s.db.putCar(car)
+	return s.db.withEngine(car), nil
+}
+
+// DeleteCar moves a car to the trash.
+func (s *CarStore) DeleteCar(ctx context.Context, id string, ifVersion int64) (models.Car, error) {
+	carID, err := parseID("car", id)
+	if err != nil {
+		return models.Car{}, err
+	}
+	defer s.db.lock(ctx)()
+
+	car, ok := s.db.liveCar(carID)
+	if !ok {
+		return models.Car{}, apperrors.NotFound("car %s not found", id)
+	}
+	if err := checkVersion("car", id, car.Version, ifVersion); err != nil {
+		return models.Car{}, err
+	}
+	now := time.Now()
+	car.DeletedAt = &now
+	car.Version++
+	s.db.putCar(car)
+	return car, nil
+}
+
+func (s *CarStore) RestoreCar(ctx context.Context, id string) (models.Car, error) {
+	carID, err := parseID("car", id)
+	if err != nil {
+		return models.Car{}, err
+	}
+	defer s.db.lock(ctx)()
+
+	car, ok := s.db.cars[carID]
+	if !ok || car.DeletedAt == nil {
+		return models.Car{}, apperrors.NotFound("car %s is not in the trash", id)
+	}
+	car.DeletedAt = nil
+	car.UpdatedAt = time.Now()
+	car.Version++
+	s.db.putCar(car)
+	return s.db.withEngine(car), nil
+}
+
+func (s *CarStore) PurgeDeletedCars(ctx context.Context, before time.Time) ([]models.Car, error) {
+	defer s.db.lock(ctx)()
+
+	var purged []models.Car
+	for _, car := range s.db.cars {
+		if car.DeletedAt != nil && car.DeletedAt.Before(before) {
+			s.db.removeCar(car)
+			purged = append(purged, car)
+		}
+	}
+	return purged, nil
+}
+
+func (s *CarStore) ListCars(ctx context.Context, query models.CarListQuery) ([]models.Car, error) {
+	defer s.db.rlock(ctx)()
+
+	var cars []models.Car
+	for _, car := range s.db.cars {
+		if (car.DeletedAt != nil) != query.Deleted {
+			continue
+		}
+		car = s.db.withEngine(car)
+		if !query.Filter.Matches(car) {
+			continue
+		}
+		if query.After != nil && models.CompareCars(car, *query.After, query.Sort) <= 0 {
+			continue
+		}
+		if query.Before != nil && models.CompareCars(car, *query.Before, query.Sort) >= 0 {
+			continue
+		}
+		cars = append(cars, car)
+	}
+	sort.Slice(cars, func(i, j int) bool {
+		return models.CompareCars(cars[i], cars[j], query.Sort) < 0
+	})
+	// Walking backwards the page is the one just before the boundary.
+	if query.Before != nil && len(cars) > query.Limit {
+		cars = cars[len(cars)-query.Limit:]
+	} else if len(cars) > query.Limit {
+		cars = cars[:query.Limit]
+	}
+	for i := range cars {
+		cars[i] = s.db.project(cars[i], query.Fields)
+	}
+	return cars, nil
+}
+
+func (s *CarStore) GetCarsByIDs(ctx context.Context, ids []uuid.UUID, fields models.FieldSet) ([]models.Car, error) {
+	defer s.db.rlock(ctx)()
+
+	var cars []models.Car
+	for _, id := range ids {
+		if car, ok := s.db.liveCar(id); ok {
+			cars = append(cars, s.db.project(car, fields))
+		}
+	}
+	return cars, nil
+}
+
+func (s *CarStore) GetCarsByEngineID(ctx context.Context, engineID string, fields models.FieldSet) ([]models.Car, error) {
+	id, err := parseID("engine", engineID)
+	if err != nil {
+		return nil, err
+	}
+	defer s.db.rlock(ctx)()
+
+	cars := s.db.sortedCars(func(car models.Car) bool {
+		return car.DeletedAt == nil && car.Engine.EngineID == id
+	})
+	for i := range cars {
+		cars[i] = s.db.project(cars[i], fields)
+	}
+	return cars, nil
+}
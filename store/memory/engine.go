@@ -0,0 +1,189 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"golangSecond/apperrors"
+	"golangSecond/models"
+
+	"github.com/google/uuid"
+)
+
+// EngineStore is a thread-safe implementation of store.EngineStoreInterface.
+type EngineStore struct {
+	db *DB
+}
+
+func NewEngineStore(db *DB) *EngineStore {
+	return &EngineStore{
+		db: db,
+	}
+}
+
+func (e *EngineStore) EngineById(ctx context.Context, id string, fields models.FieldSet) (models.Engine, error) {
+	engineID, err := parseID("engine", id)
+	if err != nil {
+		return models.Engine{}, err
+	}
+	defer e.db.rlock(ctx)()
+
+	engine, ok := e.db.engines[engineID]
+	if !ok {
+		return models.Engine{}, apperrors.NotFound("engine %s not found", id)
+	}
+	return projectEngine(engine, fields.Fields), nil
+}
+
+func (e *EngineStore) EngineCreate(ctx context.Context, engineReq *models.EngineRequest) (models.Engine, error) {
+	defer e.db.lock(ctx)()
+
+	engine := models.Engine{
+		EngineID:      uuid.New(),
+		Displacement:  engineReq.Displacement,
+		NoOfCylinders: engineReq.NoOfCylinders,
+		CarRange:      engineReq.CarRange,
+		UpdatedAt:     time.Now(),
+		Version:       1,
+	}
+	e.db.putEngine(engine)
+	return engine, nil
+}
+
+func (e *EngineStore) EngineUpdate(ctx context.Context, id string, engineReq *models.EngineRequest, ifVersion int64) (models.Engine, error) {
+	engineID, err := parseID("engine", id)
+	if err != nil {
+		return models.Engine{}, err
+	}
+	defer e.db.lock(ctx)()
+
+	current, ok := e.db.engines[engineID]
+	if !ok {
+		return models.Engine{}, apperrors.NotFound("engine %s not found", id)
+	}
+	if err := checkVersion("engine", id, current.Version, ifVersion); err != nil {
+		return models.Engine{}, err
+	}
+	engine := models.Engine{
+		EngineID:      engineID,
+		Displacement:  engineReq.Displacement,
+		NoOfCylinders: engineReq.NoOfCylinders,
+		CarRange:      engineReq.CarRange,
+		UpdatedAt:     time.Now(),
+		Version:       current.Version + 1,
+	}
+	e.db.putEngine(engine)
+	return engine, nil
+}
+
+func (e *EngineStore) EnginePatch(ctx context.Context, id string, patch models.EnginePatch, ifVersion int64) (models.Engine, error) {
+	engineID, err := parseID("engine", id)
+	if err != nil {
+		return models.Engine{}, err
+	}
+	defer e.db.lock(ctx)()
+
+	engine, ok := e.db.engines[engineID]
+	if !ok {
+		return models.Engine{}, apperrors.NotFound("engine %s not found", id)
+	}
+	if err := checkVersion("engine", id, engine.Version, ifVersion); err != nil {
+		return models.Engine{}, err
+	}
+	engine = patch.Apply(engine)
+	engine.UpdatedAt = time.Now()
+	engine.Version++
+	e.db.putEngine(engine)
+	return engine, nil
+}
+
+func (e *EngineStore) EngineDelete(ctx context.Context, id string, opts models.EngineDeleteOptions, ifVersion int64) (models.Engine, []models.Car, error) {
+	engineID, err := parseID("engine", id)
+	if err != nil {
+		return models.Engine{}, nil, err
+	}
+	defer e.db.lock(ctx)()
+
+	engine, ok := e.db.engines[engineID]
+	if !ok {
+		return models.Engine{}, nil, apperrors.NotFound("engine %s not found", id)
+	}
+	if err := checkVersion("engine", id, engine.Version, ifVersion); err != nil {
+		return models.Engine{}, nil, err
+	}
+	// Cars in the trash go wherever the engine's live cars go, except that
+	// they never block the delete.
+	dependents := e.db.sortedCars(func(car models.Car) bool {
+		return car.Engine.EngineID == engineID
+	})
+	switch opts.Strategy {
+	case models.EngineDeleteDetach:
+		replacementID, err := parseID("engine", opts.ReplacementID)
+		if err != nil {
+			return models.Engine{}, nil, err
+		}
+		if replacementID == engineID {
+			return models.Engine{}, nil, apperrors.Validation("replacement engine %s is the engine being deleted", opts.ReplacementID)
+		}
+		replacement, ok := e.db.engines[replacementID]
+		if !ok {
+			return models.Engine{}, nil, apperrors.Validation("replacement engine %s does not exist", opts.ReplacementID)
+		}
+		now := time.Now()
+		for i, car := range dependents {
+			car.Engine = models.Engine{EngineID: replacement.EngineID}
+			car.UpdatedAt = now
+			car.Version++
+			e.db.putCar(car)
+			dependents[i] = car
+		}
+	default:
+		// cars.engine_id is a foreign key in Postgres; refuse the delete the same way.
+		var carIDs []uuid.UUID
+		for _, car := range dependents {
+			if car.DeletedAt == nil {
+				carIDs = append(carIDs, car.ID)
+			}
+		}
+		if len(carIDs) > 0 && opts.Strategy != models.EngineDeleteCascade {
+			return models.Engine{}, nil, apperrors.Wrap(apperrors.KindConflict, &models.EngineInUseError{EngineID: engineID, CarIDs: carIDs})
+		}
+		for _, car := range dependents {
+			e.db.removeCar(car)
+		}
+	}
+	e.db.removeEngine(engine)
+	return engine, dependents, nil
+}
+
+func (e *EngineStore) ListEngines(ctx context.Context, query models.EngineListQuery) ([]models.Engine, error) {
+	defer e.db.rlock(ctx)()
+
+	var engines []models.Engine
+	for _, engine := range e.db.engines {
+		if !query.Filter.Matches(engine) {
+			continue
+		}
+		if query.After != nil && models.CompareEngines(engine, *query.After, query.Sort) <= 0 {
+			continue
+		}
+		if query.Before != nil && models.CompareEngines(engine, *query.Before, query.Sort) >= 0 {
+			continue
+		}
+		engines = append(engines, engine)
+	}
+	sort.Slice(engines, func(i, j int) bool {
+		return models.CompareEngines(engines[i], engines[j], query.Sort) < 0
+	})
+	// Walking backwards the page is the one just before the boundary.
+	if query.Before != nil && len(engines) > query.Limit {
+		engines = engines[len(engines)-query.Limit:]
+	} else if len(engines) > query.Limit {
+		engines = engines[:query.Limit]
+	}
+	for i := range engines {
+		engines[i] = projectEngine(engines[i], query.Fields.Fields)
+	}
+	return engines, nil
+}
@@ -0,0 +1,91 @@
+package memory
+
+import (
+	"context"
+	"maps"
+	"time"
+
+	"golangSecond/apperrors"
+	"golangSecond/models"
+)
+
+// IdempotencyStore is a thread-safe implementation of
+// store.IdempotencyStoreInterface.
+type IdempotencyStore struct {
+	db *DB
+}
+
+func NewIdempotencyStore(db *DB) *IdempotencyStore {
+	return &IdempotencyStore{
+		db: db,
+	}
+}
+
+func (s *IdempotencyStore) ReserveIdempotencyKey(ctx context.Context, record models.IdempotencyRecord) (models.IdempotencyRecord, bool, error) {
+	defer s.db.lock(ctx)()
+
+	id := idempotencyKey{record.Actor, record.Key}
+	if existing, ok := s.db.idempotency[id]; ok && existing.ExpiresAt.After(time.Now()) {
+		return copyRecord(existing), false, nil
+	}
+	record.Response = nil
+	s.db.idempotency[id] = record
+	return record, true, nil
+}
+
+func (s *IdempotencyStore) ExtendIdempotencyKey(ctx context.Context, actor, key string, expiresAt time.Time) error {
+	defer s.db.lock(ctx)()
+
+	id := idempotencyKey{actor, key}
+	if record, ok := s.db.idempotency[id]; ok && record.Response == nil {
+		record.ExpiresAt = expiresAt
+		s.db.idempotency[id] = record
+	}
+	return nil
+}
+
+func (s *IdempotencyStore) CompleteIdempotencyKey(ctx context.Context, actor, key string, response models.StoredResponse, expiresAt time.Time) error {
+	defer s.db.lock(ctx)()
+
+	id := idempotencyKey{actor, key}
+	record, ok := s.db.idempotency[id]
+	if !ok {
+		return apperrors.NotFound("idempotency key %q not found", key)
+	}
+	record.Response = &response
+	record.ExpiresAt = expiresAt
+	s.db.idempotency[id] = copyRecord(record)
+	return nil
+}
+
+func (s *IdempotencyStore) ReleaseIdempotencyKey(ctx context.Context, actor, key string) error {
+	defer s.db.lock(ctx)()
+
+	delete(s.db.idempotency, idempotencyKey{actor, key})
+	return nil
+}
+
+func (s *IdempotencyStore) PurgeIdempotencyKeys(ctx context.Context, before time.Time) (int64, error) {
+	defer s.db.lock(ctx)()
+
+	var purged int64
+	for id, record := range s.db.idempotency {
+		if !record.ExpiresAt.After(before) {
+			delete(s.db.idempotency, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// copyRecord keeps callers and the store from sharing a response, as they
+// would not with a database.
+func copyRecord(record models.IdempotencyRecord) models.IdempotencyRecord {
+	if record.Response != nil {
+		response := *record.Response
+		response.Header = maps.Clone(response.Header)
+		response.Body = append([]byte(nil), response.Body...)
+		record.Response = &response
+	}
+	return record
+}
@@ -0,0 +1,230 @@
+package memory
+
+import (
+	"context"
+	"maps"
+	"sort"
+	"sync"
+
+	"golangSecond/apperrors"
+	"golangSecond/models"
+	"golangSecond/store"
+
+	"github.com/google/uuid"
+)
+
+var (
+	_ store.CarStoreInterface         = (*CarStore)(nil)
+	_ store.EngineStoreInterface      = (*EngineStore)(nil)
+	_ store.AuditStoreInterface       = (*AuditStore)(nil)
+	_ store.IdempotencyStoreInterface = (*IdempotencyStore)(nil)
+	_ store.TxManager                 = (*DB)(nil)
+)
+
+// DB is the shared state behind the in-memory stores. All of them must be
+// built from the same DB so that cars can reference engines and audit
+// entries roll back with the changes they record.
+type DB struct {
+	mu      sync.RWMutex
+	cars    map[uuid.UUID]models.Car
+	engines map[uuid.UUID]models.Engine
+	// carVersions and engineVersions are in the order they were recorded.
+	carVersions    []carVersion
+	engineVersions []engineVersion
+	// audit is in Seq order; an entry's Seq is its index plus one.
+	audit []models.AuditEntry
+	// idempotency is left out of snapshots: as in Postgres, its records
+	// are not written as part of the changes they guard.
+	idempotency map[idempotencyKey]models.IdempotencyRecord
+}
+
+// idempotencyKey identifies an idempotency record: keys belong to actors.
+type idempotencyKey struct {
+	actor, key string
+}
+
+func NewDB() *DB {
+	return &DB{
+		cars:        make(map[uuid.UUID]models.Car),
+		engines:     make(map[uuid.UUID]models.Engine),
+		idempotency: make(map[idempotencyKey]models.IdempotencyRecord),
+	}
+}
+
+type txKey struct{}
+
+// WithinTx implements store.TxManager. The unit of work holds the write
+// lock throughout, so it is serialized with every other store call, and a
+// failure restores the data from a snapshot taken when it started. Nested
+// calls take their own snapshot and only undo their own changes.
+func (db *DB) WithinTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if ctx.Value(txKey{}) != db {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		ctx = context.WithValue(ctx, txKey{}, db)
+	}
+	snapshot := db.snapshot()
+	defer func() {
+		if p := recover(); p != nil {
+			db.restore(snapshot)
+			panic(p)
+		}
+	}()
+	if err := fn(ctx); err != nil {
+		db.restore(snapshot)
+		return err
+	}
+	return nil
+}
+
+// snapshot is what WithinTx needs to undo a unit of work. The version and
+// audit slices are only ever appended to, so their lengths are enough.
+type snapshot struct {
+	cars                               map[uuid.UUID]models.Car
+	engines                            map[uuid.UUID]models.Engine
+	carVersions, engineVersions, audit int
+}
+
+func (db *DB) snapshot() snapshot {
+	return snapshot{
+		cars:           maps.Clone(db.cars),
+		engines:        maps.Clone(db.engines),
+		carVersions:    len(db.carVersions),
+		engineVersions: len(db.engineVersions),
+		audit:          len(db.audit),
+	}
+}
+
+func (db *DB) restore(s snapshot) {
+	db.cars, db.engines = s.cars, s.engines
+	db.carVersions = db.carVersions[:s.carVersions]
+	db.engineVersions = db.engineVersions[:s.engineVersions]
+	db.audit = db.audit[:s.audit]
+}
+
+// lock takes the write lock unless ctx belongs to a WithinTx call, which
+// already holds it, and returns the matching unlock.
+func (db *DB) lock(ctx context.Context) func() {
+	if ctx.Value(txKey{}) == db {
+		return func() {}
+	}
+	db.mu.Lock()
+	return db.mu.Unlock
+}
+
+// rlock is the read counterpart of lock.
+func (db *DB) rlock(ctx context.Context) func() {
+	if ctx.Value(txKey{}) == db {
+		return func() {}
+	}
+	db.mu.RLock()
+	return db.mu.RUnlock
+}
+
+// sortedCars returns the cars matching keep ordered by creation time, which
+// keeps results stable between calls. Callers must hold the lock.
+func (db *DB) sortedCars(keep func(models.Car) bool) []models.Car {
+	var cars []models.Car
+	for _, car := range db.cars {
+		if keep(car) {
+			cars = append(cars, car)
+		}
+	}
+	order := []models.SortField{{Field: "created_at"}}
+	sort.Slice(cars, func(i, j int) bool {
+		return models.CompareCars(cars[i], cars[j], order) < 0
+	})
+	return cars
+}
+
+// liveCar returns the car with id unless it is missing or in the trash.
+// Callers must hold the lock.
+func (db *DB) liveCar(id uuid.UUID) (models.Car, bool) {
+	car, ok := db.cars[id]
+	if !ok || car.DeletedAt != nil {
+		return models.Car{}, false
+	}
+	return car, true
+}
+
+// withEngine mirrors the left join on engines done by the SQL store.
+// Callers must hold the lock.
+func (db *DB) withEngine(car models.Car) models.Car {
+	if engine, ok := db.engines[car.Engine.EngineID]; ok {
+		car.Engine = engine
+	}
+	return car
+}
+
+// project mirrors the SQL stores, which read only the fields of fields
+// along with the ids, updated_at and versions every read selects, and only
+// join a car's engine when fields need more of it than its id. Callers
+// must hold the lock.
+func (db *DB) project(car models.Car, fields models.FieldSet) models.Car {
+	if fields.Fields != nil {
+		projected := models.Car{ID: car.ID, Engine: car.Engine, UpdatedAt: car.UpdatedAt, Version: car.Version}
+		for _, field := range fields.Fields {
+			switch field {
+			case "name":
+				projected.Name = car.Name
+			case "year":
+				projected.Year = car.Year
+			case "brand":
+				projected.Brand = car.Brand
+			case "fuel_type":
+				projected.FuelType = car.FuelType
+			case "price":
+				projected.Price = car.Price
+			case "created_at":
+				projected.CreatedAt = car.CreatedAt
+			case "deleted_at":
+				projected.DeletedAt = car.DeletedAt
+			}
+		}
+		car = projected
+	}
+	if !fields.JoinsEngine() {
+		car.Engine = models.Engine{EngineID: car.Engine.EngineID}
+		return car
+	}
+	car = db.withEngine(car)
+	car.Engine = projectEngine(car.Engine, fields.EngineFields)
+	return car
+}
+
+// projectEngine keeps the fields of engine among fields, nil for all of
+// them, along with its id, updated_at and version.
+func projectEngine(engine models.Engine, fields []string) models.Engine {
+	if fields == nil {
+		return engine
+	}
+	projected := models.Engine{EngineID: engine.EngineID, UpdatedAt: engine.UpdatedAt, Version: engine.Version}
+	for _, field := range fields {
+		switch field {
+		case "displacement":
+			projected.Displacement = engine.Displacement
+		case "noOfCylinders":
+			projected.NoOfCylinders = engine.NoOfCylinders
+		case "carRange":
+			projected.CarRange = engine.CarRange
+		}
+	}
+	return projected
+}
+
+func parseID(kind, id string) (uuid.UUID, error) {
+	parsed, err := uuid.Parse(id)
+	if err != nil {
+		return uuid.Nil, apperrors.Validation("invalid %s id %q", kind, id)
+	}
+	return parsed, nil
+}
+
+// checkVersion is the in-memory counterpart of the version condition the
+// Postgres stores add to their writes.
+func checkVersion(kind, id string, version, ifVersion int64) error {
+	if ifVersion != 0 && version != ifVersion {
+		return apperrors.PreconditionFailed("%s %s is at version %d, not %d", kind, id, version, ifVersion)
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package memory
+
+import (
+	"testing"
+
+	"golangSecond/store/storetest"
+)
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) storetest.Stores {
+		db := NewDB()
+		return storetest.Stores{
+			Cars:        NewCarStore(db),
+			Engines:     NewEngineStore(db),
+			Audit:       NewAuditStore(db),
+			Idempotency: NewIdempotencyStore(db),
+			Tx:          db,
+		}
+	})
+}
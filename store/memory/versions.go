@@ -0,0 +1,113 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"golangSecond/apperrors"
+	"golangSecond/models"
+
+	"github.com/google/uuid"
+)
+
+// carVersion is a state a car has been in, the in-memory counterpart of a
+// car_versions row. Only the car's engine id is set.
+type carVersion struct {
+	car       models.Car
+	validFrom time.Time
+}
+
+// engineVersion is the engine counterpart of carVersion.
+type engineVersion struct {
+	engine    models.Engine
+	deleted   bool
+	validFrom time.Time
+}
+
+// putCar stores car and records the version it is at. Every write to
+// db.cars goes through putCar or removeCar, as every write to the cars
+// table fires the trigger keeping car_versions. Callers must hold the lock.
+func (db *DB) putCar(car models.Car) {
+	car.Engine = models.Engine{EngineID: car.Engine.EngineID}
+	db.cars[car.ID] = car
+	db.carVersions = append(db.carVersions, carVersion{car: car, validFrom: time.Now()})
+}
+
+// removeCar deletes car for good, recording a last version with DeletedAt
+// set. Callers must hold the lock.
+func (db *DB) removeCar(car models.Car) {
+	delete(db.cars, car.ID)
+	now := time.Now()
+	if car.DeletedAt == nil {
+		car.DeletedAt = &now
+	}
+	car.Version++
+	db.carVersions = append(db.carVersions, carVersion{car: car, validFrom: now})
+}
+
+// putEngine is the engine counterpart of putCar.
+func (db *DB) putEngine(engine models.Engine) {
+	db.engines[engine.EngineID] = engine
+	db.engineVersions = append(db.engineVersions, engineVersion{engine: engine, validFrom: time.Now()})
+}
+
+// removeEngine is the engine counterpart of removeCar.
+func (db *DB) removeEngine(engine models.Engine) {
+	delete(db.engines, engine.EngineID)
+	engine.Version++
+	db.engineVersions = append(db.engineVersions, engineVersion{engine: engine, deleted: true, validFrom: time.Now()})
+}
+
+// engineAsOf returns the version of an engine current at the given moment.
+// Callers must hold the lock.
+func (db *DB) engineAsOf(id uuid.UUID, at time.Time) (models.Engine, bool) {
+	for i := len(db.engineVersions) - 1; i >= 0; i-- {
+		if v := db.engineVersions[i]; v.engine.EngineID == id && !v.validFrom.After(at) {
+			return v.engine, !v.deleted
+		}
+	}
+	return models.Engine{}, false
+}
+
+func (s *CarStore) GetCarAsOf(ctx context.Context, id string, at time.Time) (models.Car, error) {
+	carID, err := parseID("car", id)
+	if err != nil {
+		return models.Car{}, err
+	}
+	defer s.db.rlock(ctx)()
+
+	for i := len(s.db.carVersions) - 1; i >= 0; i-- {
+		v := s.db.carVersions[i]
+		if v.car.ID != carID || v.validFrom.After(at) {
+			continue
+		}
+		if v.car.DeletedAt != nil {
+			break
+		}
+		car := v.car
+		if engine, ok := s.db.engineAsOf(car.Engine.EngineID, at); ok {
+			car.Engine = engine
+		}
+		return car, nil
+	}
+	return models.Car{}, apperrors.NotFound("car %s did not exist at %s", id, at.Format(time.RFC3339))
+}
+
+func (s *CarStore) GetCarVersion(ctx context.Context, id string, version int64) (models.Car, error) {
+	carID, err := parseID("car", id)
+	if err != nil {
+		return models.Car{}, err
+	}
+	defer s.db.rlock(ctx)()
+
+	for _, v := range s.db.carVersions {
+		if v.car.ID == carID && v.car.Version == version {
+			car := v.car
+			if engine, ok := s.db.engineAsOf(car.Engine.EngineID, v.validFrom); ok {
+				car.Engine = engine
+			}
+			return car, nil
+		}
+	}
+	return models.Car{}, apperrors.NotFound("car %s has no version %d", id, version)
+}
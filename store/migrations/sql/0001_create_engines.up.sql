@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS engines (
+    id              UUID PRIMARY KEY,
+    displacement    BIGINT NOT NULL CHECK (displacement > 0),
+    no_of_cylinders BIGINT NOT NULL CHECK (no_of_cylinders > 0),
+    car_range       BIGINT NOT NULL CHECK (car_range > 0)
+);
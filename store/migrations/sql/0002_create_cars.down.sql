@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_cars_engine_id;
+DROP INDEX IF EXISTS idx_cars_brand;
+DROP TABLE IF EXISTS cars;
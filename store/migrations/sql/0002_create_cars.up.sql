@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS cars (
+    id         UUID PRIMARY KEY,
+    name       TEXT NOT NULL,
+    year       VARCHAR(4) NOT NULL,
+    brand      TEXT NOT NULL,
+    fuel_type  VARCHAR(20) NOT NULL CHECK (fuel_type IN ('Petrol', 'Diesel', 'Electric', 'Hybrid')),
+    engine_id  UUID NOT NULL REFERENCES engines (id),
+    price      NUMERIC(12, 2) NOT NULL CHECK (price > 0),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_cars_brand ON cars (brand);
+CREATE INDEX IF NOT EXISTS idx_cars_engine_id ON cars (engine_id);
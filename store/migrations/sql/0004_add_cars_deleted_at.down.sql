@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_cars_deleted_at;
+ALTER TABLE cars DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,3 @@
+ALTER TABLE cars ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_cars_deleted_at ON cars (deleted_at) WHERE deleted_at IS NOT NULL;
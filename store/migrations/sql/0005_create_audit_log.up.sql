@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS audit_log (
+    seq         BIGSERIAL PRIMARY KEY,
+    entity_type VARCHAR(20) NOT NULL,
+    entity_id   UUID NOT NULL,
+    action      VARCHAR(20) NOT NULL,
+    actor       TEXT NOT NULL,
+    at          TIMESTAMPTZ NOT NULL DEFAULT now(),
+    version     BIGINT NOT NULL,
+    changes     JSONB NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_entity ON audit_log (entity_type, entity_id, seq);
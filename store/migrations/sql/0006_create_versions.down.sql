@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_engine_versions_valid_from;
+DROP INDEX IF EXISTS idx_car_versions_valid_from;
+DROP TRIGGER IF EXISTS cars_record_version ON cars;
+DROP TRIGGER IF EXISTS engines_record_version ON engines;
+DROP FUNCTION IF EXISTS record_car_version();
+DROP FUNCTION IF EXISTS record_engine_version();
+DROP TABLE IF EXISTS car_versions;
+DROP TABLE IF EXISTS engine_versions;
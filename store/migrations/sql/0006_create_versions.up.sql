@@ -0,0 +1,78 @@
+-- engine_versions and car_versions keep every state engines and cars have
+-- been in. Triggers write them so that no statement can change a row
+-- without leaving its previous state behind. A version is current from
+-- valid_from until the next one; a row deleted outright gets a last version
+-- with deleted_at set.
+CREATE TABLE IF NOT EXISTS engine_versions (
+    id              UUID NOT NULL,
+    version         BIGINT NOT NULL,
+    displacement    BIGINT NOT NULL,
+    no_of_cylinders BIGINT NOT NULL,
+    car_range       BIGINT NOT NULL,
+    deleted_at      TIMESTAMPTZ,
+    valid_from      TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (id, version)
+);
+
+CREATE TABLE IF NOT EXISTS car_versions (
+    id         UUID NOT NULL,
+    version    BIGINT NOT NULL,
+    name       TEXT NOT NULL,
+    year       VARCHAR(4) NOT NULL,
+    brand      TEXT NOT NULL,
+    fuel_type  VARCHAR(20) NOT NULL,
+    engine_id  UUID NOT NULL,
+    price      NUMERIC(12, 2) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL,
+    deleted_at TIMESTAMPTZ,
+    valid_from TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (id, version)
+);
+
+CREATE INDEX IF NOT EXISTS idx_car_versions_valid_from ON car_versions (id, valid_from);
+CREATE INDEX IF NOT EXISTS idx_engine_versions_valid_from ON engine_versions (id, valid_from);
+
+CREATE OR REPLACE FUNCTION record_engine_version() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        INSERT INTO engine_versions (id, version, displacement, no_of_cylinders, car_range, deleted_at, valid_from)
+        VALUES (OLD.id, OLD.version + 1, OLD.displacement, OLD.no_of_cylinders, OLD.car_range, now(), now());
+        RETURN OLD;
+    END IF;
+    INSERT INTO engine_versions (id, version, displacement, no_of_cylinders, car_range, deleted_at, valid_from)
+    VALUES (NEW.id, NEW.version, NEW.displacement, NEW.no_of_cylinders, NEW.car_range, NULL, now());
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION record_car_version() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        INSERT INTO car_versions (id, version, name, year, brand, fuel_type, engine_id, price, created_at, updated_at, deleted_at, valid_from)
+        VALUES (OLD.id, OLD.version + 1, OLD.name, OLD.year, OLD.brand, OLD.fuel_type, OLD.engine_id, OLD.price, OLD.created_at, OLD.updated_at, COALESCE(OLD.deleted_at, now()), now());
+        RETURN OLD;
+    END IF;
+    INSERT INTO car_versions (id, version, name, year, brand, fuel_type, engine_id, price, created_at, updated_at, deleted_at, valid_from)
+    VALUES (NEW.id, NEW.version, NEW.name, NEW.year, NEW.brand, NEW.fuel_type, NEW.engine_id, NEW.price, NEW.created_at, NEW.updated_at, NEW.deleted_at, now());
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS engines_record_version ON engines;
+CREATE TRIGGER engines_record_version AFTER INSERT OR UPDATE OR DELETE ON engines
+    FOR EACH ROW EXECUTE FUNCTION record_engine_version();
+
+DROP TRIGGER IF EXISTS cars_record_version ON cars;
+CREATE TRIGGER cars_record_version AFTER INSERT OR UPDATE OR DELETE ON cars
+    FOR EACH ROW EXECUTE FUNCTION record_car_version();
+
+-- Existing rows start their history here. Engines carry no timestamps, so
+-- their current state is taken to have always held.
+INSERT INTO engine_versions (id, version, displacement, no_of_cylinders, car_range, deleted_at, valid_from)
+SELECT id, version, displacement, no_of_cylinders, car_range, NULL, '-infinity' FROM engines
+ON CONFLICT DO NOTHING;
+
+INSERT INTO car_versions (id, version, name, year, brand, fuel_type, engine_id, price, created_at, updated_at, deleted_at, valid_from)
+SELECT id, version, name, year, brand, fuel_type, engine_id, price, created_at, updated_at, deleted_at, GREATEST(updated_at, deleted_at) FROM cars
+ON CONFLICT DO NOTHING;
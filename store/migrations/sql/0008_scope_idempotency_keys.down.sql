@@ -0,0 +1,4 @@
+DELETE FROM idempotency_keys;
+ALTER TABLE idempotency_keys DROP CONSTRAINT idempotency_keys_pkey;
+ALTER TABLE idempotency_keys DROP COLUMN actor;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (key);
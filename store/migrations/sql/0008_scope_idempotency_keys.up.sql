@@ -0,0 +1,6 @@
+-- Keys belong to the actor that sent them. Records only live for hours, so
+-- the ones made before keys were scoped are dropped rather than assigned.
+DELETE FROM idempotency_keys;
+ALTER TABLE idempotency_keys ADD COLUMN actor TEXT NOT NULL;
+ALTER TABLE idempotency_keys DROP CONSTRAINT idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (actor, key);
@@ -0,0 +1,15 @@
+CREATE OR REPLACE FUNCTION record_engine_version() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        INSERT INTO engine_versions (id, version, displacement, no_of_cylinders, car_range, deleted_at, valid_from)
+        VALUES (OLD.id, OLD.version + 1, OLD.displacement, OLD.no_of_cylinders, OLD.car_range, now(), now());
+        RETURN OLD;
+    END IF;
+    INSERT INTO engine_versions (id, version, displacement, no_of_cylinders, car_range, deleted_at, valid_from)
+    VALUES (NEW.id, NEW.version, NEW.displacement, NEW.no_of_cylinders, NEW.car_range, NULL, now());
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+ALTER TABLE engine_versions DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE engines DROP COLUMN IF EXISTS updated_at;
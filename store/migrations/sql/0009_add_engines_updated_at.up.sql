@@ -0,0 +1,21 @@
+-- Engines record when they last changed, so that responses embedding them
+-- can tell clients when they were last modified. Existing engines and the
+-- versions recorded before history began start from now.
+ALTER TABLE engines ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+ALTER TABLE engine_versions ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ;
+UPDATE engine_versions SET updated_at = CASE WHEN valid_from = '-infinity' THEN now() ELSE valid_from END;
+ALTER TABLE engine_versions ALTER COLUMN updated_at SET NOT NULL;
+
+CREATE OR REPLACE FUNCTION record_engine_version() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        INSERT INTO engine_versions (id, version, displacement, no_of_cylinders, car_range, updated_at, deleted_at, valid_from)
+        VALUES (OLD.id, OLD.version + 1, OLD.displacement, OLD.no_of_cylinders, OLD.car_range, OLD.updated_at, now(), now());
+        RETURN OLD;
+    END IF;
+    INSERT INTO engine_versions (id, version, displacement, no_of_cylinders, car_range, updated_at, deleted_at, valid_from)
+    VALUES (NEW.id, NEW.version, NEW.displacement, NEW.no_of_cylinders, NEW.car_range, NEW.updated_at, NULL, now());
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,112 @@
+// Package sqlbuilder assembles WHERE clauses from trusted SQL fragments and
+// untrusted values. Values are only ever passed as numbered placeholders.
+package sqlbuilder
+
+import (
+	"strconv"
+	"strings"
+)
+
+type Where struct {
+	clauses []string
+	args    []any
+}
+
+// Add appends a condition, replacing each ? in clause with a placeholder
+// bound to the matching arg. clause must be a constant written by us.
+func (w *Where) Add(clause string, args ...any) {
+	var b strings.Builder
+	next := 0
+	for _, r := range clause {
+		if r == '?' && next < len(args) {
+			b.WriteString(w.Arg(args[next]))
+			next++
+			continue
+		}
+		b.WriteRune(r)
+	}
+	w.clauses = append(w.clauses, b.String())
+}
+
+// In appends "column IN (...)" for the given values. It is a no-op for an
+// empty slice.
+func In[T any](w *Where, column string, values []T) {
+	if len(values) == 0 {
+		return
+	}
+	placeholders := make([]string, len(values))
+	for i, v := range values {
+		placeholders[i] = w.Arg(v)
+	}
+	w.clauses = append(w.clauses, column+" IN ("+strings.Join(placeholders, ", ")+")")
+}
+
+// Arg binds v and returns its placeholder, for clauses built by hand.
+func (w *Where) Arg(v any) string {
+	w.args = append(w.args, v)
+	return "$" + strconv.Itoa(len(w.args))
+}
+
+// AddRaw appends an already built condition whose values were bound with Arg.
+func (w *Where) AddRaw(clause string) {
+	w.clauses = append(w.clauses, clause)
+}
+
+// SQL returns the conditions joined by AND, prefixed with WHERE, or "".
+func (w *Where) SQL() string {
+	if len(w.clauses) == 0 {
+		return ""
+	}
+	return "where " + strings.Join(w.clauses, " AND ")
+}
+
+func (w *Where) Args() []any {
+	return w.args
+}
+
+// EscapeLike escapes the LIKE wildcards in s so it matches literally.
+func EscapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// SortKey is one column of a keyset ordering. Value is the boundary row's
+// value for the column and is ignored when there is no boundary.
+type SortKey struct {
+	Column string
+	Desc   bool
+	Value  any
+}
+
+// Keyset orders by keys and, when boundary is set, restricts the rows to
+// those strictly after the boundary, or strictly before it when backward.
+// Backward reads run in reverse order so that LIMIT keeps the rows closest
+// to the boundary; callers reverse the result. It returns the ORDER BY list.
+func (w *Where) Keyset(keys []SortKey, boundary, backward bool) string {
+	var keyset, orderBy []string
+	for i, key := range keys {
+		desc := key.Desc != backward
+		if desc {
+			orderBy = append(orderBy, key.Column+" DESC")
+		} else {
+			orderBy = append(orderBy, key.Column+" ASC")
+		}
+		if !boundary {
+			continue
+		}
+		// (k1 > v1) OR (k1 = v1 AND k2 > v2) OR ...
+		var terms []string
+		for j := 0; j < i; j++ {
+			terms = append(terms, keys[j].Column+" = "+w.Arg(keys[j].Value))
+		}
+		op := " > "
+		if desc {
+			op = " < "
+		}
+		terms = append(terms, key.Column+op+w.Arg(key.Value))
+		keyset = append(keyset, "("+strings.Join(terms, " AND ")+")")
+	}
+	if len(keyset) > 0 {
+		w.AddRaw("(" + strings.Join(keyset, " OR ") + ")")
+	}
+	return strings.Join(orderBy, ", ")
+}
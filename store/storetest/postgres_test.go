@@ -0,0 +1,99 @@
+package storetest_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+
+	"golangSecond/apperrors"
+	"golangSecond/driver"
+	"golangSecond/models"
+	auditStore "golangSecond/store/audit"
+	carStore "golangSecond/store/car"
+	engineStore "golangSecond/store/engine"
+	idempotencyStore "golangSecond/store/idempotency"
+	"golangSecond/store/migrations"
+	"golangSecond/store/storetest"
+
+	_ "github.com/lib/pq"
+)
+
+// TestPostgresConformance runs the suite against a real database. It is
+// skipped unless TEST_DATABASE_URL points at a disposable Postgres instance.
+func TestPostgresConformance(t *testing.T) {
+	db := openTestDB(t)
+	storetest.Run(t, func(t *testing.T) storetest.Stores {
+		truncate(t, db)
+		return storetest.Stores{
+			Cars:        carStore.New(db),
+			Engines:     engineStore.New(db),
+			Audit:       auditStore.New(db),
+			Idempotency: idempotencyStore.New(db),
+			Tx:          driver.NewTxManager(db),
+		}
+	})
+}
+
+// TestPostgresTxRollback checks that a car insert rejected by the database
+// also undoes an engine created earlier in the same unit of work.
+func TestPostgresTxRollback(t *testing.T) {
+	db := openTestDB(t)
+	truncate(t, db)
+	ctx := context.Background()
+	err := driver.NewTxManager(db).WithinTx(ctx, func(ctx context.Context) error {
+		engine, err := engineStore.New(db).EngineCreate(ctx, &models.EngineRequest{Displacement: 1199, NoOfCylinders: 3, CarRange: 500})
+		if err != nil {
+			return err
+		}
+		req := models.CarRequest{
+			Name:     "Nexon",
+			Year:     "2021",
+			Brand:    "Tata",
+			FuelType: "Petrol",
+			Engine:   engine,
+			Price:    -1,
+		}
+		_, err = carStore.New(db).CreateCar(ctx, &req)
+		return err
+	})
+	if got := apperrors.KindOf(err); got != apperrors.KindValidation {
+		t.Fatalf("WithinTx error kind = %s, want %s (%v)", got, apperrors.KindValidation, err)
+	}
+	var engines int
+	if err := db.QueryRowContext(ctx, `SELECT count(*) FROM engines`).Scan(&engines); err != nil {
+		t.Fatal(err)
+	}
+	if engines != 0 {
+		t.Errorf("%d engine(s) left behind by a rolled back unit of work", engines)
+	}
+}
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrator, err := migrations.New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func truncate(t *testing.T, db *sql.DB) {
+	t.Helper()
+	if _, err := db.ExecContext(context.Background(), `TRUNCATE cars, engines, car_versions, engine_versions, audit_log, idempotency_keys CASCADE`); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,1481 @@
+// Package storetest is a conformance suite for store.CarStoreInterface,
+// store.EngineStoreInterface, store.AuditStoreInterface and
+// store.IdempotencyStoreInterface implementations. Every backend should pass
+// it so that services behave the same whichever one they are given.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"golangSecond/apperrors"
+	"golangSecond/filterexpr"
+	"golangSecond/models"
+	"golangSecond/store"
+
+	"github.com/google/uuid"
+)
+
+// Stores is a car store, an engine store, an audit store and an
+// idempotency store that share the same data, and the transaction manager
+// that spans them.
+type Stores struct {
+	Cars        store.CarStoreInterface
+	Engines     store.EngineStoreInterface
+	Audit       store.AuditStoreInterface
+	Idempotency store.IdempotencyStoreInterface
+	Tx          store.TxManager
+}
+
+// Factory returns a fresh, empty pair of stores for each test.
+type Factory func(t *testing.T) Stores
+
+// Run exercises every store behavior the services rely on.
+func Run(t *testing.T, newStores Factory) {
+	t.Run("Engine", func(t *testing.T) { RunEngineStoreTests(t, newStores) })
+	t.Run("Car", func(t *testing.T) { RunCarStoreTests(t, newStores) })
+	t.Run("Tx", func(t *testing.T) { RunTxTests(t, newStores) })
+	t.Run("Audit", func(t *testing.T) { RunAuditStoreTests(t, newStores) })
+	t.Run("Idempotency", func(t *testing.T) { RunIdempotencyStoreTests(t, newStores) })
+}
+
+func RunEngineStoreTests(t *testing.T, newStores Factory) {
+	t.Run("CreateAndGet", func(t *testing.T) {
+		ctx := context.Background()
+		s := newStores(t)
+		created := mustCreateEngine(t, s, 1998, 4, 550)
+		if created.EngineID == uuid.Nil {
+			t.Fatal("EngineCreate returned a nil id")
+		}
+		if created.Version != 1 {
+			t.Errorf("EngineCreate version = %d, want 1", created.Version)
+		}
+		if created.UpdatedAt.IsZero() {
+			t.Error("EngineCreate returned no updated_at")
+		}
+		got, err := s.Engines.EngineById(ctx, created.EngineID.String(), models.AllFields)
+		if err != nil {
+			t.Fatalf("EngineById: %v", err)
+		}
+		if got != created {
+			t.Errorf("EngineById = %+v, want %+v", got, created)
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		ctx := context.Background()
+		s := newStores(t)
+		created := mustCreateEngine(t, s, 1998, 4, 550)
+		updated, err := s.Engines.EngineUpdate(ctx, created.EngineID.String(), &models.EngineRequest{Displacement: 2500, NoOfCylinders: 6, CarRange: 480}, 0)
+		if err != nil {
+			t.Fatalf("EngineUpdate: %v", err)
+		}
+		if updated.UpdatedAt.Before(created.UpdatedAt) {
+			t.Errorf("EngineUpdate updated_at = %v, want no earlier than %v", updated.UpdatedAt, created.UpdatedAt)
+		}
+		want := models.Engine{EngineID: created.EngineID, Displacement: 2500, NoOfCylinders: 6, CarRange: 480, UpdatedAt: updated.UpdatedAt, Version: 2}
+		if updated != want {
+			t.Errorf("EngineUpdate = %+v, want %+v", updated, want)
+		}
+		got, err := s.Engines.EngineById(ctx, created.EngineID.String(), models.AllFields)
+		if err != nil {
+			t.Fatalf("EngineById: %v", err)
+		}
+		if got != want {
+			t.Errorf("EngineById after update = %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		ctx := context.Background()
+		s := newStores(t)
+		created := mustCreateEngine(t, s, 1998, 4, 550)
+		deleted, _, err := s.Engines.EngineDelete(ctx, created.EngineID.String(), models.EngineDeleteOptions{}, 0)
+		if err != nil {
+			t.Fatalf("EngineDelete: %v", err)
+		}
+		if deleted != created {
+			t.Errorf("EngineDelete = %+v, want %+v", deleted, created)
+		}
+		_, err = s.Engines.EngineById(ctx, created.EngineID.String(), models.AllFields)
+		expectKind(t, "EngineById after delete", err, apperrors.KindNotFound)
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		ctx := context.Background()
+		s := newStores(t)
+		missing := uuid.NewString()
+		_, err := s.Engines.EngineById(ctx, missing, models.AllFields)
+		expectKind(t, "EngineById", err, apperrors.KindNotFound)
+		_, err = s.Engines.EngineUpdate(ctx, missing, &models.EngineRequest{Displacement: 1, NoOfCylinders: 1, CarRange: 1}, 0)
+		expectKind(t, "EngineUpdate", err, apperrors.KindNotFound)
+		_, _, err = s.Engines.EngineDelete(ctx, missing, models.EngineDeleteOptions{}, 0)
+		expectKind(t, "EngineDelete", err, apperrors.KindNotFound)
+	})
+
+	t.Run("InvalidID", func(t *testing.T) {
+		ctx := context.Background()
+		s := newStores(t)
+		_, err := s.Engines.EngineById(ctx, "not-a-uuid", models.AllFields)
+		expectKind(t, "EngineById", err, apperrors.KindValidation)
+		_, err = s.Engines.EngineUpdate(ctx, "not-a-uuid", &models.EngineRequest{Displacement: 1, NoOfCylinders: 1, CarRange: 1}, 0)
+		expectKind(t, "EngineUpdate", err, apperrors.KindValidation)
+		_, _, err = s.Engines.EngineDelete(ctx, "not-a-uuid", models.EngineDeleteOptions{}, 0)
+		expectKind(t, "EngineDelete", err, apperrors.KindValidation)
+	})
+
+	t.Run("DeleteReferencedEngine", func(t *testing.T) {
+		ctx := context.Background()
+		s := newStores(t)
+		engine := mustCreateEngine(t, s, 1998, 4, 550)
+		nexon := mustCreateCar(t, s, "Nexon", "Tata", engine)
+		harrier := mustCreateCar(t, s, "Harrier", "Tata", engine)
+		_, _, err := s.Engines.EngineDelete(ctx, engine.EngineID.String(), models.EngineDeleteOptions{}, 0)
+		expectKind(t, "EngineDelete", err, apperrors.KindConflict)
+		var inUse *models.EngineInUseError
+		if !errors.As(err, &inUse) {
+			t.Fatalf("EngineDelete error %v does not list the dependent cars", err)
+		}
+		if inUse.EngineID != engine.EngineID || len(inUse.CarIDs) != 2 || inUse.CarIDs[0] != nexon.ID || inUse.CarIDs[1] != harrier.ID {
+			t.Errorf("EngineDelete in use = %+v, want engine %s used by %s, %s", inUse, engine.EngineID, nexon.ID, harrier.ID)
+		}
+		if _, err := s.Engines.EngineById(ctx, engine.EngineID.String(), models.AllFields); err != nil {
+			t.Errorf("engine should survive a refused delete: %v", err)
+		}
+	})
+
+	t.Run("DeleteEngineOfTrashedCars", func(t *testing.T) {
+		ctx := context.Background()
+		s := newStores(t)
+		engine := mustCreateEngine(t, s, 1998, 4, 550)
+		nexon := mustCreateCar(t, s, "Nexon", "Tata", engine)
+		if _, err := s.Cars.DeleteCar(ctx, nexon.ID.String(), 0); err != nil {
+			t.Fatalf("DeleteCar: %v", err)
+		}
+		_, removed, err := s.Engines.EngineDelete(ctx, engine.EngineID.String(), models.EngineDeleteOptions{}, 0)
+		if err != nil {
+			t.Fatalf("cars in the trash should not block EngineDelete: %v", err)
+		}
+		if len(removed) != 1 || removed[0].ID != nexon.ID || removed[0].DeletedAt == nil {
+			t.Errorf("EngineDelete removed %+v, want the trashed %s", removed, nexon.ID)
+		}
+		trash, err := s.Cars.ListCars(ctx, models.CarListQuery{Sort: []models.SortField{{Field: "created_at"}}, Limit: 10, Deleted: true})
+		if err != nil {
+			t.Fatalf("ListCars deleted: %v", err)
+		}
+		expectOrder(t, "ListCars deleted after EngineDelete", trash)
+	})
+
+	t.Run("DeleteCascade", func(t *testing.T) {
+		ctx := context.Background()
+		s := newStores(t)
+		engine := mustCreateEngine(t, s, 1998, 4, 550)
+		other := mustCreateEngine(t, s, 1200, 3, 400)
+		nexon := mustCreateCar(t, s, "Nexon", "Tata", engine)
+		alto := mustCreateCar(t, s, "Alto", "Maruti", other)
+		harrier := mustCreateCar(t, s, "Harrier", "Tata", engine)
+		if _, err := s.Cars.DeleteCar(ctx, harrier.ID.String(), 0); err != nil {
+			t.Fatalf("DeleteCar: %v", err)
+		}
+		opts := models.EngineDeleteOptions{Strategy: models.EngineDeleteCascade}
+		_, removed, err := s.Engines.EngineDelete(ctx, engine.EngineID.String(), opts, 0)
+		if err != nil {
+			t.Fatalf("EngineDelete cascade: %v", err)
+		}
+		if len(removed) != 2 || removed[0].ID != nexon.ID || removed[0].Name != nexon.Name || removed[0].Version != nexon.Version ||
+			removed[1].ID != harrier.ID || removed[1].DeletedAt == nil {
+			t.Errorf("EngineDelete cascade removed %+v, want %s and the trashed %s", removed, nexon.ID, harrier.ID)
+		}
+		_, err = s.Cars.GetCarById(ctx, nexon.ID.String(), models.AllFields)
+		expectKind(t, "GetCarById of a cascaded car", err, apperrors.KindNotFound)
+		// A cascade removes cars for good: none of them can be restored.
+		trash, err := s.Cars.ListCars(ctx, models.CarListQuery{Sort: []models.SortField{{Field: "created_at"}}, Limit: 10, Deleted: true})
+		if err != nil || len(trash) != 0 {
+			t.Errorf("trash after a cascade = %+v, %v, want it empty", trash, err)
+		}
+		_, err = s.Cars.RestoreCar(ctx, nexon.ID.String())
+		expectKind(t, "RestoreCar of a cascaded car", err, apperrors.KindNotFound)
+		if _, err := s.Cars.GetCarById(ctx, alto.ID.String(), models.AllFields); err != nil {
+			t.Errorf("cars on other engines should survive a cascade: %v", err)
+		}
+	})
+
+	t.Run("DeleteDetach", func(t *testing.T) {
+		ctx := context.Background()
+		s := newStores(t)
+		engine := mustCreateEngine(t, s, 1998, 4, 550)
+		replacement := mustCreateEngine(t, s, 2200, 4, 600)
+		nexon := mustCreateCar(t, s, "Nexon", "Tata", engine)
+
+		missing := models.EngineDeleteOptions{Strategy: models.EngineDeleteDetach, ReplacementID: uuid.NewString()}
+		_, _, err := s.Engines.EngineDelete(ctx, engine.EngineID.String(), missing, 0)
+		expectKind(t, "EngineDelete detach to a missing engine", err, apperrors.KindValidation)
+		if got, err := s.Cars.GetCarById(ctx, nexon.ID.String(), models.AllFields); err != nil || got.Engine.EngineID != engine.EngineID {
+			t.Errorf("a failed detach should leave the car alone, got %+v, %v", got.Engine, err)
+		}
+
+		// The same id spelt in upper case is still the engine being deleted.
+		itself := models.EngineDeleteOptions{Strategy: models.EngineDeleteDetach, ReplacementID: strings.ToUpper(engine.EngineID.String())}
+		_, _, err = s.Engines.EngineDelete(ctx, engine.EngineID.String(), itself, 0)
+		expectKind(t, "EngineDelete detach to itself", err, apperrors.KindValidation)
+		if got, err := s.Cars.GetCarById(ctx, nexon.ID.String(), models.AllFields); err != nil || got.Engine.EngineID != engine.EngineID {
+			t.Errorf("a detach to itself should leave the car alone, got %+v, %v", got.Engine, err)
+		}
+		if _, err := s.Engines.EngineById(ctx, engine.EngineID.String(), models.AllFields); err != nil {
+			t.Errorf("a detach to itself should leave the engine alone: %v", err)
+		}
+
+		opts := models.EngineDeleteOptions{Strategy: models.EngineDeleteDetach, ReplacementID: replacement.EngineID.String()}
+		_, moved, err := s.Engines.EngineDelete(ctx, engine.EngineID.String(), opts, 0)
+		if err != nil {
+			t.Fatalf("EngineDelete detach: %v", err)
+		}
+		if len(moved) != 1 || moved[0].ID != nexon.ID || moved[0].Engine.EngineID != replacement.EngineID || moved[0].Version != nexon.Version+1 {
+			t.Errorf("EngineDelete detach moved %+v, want %s on %s at version %d", moved, nexon.ID, replacement.EngineID, nexon.Version+1)
+		}
+		got, err := s.Cars.GetCarById(ctx, nexon.ID.String(), models.AllFields)
+		if err != nil {
+			t.Fatalf("GetCarById of a detached car: %v", err)
+		}
+		if got.Engine != replacement {
+			t.Errorf("detached car engine = %+v, want %+v", got.Engine, replacement)
+		}
+		_, err = s.Engines.EngineById(ctx, engine.EngineID.String(), models.AllFields)
+		expectKind(t, "EngineById after detach", err, apperrors.KindNotFound)
+	})
+
+	t.Run("Patch", func(t *testing.T) {
+		ctx := context.Background()
+		s := newStores(t)
+		created := mustCreateEngine(t, s, 1998, 4, 550)
+		carRange := int64(600)
+		patched, err := s.Engines.EnginePatch(ctx, created.EngineID.String(), models.EnginePatch{CarRange: &carRange}, 0)
+		if err != nil {
+			t.Fatalf("EnginePatch: %v", err)
+		}
+		if patched.UpdatedAt.Before(created.UpdatedAt) {
+			t.Errorf("EnginePatch updated_at = %v, want no earlier than %v", patched.UpdatedAt, created.UpdatedAt)
+		}
+		want := models.Engine{EngineID: created.EngineID, Displacement: 1998, NoOfCylinders: 4, CarRange: 600, UpdatedAt: patched.UpdatedAt, Version: 2}
+		if patched != want {
+			t.Errorf("EnginePatch = %+v, want %+v", patched, want)
+		}
+		if got, err := s.Engines.EngineById(ctx, created.EngineID.String(), models.AllFields); err != nil || got != want {
+			t.Errorf("EngineById after patch = %+v, %v, want %+v", got, err, want)
+		}
+		_, err = s.Engines.EnginePatch(ctx, uuid.NewString(), models.EnginePatch{CarRange: &carRange}, 0)
+		expectKind(t, "EnginePatch of a missing engine", err, apperrors.KindNotFound)
+	})
+
+	t.Run("Versions", func(t *testing.T) {
+		ctx := context.Background()
+		s := newStores(t)
+		created := mustCreateEngine(t, s, 1998, 4, 550)
+		id := created.EngineID.String()
+		req := &models.EngineRequest{Displacement: 2500, NoOfCylinders: 6, CarRange: 480}
+		carRange := int64(600)
+
+		_, err := s.Engines.EngineUpdate(ctx, id, req, created.Version+1)
+		expectKind(t, "EngineUpdate at a stale version", err, apperrors.KindPreconditionFailed)
+		updated, err := s.Engines.EngineUpdate(ctx, id, req, created.Version)
+		if err != nil {
+			t.Fatalf("EngineUpdate at the current version: %v", err)
+		}
+		_, err = s.Engines.EnginePatch(ctx, id, models.EnginePatch{CarRange: &carRange}, created.Version)
+		expectKind(t, "EnginePatch at a stale version", err, apperrors.KindPreconditionFailed)
+		patched, err := s.Engines.EnginePatch(ctx, id, models.EnginePatch{CarRange: &carRange}, updated.Version)
+		if err != nil {
+			t.Fatalf("EnginePatch at the current version: %v", err)
+		}
+		if patched.Version != 3 {
+			t.Errorf("EnginePatch version = %d, want 3", patched.Version)
+		}
+		_, _, err = s.Engines.EngineDelete(ctx, id, models.EngineDeleteOptions{}, updated.Version)
+		expectKind(t, "EngineDelete at a stale version", err, apperrors.KindPreconditionFailed)
+		if _, _, err := s.Engines.EngineDelete(ctx, id, models.EngineDeleteOptions{}, patched.Version); err != nil {
+			t.Fatalf("EngineDelete at the current version: %v", err)
+		}
+		_, err = s.Engines.EngineUpdate(ctx, id, req, patched.Version)
+		expectKind(t, "EngineUpdate of a deleted engine", err, apperrors.KindNotFound)
+	})
+
+	t.Run("ListEngines", func(t *testing.T) { runListEnginesTests(t, newStores) })
+}
+
+func runListEnginesTests(t *testing.T, newStores Factory) {
+	ctx := context.Background()
+	s := newStores(t)
+	engines := []models.Engine{
+		mustCreateEngine(t, s, 1200, 3, 400),
+		mustCreateEngine(t, s, 1500, 4, 500),
+		mustCreateEngine(t, s, 2000, 4, 600),
+		mustCreateEngine(t, s, 3000, 6, 450),
+	}
+	// -noOfCylinders, carRange: 3000, 1500, 2000, 1200.
+	order := []models.SortField{{Field: "noOfCylinders", Desc: true}, {Field: "carRange"}}
+	want := []models.Engine{engines[3], engines[1], engines[2], engines[0]}
+
+	all, err := s.Engines.ListEngines(ctx, models.EngineListQuery{Sort: order, Limit: 10})
+	if err != nil {
+		t.Fatalf("ListEngines: %v", err)
+	}
+	expectEngines(t, "ListEngines", all, want...)
+
+	page, err := s.Engines.ListEngines(ctx, models.EngineListQuery{Sort: order, Limit: 2, After: &all[1]})
+	if err != nil {
+		t.Fatalf("ListEngines after: %v", err)
+	}
+	expectEngines(t, "ListEngines after", page, want[2], want[3])
+
+	page, err = s.Engines.ListEngines(ctx, models.EngineListQuery{Sort: order, Limit: 2, Before: &all[3]})
+	if err != nil {
+		t.Fatalf("ListEngines before: %v", err)
+	}
+	expectEngines(t, "ListEngines before", page, want[1], want[2])
+
+	minCylinders, maxRange := int64(4), int64(550)
+	filtered, err := s.Engines.ListEngines(ctx, models.EngineListQuery{
+		Filter: models.EngineFilter{CylindersMin: &minCylinders, RangeMax: &maxRange},
+		Sort:   order,
+		Limit:  10,
+	})
+	if err != nil {
+		t.Fatalf("ListEngines filtered: %v", err)
+	}
+	expectEngines(t, "ListEngines filtered", filtered, engines[3], engines[1])
+
+	expr, err := filterexpr.Parse(`displacement < 2500 and not carRange in (400, 600)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filtered, err = s.Engines.ListEngines(ctx, models.EngineListQuery{Filter: models.EngineFilter{Expr: expr}, Sort: order, Limit: 10})
+	if err != nil {
+		t.Fatalf("ListEngines expression: %v", err)
+	}
+	expectEngines(t, "ListEngines expression", filtered, engines[1])
+}
+
+func RunCarStoreTests(t *testing.T, newStores Factory) {
+	t.Run("CreateAndGet", func(t *testing.T) {
+		ctx := context.Background()
+		s := newStores(t)
+		engine := mustCreateEngine(t, s, 1998, 4, 550)
+		created := mustCreateCar(t, s, "Nexon", "Tata", engine)
+		if created.ID == uuid.Nil {
+			t.Fatal("CreateCar returned a nil id")
+		}
+		if created.Engine.EngineID != engine.EngineID {
+			t.Errorf("CreateCar engine id = %s, want %s", created.Engine.EngineID, engine.EngineID)
+		}
+		if created.Version != 1 {
+			t.Errorf("CreateCar version = %d, want 1", created.Version)
+		}
+		if created.CreatedAt.IsZero() || !created.CreatedAt.Equal(created.UpdatedAt) {
+			t.Errorf("CreateCar timestamps = %v / %v, want equal and non-zero", created.CreatedAt, created.UpdatedAt)
+		}
+
+		got, err := s.Cars.GetCarById(ctx, created.ID.String(), models.AllFields)
+		if err != nil {
+			t.Fatalf("GetCarById: %v", err)
+		}
+		expectCar(t, "GetCarById", got, created)
+		if got.Engine != engine {
+			t.Errorf("GetCarById engine = %+v, want %+v", got.Engine, engine)
+		}
+	})
+
+	t.Run("CreateCars", func(t *testing.T) {
+		ctx := context.Background()
+		s := newStores(t)
+		petrol := mustCreateEngine(t, s, 1199, 3, 500)
+		electric := mustCreateEngine(t, s, 1, 1, 312)
+		reqs := []models.CarRequest{
+			carRequest("Nexon", "Tata", petrol),
+			carRequest("Nexon EV", "Tata", electric),
+			carRequest("Punch", "Tata", petrol),
+		}
+		created, err := s.Cars.CreateCars(ctx, reqs)
+		if err != nil {
+			t.Fatalf("CreateCars: %v", err)
+		}
+		if len(created) != len(reqs) {
+			t.Fatalf("CreateCars returned %d cars, want %d", len(created), len(reqs))
+		}
+		for i, car := range created {
+			if car.Name != reqs[i].Name || car.Engine != reqs[i].Engine || car.Version != 1 {
+				t.Errorf("CreateCars car %d = %+v, want %+v at version 1", i, car, reqs[i])
+			}
+			got, err := s.Cars.GetCarById(ctx, car.ID.String(), models.AllFields)
+			if err != nil {
+				t.Fatalf("GetCarById: %v", err)
+			}
+			expectCar(t, "GetCarById", got, car)
+		}
+
+		reqs = []models.CarRequest{
+			carRequest("Tiago", "Tata", petrol),
+			carRequest("Altroz", "Tata", models.Engine{EngineID: uuid.New()}),
+		}
+		_, err = s.Cars.CreateCars(ctx, reqs)
+		expectKind(t, "CreateCars with a missing engine", err, apperrors.KindValidation)
+		cars, err := s.Cars.GetCarsByEngineID(ctx, petrol.EngineID.String(), models.AllFields)
+		if err != nil {
+			t.Fatalf("GetCarsByEngineID: %v", err)
+		}
+		expectIDs(t, "GetCarsByEngineID after a failed batch", cars, created[0].ID, created[2].ID)
+	})
+
+	t.Run("CreateWithMissingEngine", func(t *testing.T) {
+		ctx := context.Background()
+		s := newStores(t)
+		req := carRequest("Nexon", "Tata", models.Engine{EngineID: uuid.New()})
+		_, err := s.Cars.CreateCar(ctx, &req)
+		expectKind(t, "CreateCar", err, apperrors.KindValidation)
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		ctx := context.Background()
+		s := newStores(t)
+		engine := mustCreateEngine(t, s, 1998, 4, 550)
+		other := mustCreateEngine(t, s, 1200, 3, 700)
+		created := mustCreateCar(t, s, "Nexon", "Tata", engine)
+
+		req := carRequest("Nexon EV", "Tata", other)
+		req.FuelType = "Electric"
+		req.Price = 21000
+		updated, err := s.Cars.UpdateCar(ctx, created.ID.String(), &req, 0)
+		if err != nil {
+			t.Fatalf("UpdateCar: %v", err)
+		}
+		if updated.ID != created.ID || updated.Name != "Nexon EV" || updated.FuelType != "Electric" ||
+			updated.Price != 21000 || updated.Engine.EngineID != other.EngineID {
+			t.Errorf("UpdateCar = %+v, want the requested changes", updated)
+		}
+		if !sameTime(updated.CreatedAt, created.CreatedAt) {
+			t.Errorf("UpdateCar changed created_at from %v to %v", created.CreatedAt, updated.CreatedAt)
+		}
+		if updated.UpdatedAt.Before(created.UpdatedAt) {
+			t.Errorf("UpdateCar updated_at %v is before %v", updated.UpdatedAt, created.UpdatedAt)
+		}
+		if updated.Version != created.Version+1 {
+			t.Errorf("UpdateCar version = %d, want %d", updated.Version, created.Version+1)
+		}
+
+		got, err := s.Cars.GetCarById(ctx, created.ID.String(), models.AllFields)
+		if err != nil {
+			t.Fatalf("GetCarById: %v", err)
+		}
+		if got.Engine != other {
+			t.Errorf("GetCarById engine after update = %+v, want %+v", got.Engine, other)
+		}
+	})
+
+	t.Run("UpdateWithMissingEngine", func(t *testing.T) {
+		ctx := context.Background()
+		s := newStores(t)
+		engine := mustCreateEngine(t, s, 1998, 4, 550)
+		created := mustCreateCar(t, s, "Nexon", "Tata", engine)
+		req := carRequest("Nexon", "Tata", models.Engine{EngineID: uuid.New()})
+		_, err := s.Cars.UpdateCar(ctx, created.ID.String(), &req, 0)
+		expectKind(t, "UpdateCar", err, apperrors.KindValidation)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		ctx := context.Background()
+		s := newStores(t)
+		engine := mustCreateEngine(t, s, 1998, 4, 550)
+		created := mustCreateCar(t, s, "Nexon", "Tata", engine)
+		deleted, err := s.Cars.DeleteCar(ctx, created.ID.String(), 0)
+		if err != nil {
+			t.Fatalf("DeleteCar: %v", err)
+		}
+		want := created
+		want.Version = 2
+		expectCar(t, "DeleteCar", deleted, want)
+		if deleted.DeletedAt == nil {
+			t.Error("DeleteCar did not set deleted_at")
+		}
+		_, err = s.Cars.GetCarById(ctx, created.ID.String(), models.AllFields)
+		expectKind(t, "GetCarById after delete", err, apperrors.KindNotFound)
+		_, err = s.Cars.DeleteCar(ctx, created.ID.String(), 0)
+		expectKind(t, "DeleteCar of a deleted car", err, apperrors.KindNotFound)
+		if _, _, err := s.Engines.EngineDelete(ctx, engine.EngineID.String(), models.EngineDeleteOptions{}, 0); err != nil {
+			t.Errorf("engine should be deletable once its cars are gone: %v", err)
+		}
+		_, err = s.Cars.RestoreCar(ctx, created.ID.String())
+		expectKind(t, "RestoreCar of a car purged with its engine", err, apperrors.KindNotFound)
+	})
+
+	t.Run("Trash", func(t *testing.T) {
+		ctx := context.Background()
+		s := newStores(t)
+		engine := mustCreateEngine(t, s, 1998, 4, 550)
+		nexon := mustCreateCar(t, s, "Nexon", "Tata", engine)
+		harrier := mustCreateCar(t, s, "Harrier", "Tata", engine)
+		if _, err := s.Cars.DeleteCar(ctx, nexon.ID.String(), 0); err != nil {
+			t.Fatalf("DeleteCar: %v", err)
+		}
+
+		order := []models.SortField{{Field: "created_at"}}
+		live, err := s.Cars.ListCars(ctx, models.CarListQuery{Sort: order, Limit: 10})
+		if err != nil {
+			t.Fatalf("ListCars: %v", err)
+		}
+		expectOrder(t, "ListCars", live, harrier.ID)
+		trash, err := s.Cars.ListCars(ctx, models.CarListQuery{Sort: order, Limit: 10, Deleted: true})
+		if err != nil {
+			t.Fatalf("ListCars deleted: %v", err)
+		}
+		expectOrder(t, "ListCars deleted", trash, nexon.ID)
+		if byBrand, err := s.Cars.GetCarByBrand(ctx, "Tata", models.AllFields); err != nil || len(byBrand) != 1 {
+			t.Errorf("GetCarByBrand = %d cars, %v, want only the live one", len(byBrand), err)
+		}
+		if byEngine, err := s.Cars.GetCarsByEngineID(ctx, engine.EngineID.String(), models.AllFields); err != nil || len(byEngine) != 1 {
+			t.Errorf("GetCarsByEngineID = %d cars, %v, want only the live one", len(byEngine), err)
+		}
+		price := 1000.0
+		_, err = s.Cars.PatchCar(ctx, nexon.ID.String(), models.CarPatch{Price: &price}, 0)
+		expectKind(t, "PatchCar of a deleted car", err, apperrors.KindNotFound)
+
+		restored, err := s.Cars.RestoreCar(ctx, nexon.ID.String())
+		if err != nil {
+			t.Fatalf("RestoreCar: %v", err)
+		}
+		if restored.DeletedAt != nil || restored.Version != 3 || restored.Engine != engine {
+			t.Errorf("RestoreCar = %+v, want a live car at version 3 with its engine", restored)
+		}
+		_, err = s.Cars.RestoreCar(ctx, nexon.ID.String())
+		expectKind(t, "RestoreCar of a live car", err, apperrors.KindNotFound)
+		_, err = s.Cars.RestoreCar(ctx, uuid.NewString())
+		expectKind(t, "RestoreCar of a missing car", err, apperrors.KindNotFound)
+	})
+
+	t.Run("Purge", func(t *testing.T) {
+		ctx := context.Background()
+		s := newStores(t)
+		engine := mustCreateEngine(t, s, 1998, 4, 550)
+		old := mustCreateCar(t, s, "Nexon", "Tata", engine)
+		live := mustCreateCar(t, s, "Harrier", "Tata", engine)
+		if _, err := s.Cars.DeleteCar(ctx, old.ID.String(), 0); err != nil {
+			t.Fatalf("DeleteCar: %v", err)
+		}
+		cutoff := time.Now()
+		recent := mustCreateCar(t, s, "Punch", "Tata", engine)
+		if _, err := s.Cars.DeleteCar(ctx, recent.ID.String(), 0); err != nil {
+			t.Fatalf("DeleteCar: %v", err)
+		}
+
+		purged, err := s.Cars.PurgeDeletedCars(ctx, cutoff)
+		if err != nil {
+			t.Fatalf("PurgeDeletedCars: %v", err)
+		}
+		if len(purged) != 1 || purged[0].ID != old.ID || purged[0].Name != old.Name || purged[0].Engine.EngineID != engine.EngineID {
+			t.Errorf("PurgeDeletedCars = %+v, want only %s", purged, old.ID)
+		}
+		_, err = s.Cars.RestoreCar(ctx, old.ID.String())
+		expectKind(t, "RestoreCar of a purged car", err, apperrors.KindNotFound)
+		if _, err := s.Cars.RestoreCar(ctx, recent.ID.String()); err != nil {
+			t.Errorf("cars deleted after the cutoff should survive a purge: %v", err)
+		}
+		if _, err := s.Cars.GetCarById(ctx, live.ID.String(), models.AllFields); err != nil {
+			t.Errorf("live cars should survive a purge: %v", err)
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		ctx := context.Background()
+		s := newStores(t)
+		engine := mustCreateEngine(t, s, 1998, 4, 550)
+		missing := uuid.NewString()
+		_, err := s.Cars.GetCarById(ctx, missing, models.AllFields)
+		expectKind(t, "GetCarById", err, apperrors.KindNotFound)
+		req := carRequest("Nexon", "Tata", engine)
+		_, err = s.Cars.UpdateCar(ctx, missing, &req, 0)
+		expectKind(t, "UpdateCar", err, apperrors.KindNotFound)
+		_, err = s.Cars.DeleteCar(ctx, missing, 0)
+		expectKind(t, "DeleteCar", err, apperrors.KindNotFound)
+	})
+
+	t.Run("InvalidID", func(t *testing.T) {
+		ctx := context.Background()
+		s := newStores(t)
+		_, err := s.Cars.GetCarById(ctx, "not-a-uuid", models.AllFields)
+		expectKind(t, "GetCarById", err, apperrors.KindValidation)
+		_, err = s.Cars.DeleteCar(ctx, "not-a-uuid", 0)
+		expectKind(t, "DeleteCar", err, apperrors.KindValidation)
+	})
+
+	t.Run("GetCarByBrand", func(t *testing.T) {
+		ctx := context.Background()
+		s := newStores(t)
+		engine := mustCreateEngine(t, s, 1998, 4, 550)
+		first := mustCreateCar(t, s, "Nexon", "Tata", engine)
+		second := mustCreateCar(t, s, "Harrier", "Tata", engine)
+		mustCreateCar(t, s, "Creta", "Hyundai", engine)
+
+		withoutEngine, err := s.Cars.GetCarByBrand(ctx, "Tata", models.FieldSet{OmitEngine: true})
+		if err != nil {
+			t.Fatalf("GetCarByBrand without engine: %v", err)
+		}
+		expectIDs(t, "GetCarByBrand without engine", withoutEngine, first.ID, second.ID)
+		for _, car := range withoutEngine {
+			if car.Engine != (models.Engine{EngineID: engine.EngineID}) {
+				t.Errorf("GetCarByBrand without engine returned engine %+v, want only its id", car.Engine)
+			}
+		}
+
+		withEngine, err := s.Cars.GetCarByBrand(ctx, "Tata", models.AllFields)
+		if err != nil {
+			t.Fatalf("GetCarByBrand with engine: %v", err)
+		}
+		expectIDs(t, "GetCarByBrand with engine", withEngine, first.ID, second.ID)
+		for _, car := range withEngine {
+			if car.Engine != engine {
+				t.Errorf("GetCarByBrand with engine returned engine %+v, want %+v", car.Engine, engine)
+			}
+		}
+
+		none, err := s.Cars.GetCarByBrand(ctx, "Ford", models.AllFields)
+		if err != nil {
+			t.Fatalf("GetCarByBrand unknown brand: %v", err)
+		}
+		if len(none) != 0 {
+			t.Errorf("GetCarByBrand unknown brand returned %d cars", len(none))
+		}
+	})
+
+	t.Run("Patch", func(t *testing.T) {
+		ctx := context.Background()
+		s := newStores(t)
+		engine := mustCreateEngine(t, s, 1998, 4, 550)
+		other := mustCreateEngine(t, s, 1200, 3, 400)
+		created := mustCreateCar(t, s, "Nexon", "Tata", engine)
+
+		price := 12500.0
+		patched, err := s.Cars.PatchCar(ctx, created.ID.String(), models.CarPatch{Price: &price, EngineID: &other.EngineID}, 0)
+		if err != nil {
+			t.Fatalf("PatchCar: %v", err)
+		}
+		want := created
+		want.Price = price
+		want.Engine = other
+		want.UpdatedAt = patched.UpdatedAt
+		want.Version = 2
+		expectCar(t, "PatchCar", patched, want)
+		if patched.Engine != other {
+			t.Errorf("PatchCar engine = %+v, want %+v", patched.Engine, other)
+		}
+		if !patched.UpdatedAt.After(created.UpdatedAt) {
+			t.Errorf("PatchCar updated_at %v is not after %v", patched.UpdatedAt, created.UpdatedAt)
+		}
+		got, err := s.Cars.GetCarById(ctx, created.ID.String(), models.AllFields)
+		if err != nil {
+			t.Fatalf("GetCarById after patch: %v", err)
+		}
+		expectCar(t, "GetCarById after patch", got, patched)
+
+		missing := uuid.New()
+		_, err = s.Cars.PatchCar(ctx, created.ID.String(), models.CarPatch{EngineID: &missing}, 0)
+		expectKind(t, "PatchCar to a missing engine", err, apperrors.KindValidation)
+		_, err = s.Cars.PatchCar(ctx, uuid.NewString(), models.CarPatch{Price: &price}, 0)
+		expectKind(t, "PatchCar of a missing car", err, apperrors.KindNotFound)
+	})
+
+	t.Run("GetCarsByEngineID", func(t *testing.T) {
+		ctx := context.Background()
+		s := newStores(t)
+		engine := mustCreateEngine(t, s, 1998, 4, 550)
+		other := mustCreateEngine(t, s, 1200, 3, 400)
+		nexon := mustCreateCar(t, s, "Nexon", "Tata", engine)
+		harrier := mustCreateCar(t, s, "Harrier", "Tata", engine)
+		mustCreateCar(t, s, "Alto", "Maruti", other)
+
+		cars, err := s.Cars.GetCarsByEngineID(ctx, engine.EngineID.String(), models.AllFields)
+		if err != nil {
+			t.Fatalf("GetCarsByEngineID: %v", err)
+		}
+		expectIDs(t, "GetCarsByEngineID", cars, nexon.ID, harrier.ID)
+		for _, car := range cars {
+			if car.Engine != engine {
+				t.Errorf("GetCarsByEngineID engine = %+v, want %+v", car.Engine, engine)
+			}
+		}
+
+		unused := mustCreateEngine(t, s, 1000, 3, 300)
+		cars, err = s.Cars.GetCarsByEngineID(ctx, unused.EngineID.String(), models.AllFields)
+		if err != nil {
+			t.Fatalf("GetCarsByEngineID unused engine: %v", err)
+		}
+		if len(cars) != 0 {
+			t.Errorf("GetCarsByEngineID unused engine returned %d cars", len(cars))
+		}
+
+		_, err = s.Cars.GetCarsByEngineID(ctx, "not-a-uuid", models.AllFields)
+		expectKind(t, "GetCarsByEngineID", err, apperrors.KindValidation)
+	})
+
+	t.Run("GetCarsByIDs", func(t *testing.T) {
+		ctx := context.Background()
+		s := newStores(t)
+		engine := mustCreateEngine(t, s, 1998, 4, 550)
+		nexon := mustCreateCar(t, s, "Nexon", "Tata", engine)
+		harrier := mustCreateCar(t, s, "Harrier", "Tata", engine)
+		trashed := mustCreateCar(t, s, "Alto", "Maruti", engine)
+		mustCreateCar(t, s, "Punch", "Tata", engine)
+		if _, err := s.Cars.DeleteCar(ctx, trashed.ID.String(), 0); err != nil {
+			t.Fatalf("DeleteCar: %v", err)
+		}
+
+		cars, err := s.Cars.GetCarsByIDs(ctx, []uuid.UUID{harrier.ID, uuid.New(), trashed.ID, nexon.ID}, models.AllFields)
+		if err != nil {
+			t.Fatalf("GetCarsByIDs: %v", err)
+		}
+		expectIDs(t, "GetCarsByIDs", cars, nexon.ID, harrier.ID)
+		for _, car := range cars {
+			if car.Engine != engine {
+				t.Errorf("GetCarsByIDs engine = %+v, want %+v", car.Engine, engine)
+			}
+		}
+
+		cars, err = s.Cars.GetCarsByIDs(ctx, nil, models.AllFields)
+		if err != nil {
+			t.Fatalf("GetCarsByIDs no ids: %v", err)
+		}
+		if len(cars) != 0 {
+			t.Errorf("GetCarsByIDs no ids returned %d cars", len(cars))
+		}
+	})
+
+	t.Run("Fields", func(t *testing.T) {
+		ctx := context.Background()
+		s := newStores(t)
+		engine := mustCreateEngine(t, s, 1998, 4, 550)
+		created := mustCreateCar(t, s, "Nexon", "Tata", engine)
+
+		sparse := models.FieldSet{Fields: []string{"id", "name"}, OmitEngine: true}
+		got, err := s.Cars.GetCarById(ctx, created.ID.String(), sparse)
+		if err != nil {
+			t.Fatalf("GetCarById sparse: %v", err)
+		}
+		if got.ID != created.ID || got.Name != "Nexon" || got.Version != created.Version || !sameTime(got.UpdatedAt, created.UpdatedAt) {
+			t.Errorf("GetCarById sparse = %+v, want the name, version and updated_at of %+v", got, created)
+		}
+		if got.Brand != "" || got.Price != 0 || !got.CreatedAt.IsZero() {
+			t.Errorf("GetCarById sparse = %+v, want no brand, price or created_at", got)
+		}
+		if got.Engine != (models.Engine{EngineID: engine.EngineID}) {
+			t.Errorf("GetCarById sparse engine = %+v, want only its id", got.Engine)
+		}
+
+		withEngine := models.FieldSet{Fields: []string{"id", "price"}, EngineFields: []string{"engine_id", "carRange"}}
+		got, err = s.Cars.GetCarById(ctx, created.ID.String(), withEngine)
+		if err != nil {
+			t.Fatalf("GetCarById with engine fields: %v", err)
+		}
+		if got.Price != created.Price || got.Engine.EngineID != engine.EngineID || got.Engine.CarRange != engine.CarRange || got.Engine.Version != engine.Version {
+			t.Errorf("GetCarById with engine fields = %+v, want price %v and engine range %d", got, created.Price, engine.CarRange)
+		}
+		if got.Name != "" || got.Engine.Displacement != 0 || got.Engine.NoOfCylinders != 0 {
+			t.Errorf("GetCarById with engine fields = %+v, want no name, displacement or cylinders", got)
+		}
+
+		// A filter on the engine still applies when the engine is not read.
+		rangeMin := int64(500)
+		cars, err := s.Cars.ListCars(ctx, models.CarListQuery{
+			Filter: models.CarFilter{RangeMin: &rangeMin},
+			Sort:   []models.SortField{{Field: "name"}},
+			Limit:  10,
+			Fields: sparse.With("name"),
+		})
+		if err != nil {
+			t.Fatalf("ListCars sparse: %v", err)
+		}
+		expectOrder(t, "ListCars sparse", cars, created.ID)
+		if cars[0].Name != "Nexon" || cars[0].Engine != (models.Engine{EngineID: engine.EngineID}) {
+			t.Errorf("ListCars sparse = %+v, want Nexon with only its engine id", cars[0])
+		}
+
+		gotEngine, err := s.Engines.EngineById(ctx, engine.EngineID.String(), models.FieldSet{Fields: []string{"engine_id", "displacement"}})
+		if err != nil {
+			t.Fatalf("EngineById sparse: %v", err)
+		}
+		if gotEngine.EngineID != engine.EngineID || gotEngine.Displacement != engine.Displacement || gotEngine.Version != engine.Version {
+			t.Errorf("EngineById sparse = %+v, want the displacement and version of %+v", gotEngine, engine)
+		}
+		if gotEngine.NoOfCylinders != 0 || gotEngine.CarRange != 0 {
+			t.Errorf("EngineById sparse = %+v, want no cylinders or range", gotEngine)
+		}
+		engines, err := s.Engines.ListEngines(ctx, models.EngineListQuery{
+			Sort:   []models.SortField{{Field: "carRange"}},
+			Limit:  10,
+			Fields: models.FieldSet{Fields: []string{"engine_id", "carRange"}},
+		})
+		if err != nil {
+			t.Fatalf("ListEngines sparse: %v", err)
+		}
+		if len(engines) != 1 || engines[0] != (models.Engine{EngineID: engine.EngineID, CarRange: engine.CarRange, UpdatedAt: engine.UpdatedAt, Version: engine.Version}) {
+			t.Errorf("ListEngines sparse = %+v, want only the id, range, updated_at and version of %+v", engines, engine)
+		}
+	})
+
+	t.Run("Versions", func(t *testing.T) {
+		ctx := context.Background()
+		s := newStores(t)
+		engine := mustCreateEngine(t, s, 1998, 4, 550)
+		replacement := mustCreateEngine(t, s, 1200, 3, 400)
+		created := mustCreateCar(t, s, "Nexon", "Tata", engine)
+		id := created.ID.String()
+		req := carRequest("Nexon EV", "Tata", engine)
+		price := 12500.0
+
+		_, err := s.Cars.UpdateCar(ctx, id, &req, created.Version+1)
+		expectKind(t, "UpdateCar at a stale version", err, apperrors.KindPreconditionFailed)
+		updated, err := s.Cars.UpdateCar(ctx, id, &req, created.Version)
+		if err != nil {
+			t.Fatalf("UpdateCar at the current version: %v", err)
+		}
+		_, err = s.Cars.PatchCar(ctx, id, models.CarPatch{Price: &price}, created.Version)
+		expectKind(t, "PatchCar at a stale version", err, apperrors.KindPreconditionFailed)
+		patched, err := s.Cars.PatchCar(ctx, id, models.CarPatch{Price: &price}, updated.Version)
+		if err != nil {
+			t.Fatalf("PatchCar at the current version: %v", err)
+		}
+		if patched.Version != 3 {
+			t.Errorf("PatchCar version = %d, want 3", patched.Version)
+		}
+
+		opts := models.EngineDeleteOptions{Strategy: models.EngineDeleteDetach, ReplacementID: replacement.EngineID.String()}
+		if _, _, err := s.Engines.EngineDelete(ctx, engine.EngineID.String(), opts, 0); err != nil {
+			t.Fatalf("EngineDelete detach: %v", err)
+		}
+		detached, err := s.Cars.GetCarById(ctx, id, models.AllFields)
+		if err != nil {
+			t.Fatalf("GetCarById after detach: %v", err)
+		}
+		if detached.Version != 4 {
+			t.Errorf("detached car version = %d, want 4", detached.Version)
+		}
+
+		_, err = s.Cars.DeleteCar(ctx, id, patched.Version)
+		expectKind(t, "DeleteCar at a stale version", err, apperrors.KindPreconditionFailed)
+		if _, err := s.Cars.DeleteCar(ctx, id, detached.Version); err != nil {
+			t.Fatalf("DeleteCar at the current version: %v", err)
+		}
+		_, err = s.Cars.DeleteCar(ctx, id, detached.Version)
+		expectKind(t, "DeleteCar of a deleted car", err, apperrors.KindNotFound)
+	})
+
+	t.Run("AsOf", func(t *testing.T) {
+		ctx := context.Background()
+		s := newStores(t)
+		beforeCreate := time.Now().Add(-time.Second)
+		engine := mustCreateEngine(t, s, 1199, 3, 500)
+		created := mustCreateCar(t, s, "Nexon", "Tata", engine)
+		id := created.ID.String()
+		original := moment()
+
+		price := 12000.0
+		updated, err := s.Cars.PatchCar(ctx, id, models.CarPatch{Price: &price}, 0)
+		if err != nil {
+			t.Fatalf("PatchCar: %v", err)
+		}
+		upgraded, err := s.Engines.EngineUpdate(ctx, engine.EngineID.String(), &models.EngineRequest{Displacement: 1497, NoOfCylinders: 4, CarRange: 600}, 0)
+		if err != nil {
+			t.Fatalf("EngineUpdate: %v", err)
+		}
+		updated.Engine = upgraded
+		changed := moment()
+
+		if _, err := s.Cars.DeleteCar(ctx, id, 0); err != nil {
+			t.Fatalf("DeleteCar: %v", err)
+		}
+		trashed := moment()
+		if _, err := s.Cars.PurgeDeletedCars(ctx, time.Now().Add(time.Second)); err != nil {
+			t.Fatalf("PurgeDeletedCars: %v", err)
+		}
+
+		_, err = s.Cars.GetCarAsOf(ctx, id, beforeCreate)
+		expectKind(t, "GetCarAsOf before the car was created", err, apperrors.KindNotFound)
+		got, err := s.Cars.GetCarAsOf(ctx, id, original)
+		if err != nil {
+			t.Fatalf("GetCarAsOf original: %v", err)
+		}
+		expectCar(t, "GetCarAsOf original", got, created)
+		if got.Engine != created.Engine {
+			t.Errorf("GetCarAsOf original engine = %+v, want %+v", got.Engine, created.Engine)
+		}
+		got, err = s.Cars.GetCarAsOf(ctx, id, changed)
+		if err != nil {
+			t.Fatalf("GetCarAsOf changed: %v", err)
+		}
+		expectCar(t, "GetCarAsOf changed", got, updated)
+		if got.Engine != updated.Engine {
+			t.Errorf("GetCarAsOf changed engine = %+v, want %+v", got.Engine, updated.Engine)
+		}
+		_, err = s.Cars.GetCarAsOf(ctx, id, trashed)
+		expectKind(t, "GetCarAsOf while in the trash", err, apperrors.KindNotFound)
+		_, err = s.Cars.GetCarAsOf(ctx, id, time.Now())
+		expectKind(t, "GetCarAsOf after the purge", err, apperrors.KindNotFound)
+	})
+
+	t.Run("GetCarVersion", func(t *testing.T) {
+		ctx := context.Background()
+		s := newStores(t)
+		engine := mustCreateEngine(t, s, 1199, 3, 500)
+		created := mustCreateCar(t, s, "Nexon", "Tata", engine)
+		id := created.ID.String()
+		req := carRequest("Punch", "Tata", engine)
+		if _, err := s.Cars.UpdateCar(ctx, id, &req, 0); err != nil {
+			t.Fatalf("UpdateCar: %v", err)
+		}
+		if _, err := s.Engines.EngineUpdate(ctx, engine.EngineID.String(), &models.EngineRequest{Displacement: 1497, NoOfCylinders: 4, CarRange: 600}, 0); err != nil {
+			t.Fatalf("EngineUpdate: %v", err)
+		}
+
+		got, err := s.Cars.GetCarVersion(ctx, id, 1)
+		if err != nil {
+			t.Fatalf("GetCarVersion: %v", err)
+		}
+		expectCar(t, "GetCarVersion 1", got, created)
+		if got.Engine != created.Engine {
+			t.Errorf("GetCarVersion 1 engine = %+v, want %+v", got.Engine, created.Engine)
+		}
+		_, err = s.Cars.GetCarVersion(ctx, id, 3)
+		expectKind(t, "GetCarVersion of a version not reached", err, apperrors.KindNotFound)
+		_, err = s.Cars.GetCarVersion(ctx, "not-a-uuid", 1)
+		expectKind(t, "GetCarVersion invalid id", err, apperrors.KindValidation)
+	})
+
+	t.Run("ListCars", func(t *testing.T) { runListCarsTests(t, newStores) })
+}
+
+// moment returns the current time, keeping it a few milliseconds clear of
+// the writes made just before and just after so that they fall on either
+// side of it.
+func moment() time.Time {
+	time.Sleep(10 * time.Millisecond)
+	now := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	return now
+}
+
+func runListCarsTests(t *testing.T, newStores Factory) {
+	ctx := context.Background()
+	s := newStores(t)
+	engine := mustCreateEngine(t, s, 1998, 4, 550)
+	var cars []models.Car
+	for i, name := range []string{"Alto", "Baleno", "Celerio", "Dzire", "Ertiga"} {
+		req := carRequest(name, "Maruti", engine)
+		req.Price = float64(10000 + (i%2)*5000)
+		car, err := s.Cars.CreateCar(ctx, &req)
+		if err != nil {
+			t.Fatalf("CreateCar: %v", err)
+		}
+		cars = append(cars, car)
+	}
+	// -price, name: Baleno, Dzire (15000) then Alto, Celerio, Ertiga (10000).
+	order := []models.SortField{{Field: "price", Desc: true}, {Field: "name"}}
+	want := []uuid.UUID{cars[1].ID, cars[3].ID, cars[0].ID, cars[2].ID, cars[4].ID}
+
+	all, err := s.Cars.ListCars(ctx, models.CarListQuery{Sort: order, Limit: 10})
+	if err != nil {
+		t.Fatalf("ListCars: %v", err)
+	}
+	expectOrder(t, "ListCars", all, want...)
+	if len(all) > 0 && all[0].Engine != engine {
+		t.Errorf("ListCars engine = %+v, want %+v", all[0].Engine, engine)
+	}
+
+	page, err := s.Cars.ListCars(ctx, models.CarListQuery{Sort: order, Limit: 2, After: &all[1]})
+	if err != nil {
+		t.Fatalf("ListCars after: %v", err)
+	}
+	expectOrder(t, "ListCars after", page, want[2], want[3])
+
+	page, err = s.Cars.ListCars(ctx, models.CarListQuery{Sort: order, Limit: 2, Before: &all[3]})
+	if err != nil {
+		t.Fatalf("ListCars before: %v", err)
+	}
+	expectOrder(t, "ListCars before", page, want[1], want[2])
+
+	page, err = s.Cars.ListCars(ctx, models.CarListQuery{Sort: order, Limit: 2, After: &all[4]})
+	if err != nil {
+		t.Fatalf("ListCars past the end: %v", err)
+	}
+	expectOrder(t, "ListCars past the end", page)
+
+	minPrice, maxYear := 12000.0, 2030
+	filtered, err := s.Cars.ListCars(ctx, models.CarListQuery{
+		Filter: models.CarFilter{PriceMin: &minPrice, YearMax: &maxYear, NameContains: "EN", FuelTypes: []string{"Petrol"}},
+		Sort:   order,
+		Limit:  10,
+	})
+	if err != nil {
+		t.Fatalf("ListCars filtered: %v", err)
+	}
+	expectOrder(t, "ListCars filtered", filtered, cars[1].ID)
+
+	bigEngine := int64(3000)
+	filtered, err = s.Cars.ListCars(ctx, models.CarListQuery{
+		Filter: models.CarFilter{DisplacementMin: &bigEngine},
+		Sort:   order,
+		Limit:  10,
+	})
+	if err != nil {
+		t.Fatalf("ListCars engine filter: %v", err)
+	}
+	expectOrder(t, "ListCars engine filter", filtered)
+
+	expr, err := filterexpr.Parse(`fuel_type in ("Petrol", "Hybrid") and (price >= 15000 and name contains "IRE" or engine.carRange > 10000)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filtered, err = s.Cars.ListCars(ctx, models.CarListQuery{Filter: models.CarFilter{Expr: expr}, Sort: order, Limit: 10})
+	if err != nil {
+		t.Fatalf("ListCars expression: %v", err)
+	}
+	expectOrder(t, "ListCars expression", filtered, cars[3].ID)
+}
+
+// RunTxTests checks that store calls made through TxManager.WithinTx are
+// committed or rolled back together.
+func RunTxTests(t *testing.T, newStores Factory) {
+	t.Run("Commit", func(t *testing.T) {
+		ctx := context.Background()
+		s := newStores(t)
+		var car models.Car
+		err := s.Tx.WithinTx(ctx, func(ctx context.Context) error {
+			engine, err := s.Engines.EngineCreate(ctx, &models.EngineRequest{Displacement: 1199, NoOfCylinders: 3, CarRange: 500})
+			if err != nil {
+				return err
+			}
+			req := carRequest("Nexon", "Tata", engine)
+			car, err = s.Cars.CreateCar(ctx, &req)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("WithinTx: %v", err)
+		}
+		got, err := s.Cars.GetCarById(ctx, car.ID.String(), models.AllFields)
+		if err != nil {
+			t.Fatalf("GetCarById after commit: %v", err)
+		}
+		if got.Engine != car.Engine {
+			t.Errorf("committed car engine = %+v, want %+v", got.Engine, car.Engine)
+		}
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		ctx := context.Background()
+		s := newStores(t)
+		var engine models.Engine
+		err := s.Tx.WithinTx(ctx, func(ctx context.Context) error {
+			var err error
+			engine, err = s.Engines.EngineCreate(ctx, &models.EngineRequest{Displacement: 1199, NoOfCylinders: 3, CarRange: 500})
+			if err != nil {
+				return err
+			}
+			req := carRequest("Nexon", "Tata", models.Engine{EngineID: uuid.New()})
+			_, err = s.Cars.CreateCar(ctx, &req)
+			return err
+		})
+		expectKind(t, "WithinTx", err, apperrors.KindValidation)
+		_, err = s.Engines.EngineById(ctx, engine.EngineID.String(), models.AllFields)
+		expectKind(t, "EngineById after rollback", err, apperrors.KindNotFound)
+	})
+
+	t.Run("Nested", func(t *testing.T) {
+		ctx := context.Background()
+		s := newStores(t)
+		var kept, undone models.Engine
+		err := s.Tx.WithinTx(ctx, func(ctx context.Context) error {
+			var err error
+			kept, err = s.Engines.EngineCreate(ctx, &models.EngineRequest{Displacement: 1199, NoOfCylinders: 3, CarRange: 500})
+			if err != nil {
+				return err
+			}
+			innerErr := s.Tx.WithinTx(ctx, func(ctx context.Context) error {
+				undone, err = s.Engines.EngineCreate(ctx, &models.EngineRequest{Displacement: 1497, NoOfCylinders: 4, CarRange: 600})
+				if err != nil {
+					return err
+				}
+				return apperrors.Conflict("inner unit of work failed")
+			})
+			expectKind(t, "inner WithinTx", innerErr, apperrors.KindConflict)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("WithinTx: %v", err)
+		}
+		if _, err := s.Engines.EngineById(ctx, kept.EngineID.String(), models.AllFields); err != nil {
+			t.Errorf("outer work should be committed: %v", err)
+		}
+		_, err = s.Engines.EngineById(ctx, undone.EngineID.String(), models.AllFields)
+		expectKind(t, "EngineById of the inner engine", err, apperrors.KindNotFound)
+	})
+}
+
+// RunAuditStoreTests checks recording and paging through histories.
+func RunAuditStoreTests(t *testing.T, newStores Factory) {
+	t.Run("RecordAndList", func(t *testing.T) {
+		ctx := context.Background()
+		s := newStores(t)
+		id := uuid.New()
+		at := time.Now().UTC().Truncate(time.Millisecond)
+		recorded, err := s.Audit.RecordAudit(ctx, models.AuditEntry{
+			EntityType: models.AuditCar,
+			EntityID:   id,
+			Action:     models.AuditUpdate,
+			Actor:      "alice",
+			At:         at,
+			Version:    2,
+			Changes:    map[string]models.FieldChange{"name": {Before: "Nexon", After: "Punch"}},
+		})
+		if err != nil {
+			t.Fatalf("RecordAudit: %v", err)
+		}
+		if recorded.Seq == 0 {
+			t.Error("RecordAudit did not assign a Seq")
+		}
+		entries, err := s.Audit.ListAudit(ctx, models.AuditListQuery{EntityType: models.AuditCar, EntityID: id, Limit: 10})
+		if err != nil {
+			t.Fatalf("ListAudit: %v", err)
+		}
+		if len(entries) != 1 {
+			t.Fatalf("ListAudit returned %d entries, want 1", len(entries))
+		}
+		got := entries[0]
+		if got.Seq != recorded.Seq || got.EntityID != id || got.Action != models.AuditUpdate || got.Actor != "alice" || got.Version != 2 || !sameTime(got.At, at) {
+			t.Errorf("ListAudit entry = %+v, want %+v", got, recorded)
+		}
+		if change := got.Changes["name"]; change.Before != "Nexon" || change.After != "Punch" || len(got.Changes) != 1 {
+			t.Errorf("ListAudit changes = %v, want name from Nexon to Punch", got.Changes)
+		}
+	})
+
+	t.Run("FiltersByEntity", func(t *testing.T) {
+		ctx := context.Background()
+		s := newStores(t)
+		id := uuid.New()
+		want := mustRecordAudit(t, s, models.AuditCar, id)
+		mustRecordAudit(t, s, models.AuditCar, uuid.New())
+		mustRecordAudit(t, s, models.AuditEngine, id)
+		entries, err := s.Audit.ListAudit(ctx, models.AuditListQuery{EntityType: models.AuditCar, EntityID: id, Limit: 10})
+		if err != nil {
+			t.Fatalf("ListAudit: %v", err)
+		}
+		expectSeqs(t, "ListAudit", entries, want.Seq)
+	})
+
+	t.Run("Paging", func(t *testing.T) {
+		ctx := context.Background()
+		s := newStores(t)
+		id := uuid.New()
+		var seqs []int64
+		for i := 0; i < 5; i++ {
+			seqs = append(seqs, mustRecordAudit(t, s, models.AuditEngine, id).Seq)
+			mustRecordAudit(t, s, models.AuditEngine, uuid.New())
+		}
+		query := models.AuditListQuery{EntityType: models.AuditEngine, EntityID: id, Limit: 2}
+		entries, err := s.Audit.ListAudit(ctx, query)
+		if err != nil {
+			t.Fatalf("ListAudit: %v", err)
+		}
+		expectSeqs(t, "first page", entries, seqs[4], seqs[3])
+
+		query.After = &seqs[3]
+		entries, err = s.Audit.ListAudit(ctx, query)
+		if err != nil {
+			t.Fatalf("ListAudit after: %v", err)
+		}
+		expectSeqs(t, "page after", entries, seqs[2], seqs[1])
+
+		query.After, query.Before = nil, &seqs[1]
+		entries, err = s.Audit.ListAudit(ctx, query)
+		if err != nil {
+			t.Fatalf("ListAudit before: %v", err)
+		}
+		expectSeqs(t, "page before", entries, seqs[3], seqs[2])
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		ctx := context.Background()
+		s := newStores(t)
+		id := uuid.New()
+		kept := mustRecordAudit(t, s, models.AuditCar, id)
+		err := s.Tx.WithinTx(ctx, func(ctx context.Context) error {
+			if _, err := s.Audit.RecordAudit(ctx, models.AuditEntry{EntityType: models.AuditCar, EntityID: id, Action: models.AuditDelete, Actor: "alice", Version: 2}); err != nil {
+				return err
+			}
+			return apperrors.Conflict("unit of work failed")
+		})
+		expectKind(t, "WithinTx", err, apperrors.KindConflict)
+		entries, err := s.Audit.ListAudit(ctx, models.AuditListQuery{EntityType: models.AuditCar, EntityID: id, Limit: 10})
+		if err != nil {
+			t.Fatalf("ListAudit: %v", err)
+		}
+		expectSeqs(t, "ListAudit after rollback", entries, kept.Seq)
+	})
+}
+
+func RunIdempotencyStoreTests(t *testing.T, newStores Factory) {
+	t.Run("ReserveAndComplete", func(t *testing.T) {
+		ctx := context.Background()
+		s := newStores(t)
+		record := models.IdempotencyRecord{Actor: "alice", Key: "order-1", Fingerprint: "a", ExpiresAt: time.Now().Add(time.Minute)}
+		reserved := mustReserve(t, s, record, true)
+		if reserved.Response != nil {
+			t.Errorf("reserved record has response %+v, want none", reserved.Response)
+		}
+
+		retry := mustReserve(t, s, models.IdempotencyRecord{Actor: "alice", Key: "order-1", Fingerprint: "b", ExpiresAt: time.Now().Add(time.Minute)}, false)
+		if retry.Fingerprint != "a" || retry.Response != nil {
+			t.Errorf("Reserve while running = %+v, want the running record", retry)
+		}
+
+		response := models.StoredResponse{
+			Status: 201,
+			Header: map[string][]string{"Content-Type": {"application/json"}},
+			Body:   []byte(`{"id":1}`),
+		}
+		if err := s.Idempotency.CompleteIdempotencyKey(ctx, "alice", "order-1", response, time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("CompleteIdempotencyKey: %v", err)
+		}
+		done := mustReserve(t, s, record, false)
+		if done.Response == nil || done.Response.Status != 201 || string(done.Response.Body) != `{"id":1}` ||
+			len(done.Response.Header["Content-Type"]) != 1 || done.Response.Header["Content-Type"][0] != "application/json" {
+			t.Errorf("Reserve after completion = %+v, want the stored response", done.Response)
+		}
+
+		err := s.Idempotency.CompleteIdempotencyKey(ctx, "alice", "missing", response, time.Now().Add(time.Hour))
+		expectKind(t, "CompleteIdempotencyKey missing", err, apperrors.KindNotFound)
+	})
+
+	t.Run("Extend", func(t *testing.T) {
+		ctx := context.Background()
+		s := newStores(t)
+		mustReserve(t, s, models.IdempotencyRecord{Actor: "alice", Key: "import", Fingerprint: "a", ExpiresAt: time.Now().Add(time.Second)}, true)
+		if err := s.Idempotency.ExtendIdempotencyKey(ctx, "alice", "import", time.Now().Add(-time.Second)); err != nil {
+			t.Fatalf("ExtendIdempotencyKey: %v", err)
+		}
+		mustReserve(t, s, models.IdempotencyRecord{Actor: "alice", Key: "import", Fingerprint: "b", ExpiresAt: time.Now().Add(time.Minute)}, true)
+		if err := s.Idempotency.ExtendIdempotencyKey(ctx, "alice", "import", time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("ExtendIdempotencyKey: %v", err)
+		}
+		if err := s.Idempotency.CompleteIdempotencyKey(ctx, "alice", "import", models.StoredResponse{Status: 201}, time.Now().Add(-time.Second)); err != nil {
+			t.Fatalf("CompleteIdempotencyKey: %v", err)
+		}
+		if err := s.Idempotency.ExtendIdempotencyKey(ctx, "alice", "import", time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("ExtendIdempotencyKey: %v", err)
+		}
+		mustReserve(t, s, models.IdempotencyRecord{Actor: "alice", Key: "import", Fingerprint: "c", ExpiresAt: time.Now().Add(time.Minute)}, true)
+		if err := s.Idempotency.ExtendIdempotencyKey(ctx, "alice", "missing", time.Now().Add(time.Hour)); err != nil {
+			t.Errorf("ExtendIdempotencyKey of a missing key: %v", err)
+		}
+	})
+
+	t.Run("KeysPerActor", func(t *testing.T) {
+		ctx := context.Background()
+		s := newStores(t)
+		mustReserve(t, s, models.IdempotencyRecord{Actor: "alice", Key: "order-1", Fingerprint: "a", ExpiresAt: time.Now().Add(time.Minute)}, true)
+		response := models.StoredResponse{Status: 201, Body: []byte(`{"id":1}`)}
+		if err := s.Idempotency.CompleteIdempotencyKey(ctx, "alice", "order-1", response, time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("CompleteIdempotencyKey: %v", err)
+		}
+		bob := mustReserve(t, s, models.IdempotencyRecord{Actor: "bob", Key: "order-1", Fingerprint: "b", ExpiresAt: time.Now().Add(time.Minute)}, true)
+		if bob.Response != nil {
+			t.Errorf("Reserve of another actor's key = %+v, want a new record", bob)
+		}
+		if err := s.Idempotency.ReleaseIdempotencyKey(ctx, "bob", "order-1"); err != nil {
+			t.Fatalf("ReleaseIdempotencyKey: %v", err)
+		}
+		alice := mustReserve(t, s, models.IdempotencyRecord{Actor: "alice", Key: "order-1", Fingerprint: "a", ExpiresAt: time.Now().Add(time.Minute)}, false)
+		if alice.Response == nil || alice.Response.Status != 201 {
+			t.Errorf("another actor's release should leave the record alone, got %+v", alice)
+		}
+	})
+
+	t.Run("ReleaseAndExpiry", func(t *testing.T) {
+		ctx := context.Background()
+		s := newStores(t)
+		mustReserve(t, s, models.IdempotencyRecord{Actor: "alice", Key: "k", Fingerprint: "a", ExpiresAt: time.Now().Add(time.Minute)}, true)
+		if err := s.Idempotency.ReleaseIdempotencyKey(ctx, "alice", "k"); err != nil {
+			t.Fatalf("ReleaseIdempotencyKey: %v", err)
+		}
+		mustReserve(t, s, models.IdempotencyRecord{Actor: "alice", Key: "k", Fingerprint: "b", ExpiresAt: time.Now().Add(-time.Second)}, true)
+		taken := mustReserve(t, s, models.IdempotencyRecord{Actor: "alice", Key: "k", Fingerprint: "c", ExpiresAt: time.Now().Add(time.Minute)}, true)
+		if taken.Fingerprint != "c" {
+			t.Errorf("Reserve over an expired record = %+v, want fingerprint c", taken)
+		}
+	})
+
+	t.Run("Purge", func(t *testing.T) {
+		ctx := context.Background()
+		s := newStores(t)
+		mustReserve(t, s, models.IdempotencyRecord{Actor: "alice", Key: "old", Fingerprint: "a", ExpiresAt: time.Now().Add(-time.Minute)}, true)
+		mustReserve(t, s, models.IdempotencyRecord{Actor: "alice", Key: "new", Fingerprint: "a", ExpiresAt: time.Now().Add(time.Minute)}, true)
+		purged, err := s.Idempotency.PurgeIdempotencyKeys(ctx, time.Now())
+		if err != nil {
+			t.Fatalf("PurgeIdempotencyKeys: %v", err)
+		}
+		if purged != 1 {
+			t.Errorf("PurgeIdempotencyKeys purged %d, want 1", purged)
+		}
+		mustReserve(t, s, models.IdempotencyRecord{Actor: "alice", Key: "new", Fingerprint: "a", ExpiresAt: time.Now().Add(time.Minute)}, false)
+	})
+}
+
+func mustReserve(t *testing.T, s Stores, record models.IdempotencyRecord, wantReserved bool) models.IdempotencyRecord {
+	t.Helper()
+	got, reserved, err := s.Idempotency.ReserveIdempotencyKey(context.Background(), record)
+	if err != nil {
+		t.Fatalf("ReserveIdempotencyKey(%s): %v", record.Key, err)
+	}
+	if reserved != wantReserved {
+		t.Fatalf("ReserveIdempotencyKey(%s) reserved = %v, want %v", record.Key, reserved, wantReserved)
+	}
+	return got
+}
+
+func mustRecordAudit(t *testing.T, s Stores, entityType string, id uuid.UUID) models.AuditEntry {
+	t.Helper()
+	entry, err := s.Audit.RecordAudit(context.Background(), models.AuditEntry{
+		EntityType: entityType,
+		EntityID:   id,
+		Action:     models.AuditCreate,
+		Actor:      "alice",
+		Version:    1,
+		Changes:    map[string]models.FieldChange{},
+	})
+	if err != nil {
+		t.Fatalf("RecordAudit: %v", err)
+	}
+	return entry
+}
+
+func expectSeqs(t *testing.T, op string, entries []models.AuditEntry, want ...int64) {
+	t.Helper()
+	got := make([]int64, len(entries))
+	for i, entry := range entries {
+		got[i] = entry.Seq
+	}
+	if len(got) != len(want) {
+		t.Errorf("%s seqs = %v, want %v", op, got, want)
+		return
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("%s seqs = %v, want %v", op, got, want)
+			return
+		}
+	}
+}
+
+func mustCreateEngine(t *testing.T, s Stores, displacement, cylinders, carRange int64) models.Engine {
+	t.Helper()
+	engine, err := s.Engines.EngineCreate(context.Background(), &models.EngineRequest{
+		Displacement:  displacement,
+		NoOfCylinders: cylinders,
+		CarRange:      carRange,
+	})
+	if err != nil {
+		t.Fatalf("EngineCreate: %v", err)
+	}
+	return engine
+}
+
+func mustCreateCar(t *testing.T, s Stores, name, brand string, engine models.Engine) models.Car {
+	t.Helper()
+	req := carRequest(name, brand, engine)
+	car, err := s.Cars.CreateCar(context.Background(), &req)
+	if err != nil {
+		t.Fatalf("CreateCar: %v", err)
+	}
+	return car
+}
+
+func carRequest(name, brand string, engine models.Engine) models.CarRequest {
+	return models.CarRequest{
+		Name:     name,
+		Year:     "2021",
+		Brand:    brand,
+		FuelType: "Petrol",
+		Engine:   engine,
+		Price:    15000,
+	}
+}
+
+func expectKind(t *testing.T, op string, err error, want apperrors.Kind) {
+	t.Helper()
+	if err == nil {
+		t.Errorf("%s: expected a %s error, got nil", op, want)
+		return
+	}
+	if got := apperrors.KindOf(err); got != want {
+		t.Errorf("%s: error kind = %s, want %s (%v)", op, got, want, err)
+	}
+}
+
+func expectCar(t *testing.T, op string, got, want models.Car) {
+	t.Helper()
+	if got.ID != want.ID || got.Name != want.Name || got.Year != want.Year || got.Brand != want.Brand ||
+		got.FuelType != want.FuelType || got.Price != want.Price || got.Engine.EngineID != want.Engine.EngineID ||
+		got.Version != want.Version {
+		t.Errorf("%s = %+v, want %+v", op, got, want)
+	}
+	if !sameTime(got.CreatedAt, want.CreatedAt) || !sameTime(got.UpdatedAt, want.UpdatedAt) {
+		t.Errorf("%s timestamps = %v / %v, want %v / %v", op, got.CreatedAt, got.UpdatedAt, want.CreatedAt, want.UpdatedAt)
+	}
+}
+
+func expectIDs(t *testing.T, op string, cars []models.Car, want ...uuid.UUID) {
+	t.Helper()
+	if len(cars) != len(want) {
+		t.Fatalf("%s returned %d cars, want %d", op, len(cars), len(want))
+	}
+	seen := make(map[uuid.UUID]bool, len(cars))
+	for _, car := range cars {
+		seen[car.ID] = true
+	}
+	for _, id := range want {
+		if !seen[id] {
+			t.Errorf("%s is missing car %s", op, id)
+		}
+	}
+}
+
+func expectOrder(t *testing.T, op string, cars []models.Car, want ...uuid.UUID) {
+	t.Helper()
+	got := make([]uuid.UUID, len(cars))
+	for i, car := range cars {
+		got[i] = car.ID
+	}
+	if len(got) != len(want) {
+		t.Fatalf("%s returned %v, want %v", op, got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("%s returned %v, want %v", op, got, want)
+		}
+	}
+}
+
+func expectEngines(t *testing.T, op string, got []models.Engine, want ...models.Engine) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s returned %+v, want %+v", op, got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("%s returned %+v, want %+v", op, got, want)
+		}
+	}
+}
+
+// sameTime compares timestamps at the microsecond precision Postgres keeps.
+func sameTime(a, b time.Time) bool {
+	return a.Round(time.Microsecond).Equal(b.Round(time.Microsecond))
+}